@@ -0,0 +1,566 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"go/types"
+	"sort"
+)
+
+// APIChange is a change of the exported API of a package.
+type APIChange struct {
+	// Name of the changed identifier, qualified by its type for fields and
+	// methods, like "T.Field".
+	Name string
+	// Breaking is set if the change can break code using the package.
+	Breaking bool
+	// Message describes the change, like "removed" or "changed from int to
+	// string".
+	Message string
+}
+
+// APIDiff is the difference between the exported APIs of two builds of a
+// package.
+type APIDiff struct {
+	// Path of the package.
+	Path string
+	// Changes sorted by Name.
+	Changes []APIChange
+}
+
+// CompareArchives compares the API of the package path compiled into the
+// archives or object files oldFile and newFile.
+func CompareArchives(oldFile, newFile, path string) (*APIDiff, error) {
+	oldPkg, err := ReadPackage(token.NewFileSet(), oldFile, path)
+	if err != nil {
+		return nil, err
+	}
+	newPkg, err := ReadPackage(token.NewFileSet(), newFile, path)
+	if err != nil {
+		return nil, err
+	}
+	return CompareAPI(oldPkg, newPkg), nil
+}
+
+// CompareAPI compares the exported API of two versions of a package. Types
+// are compared by name, so the packages can come from different importers.
+func CompareAPI(oldPkg, newPkg *types.Package) *APIDiff {
+	d := &APIDiff{Path: newPkg.Path()}
+	c := apiComparer{diff: d, oldPkg: oldPkg, newPkg: newPkg}
+	for _, name := range oldPkg.Scope().Names() {
+		o := oldPkg.Scope().Lookup(name)
+		if !o.Exported() {
+			continue
+		}
+		n := newPkg.Scope().Lookup(name)
+		if n == nil || !n.Exported() {
+			d.add(name, true, "removed")
+			continue
+		}
+		c.object(name, o, n)
+	}
+	for _, name := range newPkg.Scope().Names() {
+		if n := newPkg.Scope().Lookup(name); n.Exported() && oldPkg.Scope().Lookup(name) == nil {
+			d.add(name, false, "added")
+		}
+	}
+	sort.SliceStable(d.Changes, func(i, j int) bool { return d.Changes[i].Name < d.Changes[j].Name })
+	return d
+}
+
+// Breaking reports whether any change is breaking.
+func (d *APIDiff) Breaking() bool {
+	for _, v := range d.Changes {
+		if v.Breaking {
+			return true
+		}
+	}
+	return false
+}
+
+// String renders the changes as text, breaking changes first.
+func (d *APIDiff) String() string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "%s\n", d.Path)
+	for _, breaking := range []bool{true, false} {
+		title := "Compatible changes:"
+		if breaking {
+			title = "Incompatible changes:"
+		}
+		first := true
+		for _, v := range d.Changes {
+			if v.Breaking != breaking {
+				continue
+			}
+			if first {
+				fmt.Fprintf(buf, "%s\n", title)
+				first = false
+			}
+			fmt.Fprintf(buf, "- %s: %s\n", v.Name, v.Message)
+		}
+	}
+	return buf.String()
+}
+
+func (d *APIDiff) add(name string, breaking bool, format string, args ...interface{}) {
+	d.Changes = append(d.Changes, APIChange{Name: name, Breaking: breaking, Message: fmt.Sprintf(format, args...)})
+}
+
+type apiComparer struct {
+	diff           *APIDiff
+	oldPkg, newPkg *types.Package
+}
+
+// qualifier leaves the types of the compared package unqualified.
+func (c *apiComparer) qualifier(p *types.Package) string {
+	if p.Path() == c.newPkg.Path() || p.Path() == c.oldPkg.Path() {
+		return ""
+	}
+	return p.Path()
+}
+
+// typeString returns t with the types of the compared package unqualified
+// and the parameters of the signatures unnamed.
+func (c *apiComparer) typeString(t types.Type) string {
+	buf := &bytes.Buffer{}
+	c.writeType(buf, t)
+	return buf.String()
+}
+
+func (c *apiComparer) writeType(buf *bytes.Buffer, t types.Type) {
+	switch t := t.(type) {
+	case *types.Pointer:
+		buf.WriteString("*")
+		c.writeType(buf, t.Elem())
+	case *types.Slice:
+		buf.WriteString("[]")
+		c.writeType(buf, t.Elem())
+	case *types.Array:
+		fmt.Fprintf(buf, "[%d]", t.Len())
+		c.writeType(buf, t.Elem())
+	case *types.Map:
+		buf.WriteString("map[")
+		c.writeType(buf, t.Key())
+		buf.WriteString("]")
+		c.writeType(buf, t.Elem())
+	case *types.Chan:
+		switch t.Dir() {
+		case types.SendOnly:
+			buf.WriteString("chan<- ")
+		case types.RecvOnly:
+			buf.WriteString("<-chan ")
+		default:
+			buf.WriteString("chan ")
+		}
+		if e, ok := t.Elem().(*types.Chan); ok && e.Dir() == types.RecvOnly && t.Dir() == types.SendRecv {
+			buf.WriteString("(")
+			c.writeType(buf, e)
+			buf.WriteString(")")
+			return
+		}
+		c.writeType(buf, t.Elem())
+	case *types.Signature:
+		buf.WriteString("func")
+		if t.TypeParams().Len() > 0 {
+			c.writeTypeParams(buf, t.TypeParams())
+		}
+		buf.WriteString("(")
+		for i := 0; i < t.Params().Len(); i++ {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			p := t.Params().At(i).Type()
+			if s, ok := p.(*types.Slice); ok && t.Variadic() && i == t.Params().Len()-1 {
+				buf.WriteString("...")
+				p = s.Elem()
+			}
+			c.writeType(buf, p)
+		}
+		buf.WriteString(")")
+		switch results := t.Results(); results.Len() {
+		case 0:
+		case 1:
+			buf.WriteString(" ")
+			c.writeType(buf, results.At(0).Type())
+		default:
+			buf.WriteString(" (")
+			for i := 0; i < results.Len(); i++ {
+				if i > 0 {
+					buf.WriteString(", ")
+				}
+				c.writeType(buf, results.At(i).Type())
+			}
+			buf.WriteString(")")
+		}
+	default:
+		buf.WriteString(types.TypeString(t, c.qualifier))
+	}
+}
+
+// writeTypeParams writes the type parameters and their constraints, like
+// "[K comparable, V any]".
+func (c *apiComparer) writeTypeParams(buf *bytes.Buffer, list *types.TypeParamList) {
+	buf.WriteString("[")
+	for i := 0; i < list.Len(); i++ {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(list.At(i).Obj().Name() + " ")
+		c.writeType(buf, list.At(i).Constraint())
+	}
+	buf.WriteString("]")
+}
+
+func (c *apiComparer) changed(name string, o, n types.Type) {
+	if !identical(o, n) {
+		c.diff.add(name, true, "changed from %s to %s", c.typeString(o), c.typeString(n))
+	}
+}
+
+// identical reports whether o and n are identical like types.Identical does,
+// the named types of the two packages, loaded by different importers, matched
+// by package path and name. The names of the parameters and type parameters
+// are ignored.
+func identical(o, n types.Type) bool {
+	o, n = types.Unalias(o), types.Unalias(n)
+	switch o := o.(type) {
+	case *types.Basic:
+		n, ok := n.(*types.Basic)
+		return ok && o.Kind() == n.Kind()
+	case *types.Pointer:
+		n, ok := n.(*types.Pointer)
+		return ok && identical(o.Elem(), n.Elem())
+	case *types.Slice:
+		n, ok := n.(*types.Slice)
+		return ok && identical(o.Elem(), n.Elem())
+	case *types.Array:
+		n, ok := n.(*types.Array)
+		return ok && o.Len() == n.Len() && identical(o.Elem(), n.Elem())
+	case *types.Map:
+		n, ok := n.(*types.Map)
+		return ok && identical(o.Key(), n.Key()) && identical(o.Elem(), n.Elem())
+	case *types.Chan:
+		n, ok := n.(*types.Chan)
+		return ok && o.Dir() == n.Dir() && identical(o.Elem(), n.Elem())
+	case *types.Signature:
+		n, ok := n.(*types.Signature)
+		return ok && o.Variadic() == n.Variadic() && identicalTypeParams(o.TypeParams(), n.TypeParams()) &&
+			identicalTuples(o.Params(), n.Params()) && identicalTuples(o.Results(), n.Results())
+	case *types.Struct:
+		n, ok := n.(*types.Struct)
+		if !ok || o.NumFields() != n.NumFields() {
+			return false
+		}
+		for i := 0; i < o.NumFields(); i++ {
+			of, nf := o.Field(i), n.Field(i)
+			if of.Name() != nf.Name() || of.Embedded() != nf.Embedded() || o.Tag(i) != n.Tag(i) || !identical(of.Type(), nf.Type()) {
+				return false
+			}
+		}
+		return true
+	case *types.Interface:
+		n, ok := n.(*types.Interface)
+		if !ok || o.NumMethods() != n.NumMethods() {
+			return false
+		}
+		// The methods are sorted.
+		for i := 0; i < o.NumMethods(); i++ {
+			om, nm := o.Method(i), n.Method(i)
+			if om.Name() != nm.Name() || !identical(om.Type(), nm.Type()) {
+				return false
+			}
+		}
+		return identicalTypeSets(o, n)
+	case *types.Named:
+		n, ok := n.(*types.Named)
+		if !ok || o.Obj().Name() != n.Obj().Name() || pkgPath(o.Obj()) != pkgPath(n.Obj()) {
+			return false
+		}
+		return identicalTypeLists(o.TypeArgs(), n.TypeArgs())
+	case *types.TypeParam:
+		n, ok := n.(*types.TypeParam)
+		return ok && o.Index() == n.Index()
+	}
+	return types.TypeString(o, nil) == types.TypeString(n, nil)
+}
+
+func pkgPath(o types.Object) string {
+	if o.Pkg() == nil {
+		return ""
+	}
+	return o.Pkg().Path()
+}
+
+func identicalTypeLists(o, n *types.TypeList) bool {
+	if o.Len() != n.Len() {
+		return false
+	}
+	for i := 0; i < o.Len(); i++ {
+		if !identical(o.At(i), n.At(i)) {
+			return false
+		}
+	}
+	return true
+}
+
+// identicalTypeParams reports whether the type parameters have identical
+// constraints.
+func identicalTypeParams(o, n *types.TypeParamList) bool {
+	if o.Len() != n.Len() {
+		return false
+	}
+	for i := 0; i < o.Len(); i++ {
+		if !identical(o.At(i).Constraint(), n.At(i).Constraint()) {
+			return false
+		}
+	}
+	return true
+}
+
+// identicalTypeSets reports whether the interfaces restrict their type sets
+// identically, beyond their methods.
+func identicalTypeSets(o, n *types.Interface) bool {
+	if o.IsComparable() != n.IsComparable() {
+		return false
+	}
+	ot, nt := typeTerms(o), typeTerms(n)
+	if len(ot) != len(nt) {
+		return false
+	}
+	for i := range ot {
+		if !identicalUnions(ot[i], nt[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// typeTerms returns the unions of the type elements embedded in i, those of
+// the embedded interfaces included, a single type being a union of one term.
+func typeTerms(i *types.Interface) [][]*types.Term {
+	unions := [][]*types.Term(nil)
+	for k := 0; k < i.NumEmbeddeds(); k++ {
+		switch e := types.Unalias(i.EmbeddedType(k)).(type) {
+		case *types.Union:
+			terms := []*types.Term(nil)
+			for t := 0; t < e.Len(); t++ {
+				terms = append(terms, e.Term(t))
+			}
+			unions = append(unions, terms)
+		default:
+			if ei, ok := e.Underlying().(*types.Interface); ok {
+				unions = append(unions, typeTerms(ei)...)
+			} else {
+				unions = append(unions, []*types.Term{types.NewTerm(false, e)})
+			}
+		}
+	}
+	return unions
+}
+
+// identicalUnions reports whether the unions have the same terms, in any
+// order.
+func identicalUnions(o, n []*types.Term) bool {
+	if len(o) != len(n) {
+		return false
+	}
+	for _, ot := range o {
+		found := false
+		for _, nt := range n {
+			if ot.Tilde() == nt.Tilde() && identical(ot.Type(), nt.Type()) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func identicalTuples(o, n *types.Tuple) bool {
+	if o.Len() != n.Len() {
+		return false
+	}
+	for i := 0; i < o.Len(); i++ {
+		if !identical(o.At(i).Type(), n.At(i).Type()) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *apiComparer) object(name string, o, n types.Object) {
+	switch o := o.(type) {
+	case *types.Const:
+		if _, ok := n.(*types.Const); !ok {
+			c.diff.add(name, true, "changed from const to %s", objectKind(n))
+			return
+		}
+		c.changed(name, o.Type(), n.Type())
+	case *types.Var:
+		if _, ok := n.(*types.Var); !ok {
+			c.diff.add(name, true, "changed from var to %s", objectKind(n))
+			return
+		}
+		c.changed(name, o.Type(), n.Type())
+	case *types.Func:
+		if _, ok := n.(*types.Func); !ok {
+			c.diff.add(name, true, "changed from func to %s", objectKind(n))
+			return
+		}
+		c.changed(name, o.Type(), n.Type())
+	case *types.TypeName:
+		nt, ok := n.(*types.TypeName)
+		if !ok {
+			c.diff.add(name, true, "changed from type to %s", objectKind(n))
+			return
+		}
+		c.typeName(name, o, nt)
+	}
+}
+
+func (c *apiComparer) typeName(name string, o, n *types.TypeName) {
+	if otp, ntp := typeParams(o.Type()), typeParams(n.Type()); !identicalTypeParams(otp, ntp) {
+		from, to := &bytes.Buffer{}, &bytes.Buffer{}
+		c.writeTypeParams(from, otp)
+		c.writeTypeParams(to, ntp)
+		c.diff.add(name, true, "type parameters changed from %s to %s", from, to)
+		return
+	}
+	ou, nu := o.Type().Underlying(), n.Type().Underlying()
+	switch ou := ou.(type) {
+	case *types.Struct:
+		nu, ok := nu.(*types.Struct)
+		if !ok {
+			c.changed(name, ou, n.Type().Underlying())
+			return
+		}
+		c.fields(name, ou, nu)
+	case *types.Interface:
+		nu, ok := nu.(*types.Interface)
+		if !ok || !identicalTypeSets(ou, nu) {
+			c.changed(name, ou, n.Type().Underlying())
+			return
+		}
+		c.interfaceMethods(name, ou, nu)
+		return
+	default:
+		c.changed(name, ou, nu)
+	}
+	c.methods(name, o.Type(), n.Type())
+}
+
+// fields compares the exported fields of two structs.
+func (c *apiComparer) fields(name string, o, n *types.Struct) {
+	fields := map[string]*types.Var{}
+	for i := 0; i < n.NumFields(); i++ {
+		fields[n.Field(i).Name()] = n.Field(i)
+	}
+	for i := 0; i < o.NumFields(); i++ {
+		of := o.Field(i)
+		if !of.Exported() {
+			continue
+		}
+		field := name + "." + of.Name()
+		nf, ok := fields[of.Name()]
+		if !ok || !nf.Exported() {
+			c.diff.add(field, true, "removed")
+			continue
+		}
+		c.changed(field, of.Type(), nf.Type())
+	}
+	for i := 0; i < n.NumFields(); i++ {
+		nf := n.Field(i)
+		if nf.Exported() && !hasExportedField(o, nf.Name()) {
+			c.diff.add(name+"."+nf.Name(), false, "added")
+		}
+	}
+}
+
+func hasExportedField(s *types.Struct, name string) bool {
+	for i := 0; i < s.NumFields(); i++ {
+		if s.Field(i).Name() == name && s.Field(i).Exported() {
+			return true
+		}
+	}
+	return false
+}
+
+// interfaceMethods compares the method sets of two interfaces. Any added
+// method breaks the implementations, unless the interface already had an
+// unexported method and so could not be implemented elsewhere.
+func (c *apiComparer) interfaceMethods(name string, o, n *types.Interface) {
+	sealed := false
+	for i := 0; i < o.NumMethods(); i++ {
+		if !o.Method(i).Exported() {
+			sealed = true
+		}
+	}
+	c.methodSets(name, methodsOf(o), methodsOf(n), !sealed)
+}
+
+// methods compares the method sets of two named types and their pointers.
+func (c *apiComparer) methods(name string, o, n types.Type) {
+	c.methodSets(name, methodsOf(types.NewPointer(o)), methodsOf(types.NewPointer(n)), false)
+}
+
+func (c *apiComparer) methodSets(name string, o, n map[string]*types.Func, addBreaks bool) {
+	for m, of := range o {
+		method := name + "." + m
+		nf, ok := n[m]
+		if !ok {
+			c.diff.add(method, true, "removed")
+			continue
+		}
+		c.changed(method, signatureOf(of), signatureOf(nf))
+	}
+	for m := range n {
+		if _, ok := o[m]; !ok {
+			c.diff.add(name+"."+m, addBreaks, "added")
+		}
+	}
+}
+
+// methodsOf returns the exported methods of the method set of t.
+func methodsOf(t types.Type) map[string]*types.Func {
+	methods := map[string]*types.Func{}
+	set := types.NewMethodSet(t)
+	for i := 0; i < set.Len(); i++ {
+		if f, ok := set.At(i).Obj().(*types.Func); ok && f.Exported() {
+			methods[f.Name()] = f
+		}
+	}
+	return methods
+}
+
+// typeParams returns the type parameters of the generic type t, nil if it is
+// not one.
+func typeParams(t types.Type) *types.TypeParamList {
+	if named, ok := t.(*types.Named); ok {
+		return named.TypeParams()
+	}
+	return nil
+}
+
+// signatureOf returns the signature of f without its receiver.
+func signatureOf(f *types.Func) types.Type {
+	sig := f.Type().(*types.Signature)
+	return types.NewSignature(nil, sig.Params(), sig.Results(), sig.Variadic())
+}
+
+func objectKind(o types.Object) string {
+	switch o.(type) {
+	case *types.Const:
+		return "const"
+	case *types.Var:
+		return "var"
+	case *types.Func:
+		return "func"
+	case *types.TypeName:
+		return "type"
+	}
+	return "object"
+}
@@ -0,0 +1,86 @@
+package build_test
+
+import (
+	gb "go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareArchives(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apicheck")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	archives := map[string]string{}
+	for _, v := range []string{"old", "new"} {
+		src, err := filepath.Abs(filepath.Join("testdata", "api", v))
+		require.NoError(t, err)
+		archives[v] = filepath.Join(dir, v+".a")
+		err = build.DefaultTools.Compile(build.CompileArgs{
+			Context:           gb.Default,
+			WorkingDirectory:  src,
+			Stderr:            os.Stderr,
+			Files:             []string{"api.go"},
+			OutputFile:        archives[v],
+			PackageImportPath: "example.com/api",
+			Pack:              true,
+			Complete:          true,
+		})
+		require.NoError(t, err)
+	}
+
+	diff, err := build.CompareArchives(archives["old"], archives["new"], "example.com/api")
+	require.NoError(t, err)
+	assert.True(t, diff.Breaking())
+	assert.Equal(t, []build.APIChange{
+		{Name: "Changed", Breaking: true, Message: "changed from type to var"},
+		{Name: "F", Breaking: true, Message: "changed from func(int) string to func(int, int) string"},
+		{Name: "Gen", Breaking: true, Message: "changed from func[T any](T) T to func[T comparable](T) T"},
+		{Name: "H", Breaking: false, Message: "added"},
+		{Name: "I.Close", Breaking: true, Message: "changed from func() to func() error"},
+		{Name: "I.Write", Breaking: true, Message: "added"},
+		{Name: "List", Breaking: true, Message: "type parameters changed from [T any] to [T comparable]"},
+		{Name: "Number", Breaking: true, Message: "changed from interface{~int} to interface{~int | ~string}"},
+		{Name: "Removed", Breaking: true, Message: "removed"},
+		{Name: "Sealed.Write", Breaking: false, Message: "added"},
+		{Name: "T.A", Breaking: true, Message: "changed from int to int64"},
+		{Name: "T.B", Breaking: true, Message: "removed"},
+		{Name: "T.C", Breaking: false, Message: "added"},
+		{Name: "T.P", Breaking: true, Message: "removed"},
+		{Name: "T.Q", Breaking: false, Message: "added"},
+	}, diff.Changes)
+	assert.Equal(t, `example.com/api
+Incompatible changes:
+- Changed: changed from type to var
+- F: changed from func(int) string to func(int, int) string
+- Gen: changed from func[T any](T) T to func[T comparable](T) T
+- I.Close: changed from func() to func() error
+- I.Write: added
+- List: type parameters changed from [T any] to [T comparable]
+- Number: changed from interface{~int} to interface{~int | ~string}
+- Removed: removed
+- T.A: changed from int to int64
+- T.B: removed
+- T.P: removed
+Compatible changes:
+- H: added
+- Sealed.Write: added
+- T.C: added
+- T.Q: added
+`, diff.String())
+
+	diff, err = build.CompareArchives(archives["old"], archives["old"], "example.com/api")
+	require.NoError(t, err)
+	assert.False(t, diff.Breaking())
+	assert.Empty(t, diff.Changes)
+	assert.Equal(t, "example.com/api\n", diff.String())
+
+	_, err = build.CompareArchives(filepath.Join(dir, "missing.a"), archives["new"], "example.com/api")
+	assert.Error(t, err)
+}
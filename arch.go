@@ -0,0 +1,245 @@
+package build
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Arch holds the sub-architecture and experiment settings of a build that
+// gb.Context does not carry. Empty settings are left to the toolchain
+// default.
+type Arch struct {
+	// GO386 is "sse2" or "softfloat", for GOARCH=386.
+	GO386 string
+	// GOAMD64 is "v1", "v2", "v3" or "v4", for GOARCH=amd64.
+	GOAMD64 string
+	// GOARM is "5", "6" or "7", optionally followed by ",softfloat" or
+	// ",hardfloat", for GOARCH=arm.
+	GOARM string
+	// GOARM64 is "v8.0" to "v8.9" or "v9.0" to "v9.5", optionally followed by
+	// ",lse" and/or ",crypto", for GOARCH=arm64.
+	GOARM64 string
+	// GOMIPS is "hardfloat" or "softfloat", for GOARCH=mips and mipsle.
+	GOMIPS string
+	// GOMIPS64 is "hardfloat" or "softfloat", for GOARCH=mips64 and mips64le.
+	GOMIPS64 string
+	// GOPPC64 is "power8", "power9" or "power10", for GOARCH=ppc64 and
+	// ppc64le.
+	GOPPC64 string
+	// GORISCV64 is "rva20u64", "rva22u64" or "rva23u64", for GOARCH=riscv64.
+	GORISCV64 string
+	// GOWASM is a comma separated list of "satconv" and "signext", for
+	// GOARCH=wasm.
+	GOWASM string
+	// GOEXPERIMENT is a comma separated list of experiments, each optionally
+	// prefixed with "no" to disable it.
+	GOEXPERIMENT string
+}
+
+// archSetting describes a sub-architecture variable.
+type archSetting struct {
+	name   string
+	value  func(a Arch) string
+	set    func(a *Arch, v string)
+	goarch []string
+	valid  func(v string) bool
+	usage  string
+}
+
+var archSettings = []archSetting{
+	{
+		"GO386", func(a Arch) string { return a.GO386 },
+		func(a *Arch, v string) { a.GO386 = v },
+		[]string{"386"},
+		oneOf("sse2", "softfloat"),
+		"must be sse2, softfloat",
+	},
+	{
+		"GOAMD64", func(a Arch) string { return a.GOAMD64 },
+		func(a *Arch, v string) { a.GOAMD64 = v },
+		[]string{"amd64"},
+		oneOf("v1", "v2", "v3", "v4"),
+		"must be v1, v2, v3, v4",
+	},
+	{
+		"GOARM", func(a Arch) string { return a.GOARM },
+		func(a *Arch, v string) { a.GOARM = v },
+		[]string{"arm"},
+		validGOARM,
+		`must start with 5, 6, or 7, and may optionally end in either ",hardfloat" or ",softfloat"`,
+	},
+	{
+		"GOARM64", func(a Arch) string { return a.GOARM64 },
+		func(a *Arch, v string) { a.GOARM64 = v },
+		[]string{"arm64"},
+		validGOARM64,
+		`must start with v8.{0-9} or v9.{0-5} and may optionally end in ",lse" and/or ",crypto"`,
+	},
+	{
+		"GOMIPS", func(a Arch) string { return a.GOMIPS },
+		func(a *Arch, v string) { a.GOMIPS = v },
+		[]string{"mips", "mipsle"},
+		oneOf("hardfloat", "softfloat"),
+		"must be hardfloat, softfloat",
+	},
+	{
+		"GOMIPS64", func(a Arch) string { return a.GOMIPS64 },
+		func(a *Arch, v string) { a.GOMIPS64 = v },
+		[]string{"mips64", "mips64le"},
+		oneOf("hardfloat", "softfloat"),
+		"must be hardfloat, softfloat",
+	},
+	{
+		"GOPPC64", func(a Arch) string { return a.GOPPC64 },
+		func(a *Arch, v string) { a.GOPPC64 = v },
+		[]string{"ppc64", "ppc64le"},
+		oneOf("power8", "power9", "power10"),
+		"must be power8, power9, power10",
+	},
+	{
+		"GORISCV64", func(a Arch) string { return a.GORISCV64 },
+		func(a *Arch, v string) { a.GORISCV64 = v },
+		[]string{"riscv64"},
+		oneOf("rva20u64", "rva22u64", "rva23u64"),
+		"must be rva20u64, rva22u64, rva23u64",
+	},
+	{
+		"GOWASM", func(a Arch) string { return a.GOWASM },
+		func(a *Arch, v string) { a.GOWASM = v },
+		[]string{"wasm"},
+		listOf(oneOf("satconv", "signext")),
+		"must be a comma separated list of satconv, signext",
+	},
+}
+
+// Validate checks that the settings are valid and apply to goarch.
+func (a Arch) Validate(goarch string) error {
+	for _, s := range archSettings {
+		v := s.value(a)
+		if v == "" {
+			continue
+		}
+		if !s.valid(v) {
+			return fmt.Errorf("invalid %s=%s: %s", s.name, v, s.usage)
+		}
+		if !oneOf(s.goarch...)(goarch) {
+			return fmt.Errorf("%s is not supported with GOARCH=%s", s.name, goarch)
+		}
+	}
+	if a.GOEXPERIMENT != "" && !listOf(validExperiment)(a.GOEXPERIMENT) {
+		return fmt.Errorf("invalid GOEXPERIMENT=%s: must be a comma separated list of experiment names", a.GOEXPERIMENT)
+	}
+	return nil
+}
+
+// Setting returns the name and value of the setting applying to goarch, like
+// "GOAMD64" and "v3", or empty strings if there is none or it is not set.
+func (a Arch) Setting(goarch string) (string, string) {
+	for _, s := range archSettings {
+		if oneOf(s.goarch...)(goarch) {
+			return s.name, s.value(a)
+		}
+	}
+	return "", ""
+}
+
+// SetSetting sets the setting applying to goarch to value, like GOAMD64 to
+// "v3" for amd64. It returns false if goarch has no such setting.
+func (a *Arch) SetSetting(goarch, value string) bool {
+	for _, s := range archSettings {
+		if oneOf(s.goarch...)(goarch) {
+			s.set(a, value)
+			return true
+		}
+	}
+	return false
+}
+
+// env returns the "KEY=value" variables of the non-empty settings.
+func (a Arch) env() []string {
+	vars := []string(nil)
+	for _, s := range archSettings {
+		if v := s.value(a); v != "" {
+			vars = append(vars, s.name+"="+v)
+		}
+	}
+	if a.GOEXPERIMENT != "" {
+		vars = append(vars, "GOEXPERIMENT="+a.GOEXPERIMENT)
+	}
+	return vars
+}
+
+// resolve returns a with the empty settings applying to goarch and
+// GOEXPERIMENT taken from the "KEY=value" variables vars, the environment the
+// tools would otherwise read them from.
+func (a Arch) resolve(goarch string, vars []string) Arch {
+	values := map[string]string{}
+	for _, v := range vars {
+		if kv := strings.SplitN(v, "=", 2); len(kv) == 2 {
+			values[kv[0]] = kv[1]
+		}
+	}
+	for _, s := range archSettings {
+		if s.value(a) == "" && oneOf(s.goarch...)(goarch) {
+			s.set(&a, values[s.name])
+		}
+	}
+	if a.GOEXPERIMENT == "" {
+		a.GOEXPERIMENT = values["GOEXPERIMENT"]
+	}
+	return a
+}
+
+func oneOf(values ...string) func(v string) bool {
+	return func(v string) bool {
+		for _, value := range values {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func listOf(valid func(v string) bool) func(v string) bool {
+	return func(v string) bool {
+		for _, item := range strings.Split(v, ",") {
+			if item != "" && !valid(item) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func validGOARM(v string) bool {
+	v = strings.TrimSuffix(strings.TrimSuffix(v, ",softfloat"), ",hardfloat")
+	return oneOf("5", "6", "7")(v)
+}
+
+func validGOARM64(v string) bool {
+	for {
+		switch {
+		case strings.HasSuffix(v, ",lse"):
+			v = strings.TrimSuffix(v, ",lse")
+		case strings.HasSuffix(v, ",crypto"):
+			v = strings.TrimSuffix(v, ",crypto")
+		default:
+			if len(v) != len("v8.0") || v[2] != '.' || v[3] < '0' || v[3] > '9' {
+				return false
+			}
+			return v[:2] == "v8" || (v[:2] == "v9" && v[3] <= '5')
+		}
+	}
+}
+
+func validExperiment(v string) bool {
+	for _, r := range v {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package build_test
+
+import (
+	"bytes"
+	"fmt"
+	gb "go/build"
+	"os"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchValidate(t *testing.T) {
+	testCases := []struct {
+		Arch   build.Arch
+		GOARCH string
+		Error  string
+	}{
+		{build.Arch{}, "goarch", ""},
+		{build.Arch{GOAMD64: "v3", GOEXPERIMENT: "arenas,noregabi"}, "amd64", ""},
+		{build.Arch{GOARM: "7"}, "arm", ""},
+		{build.Arch{GOARM: "6,softfloat"}, "arm", ""},
+		{build.Arch{GOARM64: "v8.2,crypto,lse"}, "arm64", ""},
+		{build.Arch{GOMIPS: "softfloat"}, "mipsle", ""},
+		{build.Arch{GOMIPS64: "hardfloat"}, "mips64", ""},
+		{build.Arch{GOPPC64: "power9"}, "ppc64le", ""},
+		{build.Arch{GORISCV64: "rva22u64"}, "riscv64", ""},
+		{build.Arch{GOWASM: "satconv,signext"}, "wasm", ""},
+		{build.Arch{GO386: "softfloat"}, "386", ""},
+		{build.Arch{GOAMD64: "v5"}, "amd64", "invalid GOAMD64=v5: must be v1, v2, v3, v4"},
+		{build.Arch{GOAMD64: "v3"}, "arm64", "GOAMD64 is not supported with GOARCH=arm64"},
+		{build.Arch{GOARM: "8"}, "arm", `invalid GOARM=8: must start with 5, 6, or 7, and may optionally end in either ",hardfloat" or ",softfloat"`},
+		{build.Arch{GOARM64: "v9.6"}, "arm64", `invalid GOARM64=v9.6: must start with v8.{0-9} or v9.{0-5} and may optionally end in ",lse" and/or ",crypto"`},
+		{build.Arch{GOWASM: "simd"}, "wasm", "invalid GOWASM=simd: must be a comma separated list of satconv, signext"},
+		{build.Arch{GOEXPERIMENT: "no-regabi"}, "amd64", "invalid GOEXPERIMENT=no-regabi: must be a comma separated list of experiment names"},
+	}
+	for c, tc := range testCases {
+		err := tc.Arch.Validate(tc.GOARCH)
+		if tc.Error == "" {
+			assert.NoErrorf(t, err, "failed with case %d", c)
+		} else {
+			assert.EqualErrorf(t, err, tc.Error, "failed with case %d", c)
+		}
+	}
+}
+
+func TestArchSetting(t *testing.T) {
+	arch := build.Arch{GOAMD64: "v3", GOARM: "7"}
+	name, value := arch.Setting("arm")
+	assert.Equal(t, "GOARM", name)
+	assert.Equal(t, "7", value)
+	name, value = arch.Setting("amd64")
+	assert.Equal(t, "GOAMD64", name)
+	assert.Equal(t, "v3", value)
+	name, value = arch.Setting("s390x")
+	assert.Equal(t, "", name)
+	assert.Equal(t, "", value)
+}
+
+func TestArchEnv(t *testing.T) {
+	if os.Getenv("TEST_SUBPROCESS") == "1" {
+		fmt.Fprintf(os.Stdout, "%s %s %s", os.Getenv("GOARCH"), os.Getenv("GOAMD64"), os.Getenv("GOEXPERIMENT"))
+		os.Exit(0)
+	}
+	os.Setenv("TEST_SUBPROCESS", "1")
+	defer os.Setenv("TEST_SUBPROCESS", "")
+	tools := build.NewCmdTools()
+	tools.Compiler = os.Args[0]
+	tools.CompilerArgs = []string{"-test.run=TestArchEnv", "--"}
+	tools.Linker = os.Args[0]
+	tools.LinkerArgs = []string{"-test.run=TestArchEnv", "--"}
+
+	stdout := &bytes.Buffer{}
+	err := tools.Compile(build.CompileArgs{
+		Context: gb.Context{GOARCH: "amd64"},
+		Arch:    build.Arch{GOAMD64: "v3", GOEXPERIMENT: "noregabi"},
+		Stdout:  stdout,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "amd64 v3 noregabi", stdout.String())
+
+	stdout.Reset()
+	err = tools.Link(build.LinkArgs{
+		Context: gb.Context{GOARCH: "arm"},
+		Arch:    build.Arch{GOAMD64: "v3"},
+		Stdout:  stdout,
+	})
+	assert.EqualError(t, err, "GOAMD64 is not supported with GOARCH=arm")
+	assert.Empty(t, stdout.String())
+}
@@ -0,0 +1,180 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	gb "go/build"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// PackageAssembler assembles the SFiles of a package around its compile, as
+// the go command does: SymABIs generates the symabis of all the files, for
+// the compiler to write the ABI wrappers of the assembly functions and the
+// go_asm.h header, then Assemble assembles every file concurrently, each
+// including the header.
+type PackageAssembler struct {
+	Assembler Assembler
+	// Args holds the settings shared by the files, like Context, Arch and
+	// IncludeDirs. The ABI defines of the target are added to its Defines.
+	Args AssembleArgs
+	// Compile is the compile of Package, set up by PackageCompileArgs, the
+	// header and symabis files are read from.
+	Compile CompileArgs
+	Package *gb.Package
+	// Concurrency is the number of files assembled at once, GOMAXPROCS if
+	// <= 0.
+	Concurrency int
+}
+
+// asmDefines returns the defines of the assembly for the target, like
+// "GOOS_linux", "GOARCH_amd64" and "GOAMD64_v1", as the go command sets them.
+// The sub-architecture settings left empty take the toolchain default.
+func asmDefines(ctx gb.Context, arch Arch) []string {
+	defines := []string{"GOOS_" + ctx.GOOS, "GOARCH_" + ctx.GOARCH}
+	or := func(v, def string) string {
+		if v == "" {
+			return def
+		}
+		return v
+	}
+	switch ctx.GOARCH {
+	case "386":
+		defines = append(defines, "GO386_"+or(arch.GO386, "sse2"))
+	case "amd64":
+		defines = append(defines, "GOAMD64_"+or(arch.GOAMD64, "v1"))
+	case "mips", "mipsle":
+		defines = append(defines, "GOMIPS_"+or(arch.GOMIPS, "hardfloat"))
+	case "mips64", "mips64le":
+		defines = append(defines, "GOMIPS64_"+or(arch.GOMIPS64, "hardfloat"))
+	case "ppc64", "ppc64le":
+		// Each version is a superset of the previous ones.
+		switch arch.GOPPC64 {
+		case "power10":
+			defines = append(defines, "GOPPC64_power10")
+			fallthrough
+		case "power9":
+			defines = append(defines, "GOPPC64_power9")
+			fallthrough
+		default:
+			defines = append(defines, "GOPPC64_power8")
+		}
+	case "riscv64":
+		defines = append(defines, "GORISCV64_"+or(arch.GORISCV64, "rva20u64"))
+	case "arm":
+		// Each version is a superset of the previous ones, GOARM may have a
+		// floating point mode suffix.
+		switch v := or(arch.GOARM, "7"); {
+		case strings.Contains(v, "7"):
+			defines = append(defines, "GOARM_7")
+			fallthrough
+		case strings.Contains(v, "6"):
+			defines = append(defines, "GOARM_6")
+			fallthrough
+		default:
+			defines = append(defines, "GOARM_5")
+		}
+	case "arm64":
+		// Large System Extensions are mandatory from v8.1 on.
+		v := or(arch.GOARM64, "v8.0")
+		if strings.Contains(v, ",lse") || !strings.HasPrefix(v, "v8.0") {
+			defines = append(defines, "GOARM64_LSE")
+		}
+	}
+	return defines
+}
+
+// args returns the arguments shared by the assembly of every file.
+func (pa *PackageAssembler) args() (AssembleArgs, error) {
+	if pa.Compile.AsmHeaderFile == "" || pa.Compile.SymABIsFile == "" {
+		return AssembleArgs{}, fmt.Errorf("%s: compile has no assembly header and symabis", pa.Package.ImportPath)
+	}
+	args := pa.Args
+	args.IncludeDirs = append([]string{filepath.Dir(pa.Compile.AsmHeaderFile)}, args.IncludeDirs...)
+	args.Defines = append(asmDefines(args.Context, args.Arch), args.Defines...)
+	if pa.Package.Goroot && pa.Package.ImportPath == "runtime" && args.Context.GOARCH == "386" && args.DynamicLink {
+		args.Defines = append(args.Defines, "GOBUILDMODE_shared=1")
+	}
+	if args.PackageImportPath == "" {
+		args.PackageImportPath = pa.Compile.PackageImportPath
+	}
+	return PackageAssembleArgs(args, pa.Package), nil
+}
+
+// SymABIs generates the symabis file of Compile. The header not being
+// generated yet, it starts from an empty one.
+func (pa *PackageAssembler) SymABIs() error {
+	args, err := pa.args()
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(pa.Compile.AsmHeaderFile, nil, 0666); err != nil {
+		return err
+	}
+	args = SymABIsArgs(args, pa.Package, filepath.Dir(pa.Compile.SymABIsFile))
+	args.OutputFile = pa.Compile.SymABIsFile
+	return pa.Assembler.Assemble(args)
+}
+
+// Assemble assembles each of the SFiles once compiled, into objects next to
+// the header, and returns the objects in the order of SFiles. The output of
+// the files is written in that order too.
+func (pa *PackageAssembler) Assemble() ([]string, error) {
+	args, err := pa.args()
+	if err != nil {
+		return nil, err
+	}
+	concurrency := pa.Concurrency
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	objDir := filepath.Dir(pa.Compile.AsmHeaderFile)
+	objs := make([]string, len(pa.Package.SFiles))
+	stdouts := make([]bytes.Buffer, len(objs))
+	stderrs := make([]bytes.Buffer, len(objs))
+	errs := make([]error, len(objs))
+	sem := make(chan struct{}, concurrency)
+	wg := sync.WaitGroup{}
+	for i, file := range pa.Package.SFiles {
+		objs[i] = filepath.Join(objDir, strings.TrimSuffix(file, ".s")+".o")
+		obj := args
+		obj.Stdout = &stdouts[i]
+		obj.Stderr = &stderrs[i]
+		obj.Files = []string{file}
+		obj.OutputFile = objs[i]
+		wg.Add(1)
+		go func(i int, obj AssembleArgs) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			errs[i] = pa.Assembler.Assemble(obj)
+		}(i, obj)
+	}
+	wg.Wait()
+	for i := range objs {
+		if err := copyOutput(args.Stdout, &stdouts[i]); err != nil {
+			return nil, err
+		}
+		if err := copyOutput(args.Stderr, &stderrs[i]); err != nil {
+			return nil, err
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objs, nil
+}
+
+func copyOutput(w io.Writer, buf *bytes.Buffer) error {
+	if w == nil || buf.Len() == 0 {
+		return nil
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
@@ -0,0 +1,193 @@
+package build_test
+
+import (
+	"bytes"
+	"fmt"
+	gb "go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gophertest/build"
+	"github.com/gophertest/build/buildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// assemblerFunc is an Assembler calling itself.
+type assemblerFunc func(args build.AssembleArgs) error
+
+func (f assemblerFunc) Assemble(args build.AssembleArgs) error {
+	return f(args)
+}
+
+func packageAssembler(dir string, asm build.Assembler) *build.PackageAssembler {
+	p := &gb.Package{ImportPath: "example.com/a", SFiles: []string{"a.s", "b.s", "c.s"}}
+	ctx := gb.Default
+	ctx.GOOS, ctx.GOARCH = "linux", "amd64"
+	compile := build.CompileArgs{PackageImportPath: "example.com/a"}
+	return &build.PackageAssembler{
+		Assembler: asm,
+		Args: build.AssembleArgs{
+			Context:     ctx,
+			IncludeDirs: []string{"include"},
+			Defines:     []string{"A"},
+		},
+		Compile: build.PackageCompileArgs(compile, p, dir, ""),
+		Package: p,
+	}
+}
+
+func TestPackageAssemblerSymABIs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asm")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ft := buildtest.NewFakeTools()
+	pa := packageAssembler(dir, ft)
+	pa.Args.Arch.GOAMD64 = "v3"
+	require.NoError(t, pa.SymABIs())
+	calls := ft.AssembleCalls()
+	require.Len(t, calls, 1)
+	assert.True(t, calls[0].GenSymABIs)
+	assert.Equal(t, []string{"a.s", "b.s", "c.s"}, calls[0].Files)
+	assert.Equal(t, filepath.Join(dir, "symabis"), calls[0].OutputFile)
+	assert.Equal(t, pa.Compile.SymABIsFile, calls[0].OutputFile)
+	assert.Equal(t, []string{dir, "include"}, calls[0].IncludeDirs)
+	assert.Equal(t, []string{"GOOS_linux", "GOARCH_amd64", "GOAMD64_v3", "A"}, calls[0].Defines)
+	assert.Equal(t, "example.com/a", calls[0].PackageImportPath)
+	header, err := ioutil.ReadFile(pa.Compile.AsmHeaderFile)
+	require.NoError(t, err)
+	assert.Empty(t, header)
+
+	pa.Compile = build.CompileArgs{}
+	err = pa.SymABIs()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "example.com/a: compile has no assembly header")
+}
+
+func TestPackageAssemblerAssemble(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asm")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Every file waits for the others, so that they must be assembled at
+	// once.
+	mutex := sync.Mutex{}
+	calls := []build.AssembleArgs(nil)
+	all := make(chan struct{})
+	pa := packageAssembler(dir, assemblerFunc(func(args build.AssembleArgs) error {
+		mutex.Lock()
+		calls = append(calls, args)
+		if len(calls) == 3 {
+			close(all)
+		}
+		mutex.Unlock()
+		select {
+		case <-all:
+		case <-time.After(10 * time.Second):
+			return fmt.Errorf("%s assembled alone", args.Files[0])
+		}
+		io.WriteString(args.Stderr, args.Files[0]+"\n")
+		return nil
+	}))
+	stderr := &bytes.Buffer{}
+	pa.Args.Stderr = stderr
+	pa.Args.Context.GOARCH = "arm64"
+	pa.Concurrency = 3
+	objs, err := pa.Assemble()
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "a.o"), filepath.Join(dir, "b.o"), filepath.Join(dir, "c.o")}, objs)
+	assert.Equal(t, "a.s\nb.s\nc.s\n", stderr.String())
+	require.Len(t, calls, 3)
+	for _, v := range calls {
+		require.Len(t, v.Files, 1)
+		assert.Equal(t, filepath.Join(dir, v.Files[0][:1]+".o"), v.OutputFile)
+		assert.False(t, v.GenSymABIs)
+		assert.Equal(t, []string{dir, "include"}, v.IncludeDirs)
+		assert.Equal(t, []string{"GOOS_linux", "GOARCH_arm64", "A"}, v.Defines)
+		assert.Equal(t, "example.com/a", v.PackageImportPath)
+		assert.False(t, v.Std)
+	}
+
+	// The files are still all assembled when one fails.
+	ft := buildtest.NewFakeTools()
+	ft.Respond(buildtest.Assemble, buildtest.Response{Stderr: "a.s:1: bad\n", ExitCode: 1}, buildtest.Response{})
+	pa = packageAssembler(dir, ft)
+	pa.Args.Stderr = stderr
+	pa.Concurrency = 1
+	stderr.Reset()
+	_, err = pa.Assemble()
+	require.Error(t, err)
+	ft.AssertNumberOfCalls(t, buildtest.Assemble, 3)
+	assert.Equal(t, "a.s:1: bad\n", stderr.String())
+	for _, v := range ft.AssembleCalls() {
+		assert.Equal(t, []string{"GOOS_linux", "GOARCH_amd64", "GOAMD64_v1", "A"}, v.Defines)
+	}
+}
+
+func TestAsmDefines(t *testing.T) {
+	testCases := []struct {
+		GOARCH   string
+		Arch     build.Arch
+		Expected []string
+	}{
+		{"386", build.Arch{}, []string{"GO386_sse2"}},
+		{"386", build.Arch{GO386: "softfloat"}, []string{"GO386_softfloat"}},
+		{"amd64", build.Arch{}, []string{"GOAMD64_v1"}},
+		{"amd64", build.Arch{GOAMD64: "v3"}, []string{"GOAMD64_v3"}},
+		{"arm", build.Arch{}, []string{"GOARM_7", "GOARM_6", "GOARM_5"}},
+		{"arm", build.Arch{GOARM: "6,softfloat"}, []string{"GOARM_6", "GOARM_5"}},
+		{"arm", build.Arch{GOARM: "5"}, []string{"GOARM_5"}},
+		{"arm64", build.Arch{}, nil},
+		{"arm64", build.Arch{GOARM64: "v8.0,lse"}, []string{"GOARM64_LSE"}},
+		{"arm64", build.Arch{GOARM64: "v8.1"}, []string{"GOARM64_LSE"}},
+		{"arm64", build.Arch{GOARM64: "v9.0,crypto"}, []string{"GOARM64_LSE"}},
+		{"mips", build.Arch{}, []string{"GOMIPS_hardfloat"}},
+		{"mipsle", build.Arch{GOMIPS: "softfloat"}, []string{"GOMIPS_softfloat"}},
+		{"mips64", build.Arch{}, []string{"GOMIPS64_hardfloat"}},
+		{"mips64le", build.Arch{GOMIPS64: "softfloat"}, []string{"GOMIPS64_softfloat"}},
+		{"ppc64", build.Arch{}, []string{"GOPPC64_power8"}},
+		{"ppc64le", build.Arch{GOPPC64: "power9"}, []string{"GOPPC64_power9", "GOPPC64_power8"}},
+		{"ppc64le", build.Arch{GOPPC64: "power10"}, []string{"GOPPC64_power10", "GOPPC64_power9", "GOPPC64_power8"}},
+		{"riscv64", build.Arch{}, []string{"GORISCV64_rva20u64"}},
+		{"riscv64", build.Arch{GORISCV64: "rva22u64"}, []string{"GORISCV64_rva22u64"}},
+		{"s390x", build.Arch{}, nil},
+		{"wasm", build.Arch{GOWASM: "satconv"}, nil},
+	}
+	for _, tc := range testCases {
+		ctx := gb.Default
+		ctx.GOOS, ctx.GOARCH = "linux", tc.GOARCH
+		expected := append([]string{"GOOS_linux", "GOARCH_" + tc.GOARCH}, tc.Expected...)
+		assert.Equal(t, expected, build.AsmDefines(ctx, tc.Arch), "%s %+v", tc.GOARCH, tc.Arch)
+	}
+}
+
+func TestPackageAssemblerRuntime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asm")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The standard library may reference the internals of the runtime, which
+	// needs to know about shared builds on 386.
+	ft := buildtest.NewFakeTools()
+	pa := packageAssembler(dir, ft)
+	pa.Package = &gb.Package{ImportPath: "runtime", Goroot: true, SFiles: []string{"asm_386.s"}}
+	pa.Compile = build.PackageCompileArgs(build.CompileArgs{PackageImportPath: "runtime"}, pa.Package, dir, "")
+	pa.Args.Context.GOARCH = "386"
+	pa.Args.DynamicLink = true
+	require.NoError(t, pa.SymABIs())
+	_, err = pa.Assemble()
+	require.NoError(t, err)
+	calls := ft.AssembleCalls()
+	require.Len(t, calls, 2)
+	for _, v := range calls {
+		assert.True(t, v.Std)
+		assert.Equal(t, "runtime", v.PackageImportPath)
+		assert.Equal(t, []string{"GOOS_linux", "GOARCH_386", "GO386_sse2", "A", "GOBUILDMODE_shared=1"}, v.Defines)
+	}
+}
@@ -0,0 +1,955 @@
+package build
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	gb "go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Builder builds packages and their dependencies from source using Tools.
+type Builder struct {
+	// Tools used to build, DefaultTools if nil.
+	Tools Tools
+	// Context packages are loaded and built for.
+	Context gb.Context
+	// Arch settings of the build. The empty ones are taken from the
+	// environment of the tools, as the go command does.
+	Arch Arch
+	// Env passed to every tool invocation.
+	Env Env
+	// BuildMode of main packages, BuildModeExe if empty. It also selects how
+	// the dependencies are compiled.
+	BuildMode BuildMode
+	// Instrument instruments every package and the link, which are loaded
+	// with its build tag and install suffix, if set. Its platform and cgo
+	// requirements are checked before loading and building.
+	Instrument Instrument
+	// ExternalLinker is the linker used when linking externally, the default
+	// of the linker if empty.
+	ExternalLinker string
+	// Stamp sets variables of the linked binaries, if set. The variables are
+	// checked to exist before linking.
+	Stamp *Stamp
+	// TrimPath removes the machine specific directories from the compiled
+	// files and binaries, as rewritten by NewTrimPathRules. The work
+	// directory is always removed.
+	TrimPath bool
+	// Modules maps the root directories of modules to their module, to
+	// rewrite their files with TrimPath.
+	Modules map[string]Module
+	// PGO selects the profile for profile-guided optimization of all the
+	// packages: PGOOff or empty to disable it, PGOAuto, or the path of a
+	// profile.
+	PGO string
+	// BuildInfo embedded in the linked binaries, if set. Path, the build
+	// settings and the VCS settings of a git checkout are filled in when
+	// missing, and the modules of Modules providing linked packages are
+	// added to Deps.
+	BuildInfo *BuildInfo
+	// WorkDir holds intermediate files. A temporary directory removed at the
+	// end of each build is used if empty.
+	WorkDir string
+	// CacheDir holds compiled package archives reused across builds. Caching
+	// is disabled if empty.
+	CacheDir string
+	// Stderr receives the diagnostics of the tools, if set.
+	Stderr io.Writer
+	// Events receives the progress of the builds as JSON BuildEvents, if
+	// set.
+	Events io.Writer
+}
+
+// Package is a package of a build Plan.
+type Package struct {
+	*gb.Package
+	// Deps are the packages directly imported by the package.
+	Deps []*Package
+	// ImportMap maps import paths as written in the source to the import
+	// path of the resolved package, when they differ (vendoring).
+	ImportMap map[string]string
+	// ID identifies the inputs of the package build, set once built.
+	ID string
+	// Fingerprint identifies the sources and target of the package like ID,
+	// but not how its code is generated, set once built. Packages with the
+	// same Fingerprint can be shared by a binary and its plugins.
+	Fingerprint string
+	// Archive is the compiled package archive, set once built.
+	Archive string
+	// Header is the C header of the functions exported with cgo, set once
+	// built if the package exports any.
+	Header string
+	// BuildID of Archive, "actionID/contentID" with ID as action ID, set
+	// once built.
+	BuildID string
+	// Cached is set if Archive was found up to date, in the cache or the
+	// work directory.
+	Cached bool
+}
+
+// Plan is a package with all its dependencies.
+type Plan struct {
+	// Main is the package the plan was made for.
+	Main *Package
+	// Packages in dependency order, Main last. Link only dependencies such
+	// as runtime are included for main packages.
+	Packages []*Package
+}
+
+// Result of a build.
+type Result struct {
+	// Plan that was built.
+	Plan *Plan
+	// Binary is the linked executable, empty unless building a main package.
+	Binary string
+	// Archive of the main package.
+	Archive string
+	// Header is the C header of the functions exported by a C library,
+	// written next to Binary with the extension replaced by ".h".
+	Header string
+	// BuildID of the binary, or of the archive if there is no binary.
+	BuildID string
+	// Size of the binary or archive in bytes.
+	Size int64
+	// Diagnostics written by the tools.
+	Diagnostics string
+}
+
+func (b *Builder) tools() Tools {
+	if b.Tools == nil {
+		return DefaultTools
+	}
+	return b.Tools
+}
+
+// Load loads the package importPath, as found from srcDir, and all its
+// dependencies.
+func (b *Builder) Load(importPath, srcDir string) (*Plan, error) {
+	if err := b.Instrument.Validate(b.Context); err != nil {
+		return nil, err
+	}
+	l := &loader{ctx: b.loadContext(), packages: map[string]*Package{}, loading: map[string]bool{}}
+	main, err := l.load(importPath, srcDir)
+	if err != nil {
+		return nil, err
+	}
+	if main.Name == "main" {
+		for _, v := range linkDeps(b.Context, b.mode(), b.Instrument) {
+			if _, err := l.load(v, ""); err != nil {
+				return nil, err
+			}
+		}
+		// Keep the main package last.
+		for i, v := range l.order {
+			if v == main {
+				l.order = append(append(l.order[:i:i], l.order[i+1:]...), main)
+				break
+			}
+		}
+	}
+	return &Plan{Main: main, Packages: l.order}, nil
+}
+
+// loadContext returns the context packages are loaded with.
+func (b *Builder) loadContext() gb.Context {
+	return b.Instrument.Context(b.Context)
+}
+
+func (b *Builder) mode() BuildMode {
+	if b.BuildMode == "" {
+		// The memory sanitizer needs PIE everywhere but on linux/amd64.
+		if b.Instrument == InstrumentMSan && (b.Context.GOOS != "linux" || b.Context.GOARCH != "amd64") {
+			return BuildModePIE
+		}
+		return BuildModeExe
+	}
+	return b.BuildMode
+}
+
+// linkDeps returns the packages the linker needs on top of those imported.
+func linkDeps(ctx gb.Context, mode BuildMode, instrument Instrument) []string {
+	deps := []string{"runtime"}
+	// External linking needs the C startup code of runtime/cgo.
+	if ctx.CgoEnabled && mustLinkExternal(mode, ctx.GOOS, ctx.GOARCH, true) {
+		deps = append(deps, "runtime/cgo")
+	}
+	// The linker refers to math for software floating point on arm.
+	if ctx.GOARCH == "arm" {
+		deps = append(deps, "math")
+	}
+	if instrument != "" {
+		deps = append(deps, instrument.runtimePackage())
+	}
+	return deps
+}
+
+// cgoImports returns the packages implicitly imported by the code cgo
+// generates for pkg.
+func cgoImports(pkg *gb.Package) []string {
+	if len(pkg.CgoFiles) == 0 {
+		return nil
+	}
+	imports := []string(nil)
+	if !pkg.Goroot || pkg.ImportPath != "runtime/cgo" {
+		imports = append(imports, "runtime/cgo")
+	}
+	switch {
+	case pkg.Goroot && (pkg.ImportPath == "runtime/cgo" || pkg.ImportPath == "runtime/race" ||
+		pkg.ImportPath == "runtime/msan" || pkg.ImportPath == "runtime/asan"):
+	default:
+		imports = append(imports, "syscall")
+	}
+	return imports
+}
+
+type loader struct {
+	ctx      gb.Context
+	packages map[string]*Package
+	loading  map[string]bool
+	order    []*Package
+}
+
+func (l *loader) load(importPath, srcDir string) (*Package, error) {
+	pkg, err := l.ctx.Import(importPath, srcDir, 0)
+	if err != nil {
+		return nil, err
+	}
+	return l.loadPackage(pkg)
+}
+
+// loadPackage loads the dependencies of pkg, unless a package with its
+// import path is already loaded.
+func (l *loader) loadPackage(pkg *gb.Package) (*Package, error) {
+	if p, ok := l.packages[pkg.ImportPath]; ok {
+		return p, nil
+	}
+	if l.loading[pkg.ImportPath] {
+		return nil, fmt.Errorf("import cycle not allowed: %s", pkg.ImportPath)
+	}
+	l.loading[pkg.ImportPath] = true
+	defer delete(l.loading, pkg.ImportPath)
+
+	p := &Package{Package: pkg}
+	seen := map[string]bool{}
+	for _, v := range append(append([]string(nil), pkg.Imports...), cgoImports(pkg)...) {
+		switch v {
+		case "C", "unsafe":
+			continue
+		}
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		dep, err := l.load(v, pkg.Dir)
+		if err != nil {
+			return nil, err
+		}
+		p.Deps = append(p.Deps, dep)
+		if dep.ImportPath != v {
+			if p.ImportMap == nil {
+				p.ImportMap = map[string]string{}
+			}
+			p.ImportMap[v] = dep.ImportPath
+		}
+	}
+	l.packages[pkg.ImportPath] = p
+	l.order = append(l.order, p)
+	return p, nil
+}
+
+// Build builds the package importPath, as found from srcDir, with its
+// dependencies. Main packages are linked into outputFile.
+func (b *Builder) Build(importPath, srcDir, outputFile string) (*Result, error) {
+	plan, err := b.Load(importPath, srcDir)
+	if err != nil {
+		return nil, err
+	}
+	return b.BuildPlan(plan, outputFile)
+}
+
+// BuildPlan builds all the packages of plan in order. If the main package of
+// the plan is a command it is linked into outputFile.
+func (b *Builder) BuildPlan(plan *Plan, outputFile string) (result *Result, err error) {
+	if err := b.Arch.Validate(b.Context.GOARCH); err != nil {
+		return nil, err
+	}
+	if err := b.Instrument.Validate(b.Context); err != nil {
+		return nil, err
+	}
+	workDir := b.WorkDir
+	if workDir == "" {
+		dir, err := ioutil.TempDir("", "gophertest-build")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		workDir = dir
+	}
+	result = &Result{Plan: plan}
+	diag := &bytes.Buffer{}
+	defer func() { result.Diagnostics = diag.String() }()
+	stderr := io.Writer(diag)
+	if b.Stderr != nil {
+		stderr = io.MultiWriter(diag, b.Stderr)
+	}
+	a, err := b.newAction(plan, workDir, stderr)
+	if err != nil {
+		return result, err
+	}
+	if b.Events != nil {
+		a.events = NewEventWriter(b.Events)
+	}
+
+	for _, p := range plan.Packages {
+		// Commands finish once linked.
+		if err := a.build(p, p == plan.Main && p.Name == "main"); err != nil {
+			return result, err
+		}
+	}
+	result.Archive = plan.Main.Archive
+	output := plan.Main.Archive
+	if plan.Main.Name == "main" {
+		start := time.Now()
+		defer func() { a.finish(plan.Main, start, err) }()
+		defines := []string(nil)
+		var stamp *Stamp
+		if b.Stamp != nil {
+			s := *b.Stamp
+			if s.VCS {
+				if s, err = s.withVCS(plan.Main.Dir); err != nil {
+					return result, err
+				}
+			}
+			if err := s.Check(plan); err != nil {
+				return result, err
+			}
+			stamp = &s
+			defines = linkDefines(s.Defines(), plan.Main, a.compilePath(plan.Main))
+		}
+		modinfo := ""
+		if b.BuildInfo != nil {
+			bi, err := b.buildInfo(plan, a.arch, stamp, a.pgo)
+			if err != nil {
+				return result, err
+			}
+			modinfo = bi.modinfo()
+		}
+		id, err := a.link(plan, defines, modinfo, outputFile)
+		if err != nil {
+			return result, fmt.Errorf("%s: %v", plan.Main.ImportPath, err)
+		}
+		result.Binary = outputFile
+		output = outputFile
+		if plan.Main.Header != "" && (b.mode() == BuildModeCArchive || b.mode() == BuildModeCShared) {
+			result.Header = strings.TrimSuffix(outputFile, filepath.Ext(outputFile)) + ".h"
+			if err := copyFile(result.Header, plan.Main.Header); err != nil {
+				return result, err
+			}
+		}
+		// The build ID of a C archive is that of its Go object, which the
+		// buildid tool does not look for.
+		if b.mode() == BuildModeCArchive {
+			result.BuildID = id
+		}
+	}
+	if result.BuildID == "" {
+		if result.BuildID, err = a.buildID(output); err != nil {
+			return result, err
+		}
+	}
+	info, err := os.Stat(output)
+	if err != nil {
+		return result, err
+	}
+	result.Size = info.Size()
+	return result, nil
+}
+
+// action holds the state of a single BuildPlan.
+type action struct {
+	b       *Builder
+	main    *Package
+	workDir string
+	version string
+	stderr  io.Writer
+	// arch is Builder.Arch completed with the settings of the environment of
+	// the tools.
+	arch Arch
+	// trimRules rewrite the files of the packages with TrimPath.
+	trimRules TrimPathRules
+	// pgo is the profile of the build and pgoHash the hash of its content.
+	pgo     string
+	pgoHash string
+	// events receives the BuildEvents of the build, if set.
+	events *EventWriter
+}
+
+func (b *Builder) newAction(plan *Plan, workDir string, stderr io.Writer) (*action, error) {
+	version, err := b.tools().Version()
+	if err != nil {
+		return nil, err
+	}
+	a := &action{b: b, main: plan.Main, workDir: workDir, version: version, stderr: stderr}
+	toolsEnv := Env{}
+	if t, ok := b.tools().(envTools); ok {
+		toolsEnv = t.toolsEnv()
+	}
+	a.arch = b.Arch.resolve(b.Context.GOARCH, mergeEnv(toolsEnv, b.Env).environ())
+	if err := a.arch.Validate(b.Context.GOARCH); err != nil {
+		return nil, err
+	}
+	if a.pgo, a.pgoHash, err = b.pgoProfile(plan, version); err != nil {
+		return nil, err
+	}
+	if b.TrimPath {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		a.trimRules = NewTrimPathRules(b.Context, b.Modules, wd)
+	}
+	return a, nil
+}
+
+// tools returns the tools building p, emitting its events.
+func (a *action) tools(p *Package) Tools {
+	if a.events == nil {
+		return a.b.tools()
+	}
+	return NewEventTools(a.b.tools(), a.events, p.ImportPath)
+}
+
+// build builds p, emitting its events. The end of the build of packages
+// linked next is left to the link.
+func (a *action) build(p *Package, linked bool) error {
+	start := time.Now()
+	a.emit(BuildEvent{Action: EventStart, ImportPath: p.ImportPath})
+	err := a.buildPackage(p)
+	if err != nil {
+		err = fmt.Errorf("%s: %v", p.ImportPath, err)
+	}
+	if err != nil || !linked {
+		a.finish(p, start, err)
+	}
+	return err
+}
+
+// emit emits e if the build has events. Events never fail the build.
+func (a *action) emit(e BuildEvent) {
+	if a.events != nil {
+		a.events.Emit(e)
+	}
+}
+
+// finish emits the end of the build of p started at start, failed if err is
+// set.
+func (a *action) finish(p *Package, start time.Time, err error) {
+	elapsed := time.Since(start).Seconds()
+	if err != nil {
+		a.emit(BuildEvent{Action: EventOutput, ImportPath: p.ImportPath, Output: err.Error() + "\n"})
+		a.emit(BuildEvent{Action: EventFail, ImportPath: p.ImportPath, Elapsed: elapsed})
+		return
+	}
+	a.emit(BuildEvent{Action: EventPass, ImportPath: p.ImportPath, Elapsed: elapsed})
+}
+
+// objDir returns the directory holding the intermediate files of p.
+func (a *action) objDir(p *Package) (string, error) {
+	dir := filepath.Join(a.workDir, filepath.FromSlash(p.ImportPath))
+	return dir, os.MkdirAll(dir, 0777)
+}
+
+// packageID hashes all the inputs of the build of p into its fingerprint and
+// ID. The dependencies of p must have theirs set.
+func (a *action) packageID(p *Package) (string, string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "version %s\n", a.version)
+	fmt.Fprintf(h, "target %s/%s %v\n", a.b.Context.GOOS, a.b.Context.GOARCH, a.arch.env())
+	fmt.Fprintf(h, "package %s\n", p.ImportPath)
+	for _, v := range p.Deps {
+		fmt.Fprintf(h, "dep %s %s\n", v.ImportPath, v.Fingerprint)
+	}
+	if len(p.CgoFiles) > 0 {
+		fmt.Fprintf(h, "cgo %q %q %q\n", p.CgoCPPFLAGS, p.CgoCFLAGS, p.CgoLDFLAGS)
+	}
+	files := append(append([]string(nil), p.GoFiles...), p.SFiles...)
+	files = append(files, p.HFiles...)
+	files = append(files, p.CgoFiles...)
+	files = append(files, p.CFiles...)
+	files = append(files, p.SysoFiles...)
+	sort.Strings(files)
+	for _, v := range files {
+		data, err := ioutil.ReadFile(filepath.Join(p.Dir, v))
+		if err != nil {
+			return "", "", err
+		}
+		fmt.Fprintf(h, "file %s %x\n", v, sha256.Sum256(data))
+	}
+	embed, err := NewEmbedConfig(p.Package)
+	if err != nil {
+		return "", "", err
+	}
+	if embed != nil {
+		for _, v := range p.EmbedPatterns {
+			fmt.Fprintf(h, "embed %q %q\n", v, embed.Patterns[v])
+		}
+		files := []string(nil)
+		for k := range embed.Files {
+			files = append(files, k)
+		}
+		sort.Strings(files)
+		for _, v := range files {
+			data, err := ioutil.ReadFile(embed.Files[v])
+			if err != nil {
+				return "", "", err
+			}
+			fmt.Fprintf(h, "embedfile %s %x\n", v, sha256.Sum256(data))
+		}
+	}
+	fingerprint := hashToString(h.Sum(nil))
+
+	shared, dynlink := codegen(a.b.mode(), a.b.Context.GOOS, a.b.Context.GOARCH)
+	h = sha256.New()
+	fmt.Fprintf(h, "fingerprint %s\n", fingerprint)
+	fmt.Fprintf(h, "codegen shared=%v dynlink=%v\n", shared, dynlink)
+	if p.Name == "main" && a.b.mode() == BuildModePlugin {
+		fmt.Fprintf(h, "plugin\n")
+	}
+	if a.pgoHash != "" {
+		fmt.Fprintf(h, "pgo %s\n", a.pgoHash)
+	}
+	if a.b.Instrument != "" {
+		fmt.Fprintf(h, "instrument %s\n", a.b.Instrument)
+	}
+	if a.b.TrimPath {
+		fmt.Fprintf(h, "trimpath %s\n", a.trimDir(p))
+	}
+	for _, v := range p.Deps {
+		fmt.Fprintf(h, "depcontent %s %s\n", v.ImportPath, ContentID(v.BuildID))
+	}
+	return fingerprint, hashToString(h.Sum(nil)), nil
+}
+
+// hashToString encodes the beginning of a hash like cmd/go does for build IDs.
+func hashToString(sum []byte) string {
+	return base64.RawURLEncoding.EncodeToString(sum[:15])
+}
+
+// buildPackage compiles p, or reuses its archive if up to date.
+func (a *action) buildPackage(p *Package) error {
+	if len(p.CFiles) > 0 && len(p.CgoFiles) == 0 {
+		return fmt.Errorf("C source files not allowed when not using cgo: %s", strings.Join(p.CFiles, " "))
+	}
+	reason, err := a.upToDate(p)
+	if err != nil {
+		return err
+	}
+	if reason == "" {
+		a.emit(BuildEvent{Action: EventCacheHit, ImportPath: p.ImportPath})
+		p.Cached = true
+		return nil
+	}
+	a.emit(BuildEvent{Action: EventCacheMiss, ImportPath: p.ImportPath, Output: reason})
+	id := p.ID
+	objDir, err := a.objDir(p)
+	if err != nil {
+		return err
+	}
+	archive := filepath.Join(objDir, "_pkg_.a")
+	if a.b.CacheDir != "" {
+		if err := os.MkdirAll(a.b.CacheDir, 0777); err != nil {
+			return err
+		}
+		archive = filepath.Join(a.b.CacheDir, id+".a")
+	}
+	// Write to a temporary file so that an interrupted build never leaves a
+	// partial archive in the cache.
+	output := archive + ".tmp"
+
+	importCfg := filepath.Join(objDir, "importcfg")
+	if err := importConfig(p.ImportMap, p.Deps).Write(importCfg); err != nil {
+		return err
+	}
+
+	shared, dynlink := codegen(a.b.mode(), a.b.Context.GOOS, a.b.Context.GOARCH)
+	goFiles := p.GoFiles
+	objs := []string(nil)
+	// The package compiled and assembled, its assembly for the C compiler
+	// left out.
+	gopkg := p.Package
+	if len(p.CgoFiles) > 0 {
+		sFiles, gccFiles, err := cgoSFiles(p.Package)
+		if err != nil {
+			return err
+		}
+		goFiles, objs, err = a.cgo(p, objDir, gccFiles)
+		if err != nil {
+			return err
+		}
+		pkg := *p.Package
+		pkg.SFiles = sFiles
+		gopkg = &pkg
+	}
+	compile := CompileArgs{
+		Context:           a.b.Context,
+		WorkingDirectory:  p.Dir,
+		Stdout:            a.stderr,
+		Stderr:            a.stderr,
+		Env:               a.b.Env,
+		Arch:              a.arch,
+		Files:             goFiles,
+		TrimPath:          a.trimPath(p, objDir),
+		OutputFile:        output,
+		BuildID:           p.ID + "/" + p.ID,
+		PackageImportPath: a.compilePath(p),
+		ImportConfigFile:  importCfg,
+		Pack:              true,
+		Shared:            shared,
+		DynamicLink:       dynlink,
+		ProfileFile:       a.pgo,
+		InstallSuffix:     a.b.Instrument.InstallSuffix(),
+		Race:              a.b.Instrument == InstrumentRace,
+		MSan:              a.b.Instrument == InstrumentMSan,
+		ASan:              a.b.Instrument == InstrumentASan,
+	}
+	compile = PackageCompileArgs(compile, gopkg, objDir, a.version)
+	embed, err := NewEmbedConfig(p.Package)
+	if err != nil {
+		return err
+	}
+	if embed != nil {
+		compile.EmbedConfigFile = filepath.Join(objDir, "embedcfg")
+		if err := embed.Write(compile.EmbedConfigFile); err != nil {
+			return err
+		}
+	}
+	if len(gopkg.SFiles) > 0 {
+		asm := &PackageAssembler{
+			Assembler: a.tools(p),
+			Args: AssembleArgs{
+				Context:          a.b.Context,
+				WorkingDirectory: p.Dir,
+				Stdout:           a.stderr,
+				Stderr:           a.stderr,
+				Env:              a.b.Env,
+				Arch:             a.arch,
+				TrimPath:         a.trimPath(p, objDir),
+				IncludeDirs:      []string{filepath.Join(a.b.Context.GOROOT, "pkg", "include")},
+				Shared:           shared,
+				DynamicLink:      dynlink,
+			},
+			Compile: compile,
+			Package: gopkg,
+		}
+		if err := asm.SymABIs(); err != nil {
+			return err
+		}
+		if err := a.tools(p).Compile(compile); err != nil {
+			return err
+		}
+		sobjs, err := asm.Assemble()
+		if err != nil {
+			return err
+		}
+		objs = append(objs, sobjs...)
+	} else if err := a.tools(p).Compile(compile); err != nil {
+		return err
+	}
+	// System objects, like the race detector runtime, are packed as is.
+	for _, v := range p.SysoFiles {
+		objs = append(objs, filepath.Join(p.Dir, v))
+	}
+	if len(objs) > 0 {
+		err := a.tools(p).Pack(PackArgs{
+			Context:          a.b.Context,
+			WorkingDirectory: objDir,
+			Stdout:           a.stderr,
+			Stderr:           a.stderr,
+			Env:              a.b.Env,
+			Op:               Append,
+			ObjectFile:       output,
+			Names:            objs,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if p.BuildID, err = stampBuildID(output, id); err != nil {
+		return err
+	}
+	if err := os.Rename(output, archive); err != nil {
+		return err
+	}
+	p.Archive = archive
+	// cgo only writes the header of packages exporting functions.
+	header := filepath.Join(objDir, "_cgo_install.h")
+	if _, err := os.Stat(header); len(p.CgoFiles) > 0 && err == nil {
+		p.Header = strings.TrimSuffix(archive, ".a") + ".h"
+		return os.Rename(header, p.Header)
+	}
+	return nil
+}
+
+// cgoSFiles splits the SFiles of pkg, which uses cgo, into the Go assembly
+// and the files for the C compiler, like the go command: runtime/cgo has both,
+// the files of the C compiler prefixed with "gcc_", any other package may only
+// have assembly for the C compiler.
+func cgoSFiles(pkg *gb.Package) ([]string, []string, error) {
+	if pkg.Goroot && pkg.ImportPath == "runtime/cgo" {
+		sFiles, gccFiles := []string(nil), []string(nil)
+		for _, v := range pkg.SFiles {
+			if strings.HasPrefix(v, "gcc_") {
+				gccFiles = append(gccFiles, v)
+			} else {
+				sFiles = append(sFiles, v)
+			}
+		}
+		return sFiles, gccFiles, nil
+	}
+	for _, v := range pkg.SFiles {
+		data, err := ioutil.ReadFile(filepath.Join(pkg.Dir, v))
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, directive := range []string{"TEXT", "DATA", "GLOBL"} {
+			if bytes.HasPrefix(data, []byte(directive)) || bytes.Contains(data, []byte("\n"+directive)) {
+				return nil, nil, fmt.Errorf("package using cgo has Go assembly file %s", v)
+			}
+		}
+	}
+	return nil, pkg.SFiles, nil
+}
+
+// cgo translates the cgo files of p and compiles their C code and gccFiles,
+// its assembly for the C compiler. It returns the Go files to compile and the
+// objects to pack with them.
+func (a *action) cgo(p *Package, objDir string, gccFiles []string) ([]string, []string, error) {
+	cflags := append(a.b.Instrument.cFlags(), p.CgoCPPFLAGS...)
+	cflags = append(cflags, p.CgoCFLAGS...)
+	if len(p.CgoCFLAGS) == 0 {
+		cflags = append(cflags, "-g", "-O2")
+	}
+	tools, err := withCgo(a.tools(p))
+	if err != nil {
+		return nil, nil, err
+	}
+	std := p.Goroot && p.ImportPath == "runtime/cgo"
+	err = tools.Cgo(CgoArgs{
+		Context:            a.b.Context,
+		WorkingDirectory:   p.Dir,
+		Stdout:             a.stderr,
+		Stderr:             a.stderr,
+		Env:                a.b.Env,
+		Arch:               a.arch,
+		Files:              p.CgoFiles,
+		ObjectDir:          objDir,
+		ImportPath:         p.ImportPath,
+		ExportHeader:       filepath.Join(objDir, "_cgo_install.h"),
+		TrimPath:           a.trimPath(p, objDir),
+		LDFlags:            strings.Join(append(a.b.Instrument.cFlags(), p.CgoLDFLAGS...), " "),
+		NoImportRuntimeCgo: std,
+		NoImportSyscall:    std || p.Goroot && (p.ImportPath == "runtime/race" || p.ImportPath == "runtime/msan" || p.ImportPath == "runtime/asan"),
+		CFlags:             append(cflags, "-I", objDir),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	goFiles := append([]string(nil), p.GoFiles...)
+	goFiles = append(goFiles, filepath.Join(objDir, "_cgo_gotypes.go"))
+	cFiles := []string{filepath.Join(objDir, "_cgo_export.c")}
+	for _, v := range p.CgoFiles {
+		base := strings.TrimSuffix(v, ".go")
+		goFiles = append(goFiles, filepath.Join(objDir, base+".cgo1.go"))
+		cFiles = append(cFiles, filepath.Join(objDir, base+".cgo2.c"))
+	}
+	cFiles = append(cFiles, p.CFiles...)
+	cFiles = append(cFiles, gccFiles...)
+
+	objs := []string(nil)
+	for i, v := range cFiles {
+		obj := filepath.Join(objDir, fmt.Sprintf("_x%03d.o", i+1))
+		err := tools.CCompile(CCompileArgs{
+			Context:          a.b.Context,
+			WorkingDirectory: p.Dir,
+			Stdout:           a.stderr,
+			Stderr:           a.stderr,
+			Env:              a.b.Env,
+			Files:            []string{v},
+			OutputFile:       obj,
+			IncludeDirs:      []string{objDir, p.Dir},
+			Flags:            append(ccFlags(a.b.Context), cflags...),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		objs = append(objs, obj)
+	}
+	// The dynamic imports of the package are not computed, the marker makes
+	// the linker fall back to external linking.
+	marker := filepath.Join(objDir, "dynimportfail")
+	if err := ioutil.WriteFile(marker, nil, 0666); err != nil {
+		return nil, nil, err
+	}
+	return goFiles, append(objs, marker), nil
+}
+
+// ccFlags returns the C compiler flags needed to target ctx.
+func ccFlags(ctx gb.Context) []string {
+	flags := []string(nil)
+	switch ctx.GOARCH {
+	case "amd64", "s390x":
+		flags = append(flags, "-m64")
+	case "386":
+		flags = append(flags, "-m32")
+	case "arm":
+		flags = append(flags, "-marm")
+	}
+	if ctx.GOOS != "windows" {
+		flags = append(flags, "-fPIC")
+	}
+	switch ctx.GOOS {
+	case "windows", "darwin", "ios":
+	default:
+		flags = append(flags, "-pthread")
+	}
+	return flags
+}
+
+// trimPath returns the -trimpath rewrites of the files of p.
+func (a *action) trimPath(p *Package, objDir string) string {
+	rules := TrimPathRules(nil).Add(objDir, "")
+	if a.b.TrimPath {
+		rules = append(rules, a.trimRules...)
+		if _, ok := a.trimRules.Apply(p.Dir); !ok {
+			rules = rules.Add(p.Dir, p.ImportPath)
+		}
+	}
+	return rules.String()
+}
+
+// trimDir returns the directory of p as rewritten with TrimPath.
+func (a *action) trimDir(p *Package) string {
+	if dir, ok := a.trimRules.Apply(p.Dir); ok {
+		return dir
+	}
+	return p.ImportPath
+}
+
+// compilePath returns the package path p is compiled with, commands are
+// compiled as main, or as their plugin path when building a plugin. Commands
+// imported by their tests are compiled with their import path.
+func (a *action) compilePath(p *Package) string {
+	if p.Name == "main" && p == a.main {
+		if a.b.mode() == BuildModePlugin {
+			return pluginPath(p)
+		}
+		return "main"
+	}
+	return p.ImportPath
+}
+
+// link links the main package of plan into outputFile, setting the string
+// defines and the build info, and returns its build ID.
+func (a *action) link(plan *Plan, defines []string, modinfo, outputFile string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "link %s %s\n", plan.Main.ID, a.b.mode())
+	for _, v := range plan.Packages {
+		fmt.Fprintf(h, "dep %s %s\n", v.ImportPath, v.ID)
+	}
+	for _, v := range defines {
+		fmt.Fprintf(h, "define %s\n", v)
+	}
+	fmt.Fprintf(h, "modinfo %q\n", modinfo)
+	id := hashToString(h.Sum(nil))
+
+	objDir, err := a.objDir(plan.Main)
+	if err != nil {
+		return "", err
+	}
+	importCfg := filepath.Join(objDir, "importcfg.link")
+	if err := importConfig(nil, plan.Packages).Write(importCfg); err != nil {
+		return "", err
+	}
+	if modinfo != "" {
+		if err := appendFile(importCfg, "modinfo "+strconv.Quote(modinfo)+"\n"); err != nil {
+			return "", err
+		}
+	}
+	if dir := filepath.Dir(outputFile); dir != "" {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return "", err
+		}
+	}
+	args := LinkArgs{
+		Context:          a.b.Context,
+		WorkingDirectory: objDir,
+		Stdout:           a.stderr,
+		Stderr:           a.stderr,
+		Env:              a.b.Env,
+		Arch:             a.arch,
+		Files:            []string{plan.Main.Archive},
+		ImportConfigFile: importCfg,
+		BuildID:          id + "/" + id,
+		OutputFile:       outputFile,
+		StringDefines:    defines,
+		BuildMode:        a.b.BuildMode,
+		ExternalLinker:   a.b.ExternalLinker,
+		TrimGOROOT:       a.b.TrimPath,
+		InstallSuffix:    a.b.Instrument.InstallSuffix(),
+		Race:             a.b.Instrument == InstrumentRace,
+		MSan:             a.b.Instrument == InstrumentMSan,
+		ASan:             a.b.Instrument == InstrumentASan,
+	}
+	if a.b.BuildMode == "" {
+		// The default of instrumented builds.
+		args.BuildMode = a.b.mode()
+		if args.BuildMode == BuildModeExe {
+			args.BuildMode = ""
+		}
+	}
+	if a.b.mode() == BuildModePlugin {
+		args.PluginPath = pluginPath(plan.Main)
+	}
+	return id + "/" + id, a.tools(plan.Main).Link(args)
+}
+
+func (a *action) buildID(file string) (string, error) {
+	return a.b.tools().BuildID(BuildIDArgs{
+		Context:    a.b.Context,
+		Stderr:     a.stderr,
+		Env:        a.b.Env,
+		ObjectFile: file,
+	})
+}
+
+// appendFile appends content to file.
+func appendFile(file, content string) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// importConfig returns the config importing packages with importMap.
+func importConfig(importMap map[string]string, packages []*Package) *ImportConfig {
+	cfg := &ImportConfig{ImportMap: importMap, PackageFile: map[string]string{}}
+	for _, v := range packages {
+		cfg.PackageFile[v.ImportPath] = v.Archive
+	}
+	return cfg
+}
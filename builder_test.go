@@ -0,0 +1,304 @@
+package build_test
+
+import (
+	gb "go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/gophertest/build/buildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testContext returns a context resolving packages from testdata in GOPATH
+// mode. The returned func restores the environment.
+func testContext(t *testing.T) (gb.Context, func()) {
+	gopath, err := filepath.Abs("testdata")
+	require.NoError(t, err)
+	restore := restoreEnv("GO111MODULE")
+	os.Setenv("GO111MODULE", "off")
+	ctx := gb.Default
+	ctx.GOOS = "linux"
+	ctx.GOARCH = "amd64"
+	ctx.GOPATH = gopath
+	ctx.CgoEnabled = false
+	return ctx, restore
+}
+
+// realContext returns the context of testContext for the host, skipping the
+// test if the tools of the go command are not installed.
+func realContext(t *testing.T) (gb.Context, func()) {
+	if testing.Short() {
+		t.Skip("builds with the go toolchain")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	for _, v := range []string{"asm", "compile", "link", "pack", "buildid"} {
+		if _, err := os.Stat(filepath.Join(gb.ToolDir, v)); err != nil {
+			t.Skipf("go toolchain not installed: %v", err)
+		}
+	}
+	ctx, restore := testContext(t)
+	ctx.GOOS, ctx.GOARCH = runtime.GOOS, runtime.GOARCH
+	return ctx, restore
+}
+
+// realCgoContext returns the context of realContext with cgo enabled,
+// skipping the test if there is no C compiler.
+func realCgoContext(t *testing.T) (gb.Context, func()) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	ctx, restore := realContext(t)
+	ctx.CgoEnabled = true
+	return ctx, restore
+}
+
+// copyPackages copies the packages of testdata named example.com/<name> to
+// the GOPATH gopath.
+func copyPackages(t *testing.T, gopath string, names ...string) {
+	for _, v := range names {
+		src := filepath.Join("testdata", "src", "example.com", v)
+		dst := filepath.Join(gopath, "src", "example.com", v)
+		require.NoError(t, os.MkdirAll(dst, 0777))
+		files, err := ioutil.ReadDir(src)
+		require.NoError(t, err)
+		for _, f := range files {
+			data, err := ioutil.ReadFile(filepath.Join(src, f.Name()))
+			require.NoError(t, err)
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dst, f.Name()), data, 0666))
+		}
+	}
+}
+
+func TestBuilderLoad(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+
+	b := &build.Builder{Context: ctx}
+	plan, err := b.Load("example.com/hello", "")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com/hello", plan.Main.ImportPath)
+	assert.Equal(t, plan.Main, plan.Packages[len(plan.Packages)-1])
+
+	index := map[string]int{}
+	for i, p := range plan.Packages {
+		_, dup := index[p.ImportPath]
+		assert.Falsef(t, dup, "%s listed twice", p.ImportPath)
+		index[p.ImportPath] = i
+	}
+	for i, p := range plan.Packages {
+		for _, dep := range p.Deps {
+			assert.Truef(t, index[dep.ImportPath] < i, "%s listed after %s", dep.ImportPath, p.ImportPath)
+		}
+	}
+	assert.Contains(t, index, "runtime")
+	assert.Contains(t, index, "fmt")
+	assert.Contains(t, index, "example.com/greet")
+
+	_, err = b.Load("example.com/missing", "")
+	assert.Error(t, err)
+}
+
+func TestBuilderBuild(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "builder")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	ft.Respond(buildtest.BuildID, buildtest.Response{Stdout: "link/id\n"})
+	b := &build.Builder{
+		Tools:    ft,
+		Context:  ctx,
+		WorkDir:  filepath.Join(dir, "work"),
+		CacheDir: filepath.Join(dir, "cache"),
+	}
+	binary := filepath.Join(dir, "bin", "hello")
+	result, err := b.Build("example.com/hello", "", binary)
+	require.NoError(t, err)
+	assert.Equal(t, binary, result.Binary)
+	assert.Equal(t, "link/id", result.BuildID)
+	assert.Equal(t, int64(len("link\n")), result.Size)
+	assert.FileExists(t, binary)
+
+	compiles := map[string]build.CompileArgs{}
+	for _, v := range ft.CompileCalls() {
+		compiles[v.PackageImportPath] = v
+	}
+	hello := compiles["main"]
+	assert.Equal(t, []string{"main.go"}, hello.Files)
+	assert.True(t, hello.Complete)
+	assert.False(t, hello.CompilingStandardLibrary)
+	importCfg, err := ioutil.ReadFile(hello.ImportConfigFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(importCfg), "packagefile example.com/greet="+filepath.Join(dir, "cache"))
+	assert.Contains(t, string(importCfg), "packagefile fmt=")
+
+	greet := compiles["example.com/greet"]
+	assert.Equal(t, []string{"greet.go", "name_amd64.go"}, greet.Files)
+	assert.False(t, greet.Complete)
+	assert.NotEmpty(t, greet.SymABIsFile)
+	assert.NotEmpty(t, greet.AsmHeaderFile)
+	assert.True(t, compiles["fmt"].CompilingStandardLibrary)
+
+	asm := []build.AssembleArgs(nil)
+	for _, v := range ft.AssembleCalls() {
+		if v.WorkingDirectory == greet.WorkingDirectory {
+			asm = append(asm, v)
+		}
+	}
+	require.Len(t, asm, 2)
+	assert.True(t, asm[0].GenSymABIs)
+	assert.Equal(t, greet.SymABIsFile, asm[0].OutputFile)
+	assert.False(t, asm[1].GenSymABIs)
+	assert.Equal(t, []string{"name_amd64.s"}, asm[1].Files)
+	assert.Contains(t, asm[1].Defines, "GOOS_linux")
+	assert.Contains(t, asm[1].Defines, "GOARCH_amd64")
+
+	links := ft.LinkCalls()
+	require.Len(t, links, 1)
+	assert.Equal(t, binary, links[0].OutputFile)
+	assert.Equal(t, []string{result.Plan.Main.Archive}, links[0].Files)
+
+	// Everything is cached the second time around.
+	ft.Reset()
+	ft.Respond(buildtest.BuildID, buildtest.Response{Stdout: "link/id\n"})
+	result, err = b.Build("example.com/hello", "", binary)
+	require.NoError(t, err)
+	ft.AssertNotCalled(t, buildtest.Compile)
+	ft.AssertNotCalled(t, buildtest.Assemble)
+	ft.AssertNumberOfCalls(t, buildtest.Link, 1)
+	for _, p := range result.Plan.Packages {
+		assert.Truef(t, p.Cached, "%s not cached", p.ImportPath)
+	}
+}
+
+func TestBuilderArchEnv(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+	defer restoreEnv("GOAMD64")()
+	defer restoreEnv("GOEXPERIMENT")()
+	os.Unsetenv("GOAMD64")
+	os.Unsetenv("GOEXPERIMENT")
+	dir, err := ioutil.TempDir("", "builder")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	b := &build.Builder{Tools: ft, Context: ctx, CacheDir: dir}
+	_, err = b.Build("example.com/greet", "", "")
+	require.NoError(t, err)
+	ft.AssertCompiled(t, "example.com/greet")
+
+	// The settings of the environment are part of the cache key.
+	os.Setenv("GOAMD64", "v3")
+	ft.Reset()
+	_, err = b.Build("example.com/greet", "", "")
+	require.NoError(t, err)
+	ft.AssertCompiled(t, "example.com/greet")
+	for _, v := range ft.CompileCalls() {
+		assert.Equal(t, "v3", v.Arch.GOAMD64)
+	}
+	for _, v := range ft.AssembleCalls() {
+		assert.Contains(t, v.Defines, "GOAMD64_v3")
+	}
+
+	b.Env = build.Env{Vars: []string{"GOEXPERIMENT=noregabi"}}
+	ft.Reset()
+	_, err = b.Build("example.com/greet", "", "")
+	require.NoError(t, err)
+	ft.AssertCompiled(t, "example.com/greet")
+	assert.Equal(t, "noregabi", ft.CompileCalls()[0].Arch.GOEXPERIMENT)
+
+	ft.Reset()
+	_, err = b.Build("example.com/greet", "", "")
+	require.NoError(t, err)
+	ft.AssertNotCalled(t, buildtest.Compile)
+
+	os.Setenv("GOAMD64", "v9")
+	_, err = b.Build("example.com/greet", "", "")
+	assert.EqualError(t, err, "invalid GOAMD64=v9: must be v1, v2, v3, v4")
+}
+
+func TestBuilderBuildError(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	ft.Respond(buildtest.Compile, buildtest.Response{Stderr: "main.go:1: oops\n", ExitCode: 2})
+	b := &build.Builder{Tools: ft, Context: ctx}
+	result, err := b.Build("example.com/greet", "", "")
+	require.Error(t, err)
+	assert.True(t, strings.HasSuffix(err.Error(), "compile: exit status 2"), err.Error())
+	assert.Equal(t, "main.go:1: oops\n", result.Diagnostics)
+
+	// The assembly of packages using cgo is for the C compiler.
+	ft.Reset()
+	b.Context.CgoEnabled = true
+	_, err = b.Build("example.com/cgoasm", "", "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "example.com/cgoasm: package using cgo has Go assembly file two_amd64.s")
+}
+
+// noCgoTools are Tools not implementing Cgoer and CCompiler.
+type noCgoTools struct {
+	build.Tools
+}
+
+func TestBuilderNoCgoTools(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	b := &build.Builder{Tools: noCgoTools{ft}, Context: ctx}
+	_, err := b.Build("example.com/greet", "", "")
+	require.NoError(t, err)
+
+	b.Context.CgoEnabled = true
+	for _, events := range []io.Writer{nil, ioutil.Discard} {
+		b.Events = events
+		_, err = b.BuildCLibrary("example.com/clib", "", build.BuildModeCArchive, "")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "runtime/cgo: tools build_test.noCgoTools do not support cgo")
+	}
+	ft.AssertNotCalled(t, buildtest.Cgo)
+}
+
+func TestBuilderBuildDefaultTools(t *testing.T) {
+	ctx, restore := realContext(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "builder")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	stderr := &strings.Builder{}
+	b := &build.Builder{Tools: build.DefaultTools, Context: ctx, Stderr: stderr, CacheDir: filepath.Join(dir, "cache")}
+	binary := filepath.Join(dir, "hello")
+	_, err = b.Build("example.com/hello", "", binary)
+	require.NoError(t, err, stderr.String())
+	out, err := exec.Command(binary).CombinedOutput()
+	require.NoError(t, err, string(out))
+	assert.Equal(t, "hello, world\n", string(out))
+
+	// The cached archives link again.
+	require.NoError(t, os.Remove(binary))
+	_, err = b.Build("example.com/hello", "", binary)
+	require.NoError(t, err, stderr.String())
+	out, err = exec.Command(binary).CombinedOutput()
+	require.NoError(t, err, string(out))
+	assert.Equal(t, "hello, world\n", string(out))
+}
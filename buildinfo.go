@@ -0,0 +1,229 @@
+package build
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BuildInfo is the build information embedded in a binary, as returned by
+// runtime/debug.ReadBuildInfo and printed by "go version -m".
+type BuildInfo struct {
+	// Path of the main package.
+	Path string
+	// Main module, omitted if its Path is empty.
+	Main Module
+	// Deps are the modules providing the dependencies of the binary.
+	Deps []Module
+	// Settings of the build, like "-buildmode", "GOOS" or "vcs.revision".
+	Settings []BuildSetting
+}
+
+// Module is a module of a BuildInfo.
+type Module struct {
+	Path    string
+	Version string
+	Sum     string
+	// Replace is the module replacing this one, if any.
+	Replace *Module
+}
+
+// BuildSetting is a key and value describing a build.
+type BuildSetting struct {
+	Key   string
+	Value string
+}
+
+// Markers delimiting the build info, recognized by the runtime.
+var (
+	infoStart, _ = hex.DecodeString("3077af0c9274080241e1c107e6d618e6")
+	infoEnd, _   = hex.DecodeString("f932433186182072008242104116d8f2")
+)
+
+// String returns the build info in the format of "go version -m", without the
+// go version line added by the linker.
+func (bi BuildInfo) String() string {
+	buf := &bytes.Buffer{}
+	if bi.Path != "" {
+		fmt.Fprintf(buf, "path\t%s\n", bi.Path)
+	}
+	var formatMod func(word string, m Module)
+	formatMod = func(word string, m Module) {
+		fmt.Fprintf(buf, "%s\t%s\t%s", word, m.Path, m.Version)
+		if m.Replace == nil {
+			fmt.Fprintf(buf, "\t%s\n", m.Sum)
+		} else {
+			buf.WriteString("\n")
+			formatMod("=>", *m.Replace)
+		}
+	}
+	if bi.Main.Path != "" {
+		formatMod("mod", bi.Main)
+	}
+	for _, v := range bi.Deps {
+		formatMod("dep", v)
+	}
+	for _, v := range bi.Settings {
+		key, value := v.Key, v.Value
+		if key == "" || strings.ContainsAny(key, "= \t\r\n\"`") {
+			key = strconv.Quote(key)
+		}
+		if strings.ContainsAny(value, " \t\r\n\"`") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(buf, "build\t%s=%s\n", key, value)
+	}
+	return buf.String()
+}
+
+// modinfo returns the build info as set in the runtime by the linker.
+func (bi BuildInfo) modinfo() string {
+	return string(infoStart) + bi.String() + string(infoEnd)
+}
+
+// setting returns the value of the setting key, if set.
+func (bi BuildInfo) setting(key string) (string, bool) {
+	for _, v := range bi.Settings {
+		if v.Key == key {
+			return v.Value, true
+		}
+	}
+	return "", false
+}
+
+// buildInfo returns the build info of the main package of plan, filling in
+// what is missing from b.BuildInfo, for the settings arch.
+func (b *Builder) buildInfo(plan *Plan, arch Arch, stamp *Stamp, pgo string) (BuildInfo, error) {
+	bi := *b.BuildInfo
+	bi.Settings = append([]BuildSetting(nil), bi.Settings...)
+	if bi.Path == "" {
+		bi.Path = plan.Main.ImportPath
+	}
+	if bi.Main.Path != "" && bi.Main.Version == "" {
+		bi.Main.Version = "(devel)"
+	}
+	bi.Deps = moduleDeps(bi, plan, b.Modules)
+	settings := []BuildSetting(nil)
+	if b.Instrument == InstrumentASan {
+		settings = append(settings, BuildSetting{"-asan", "true"})
+	}
+	settings = append(settings,
+		BuildSetting{"-buildmode", string(b.mode())},
+		BuildSetting{"-compiler", "gc"},
+	)
+	if stamp != nil {
+		ldflags, err := stamp.LDFlags()
+		if err != nil {
+			return BuildInfo{}, err
+		}
+		if ldflags != "" {
+			settings = append(settings, BuildSetting{"-ldflags", ldflags})
+		}
+	}
+	if b.Instrument == InstrumentMSan || b.Instrument == InstrumentRace {
+		settings = append(settings, BuildSetting{"-" + string(b.Instrument), "true"})
+	}
+	if pgo != "" {
+		settings = append(settings, BuildSetting{"-pgo", pgo})
+	}
+	cgo := "0"
+	if b.Context.CgoEnabled {
+		cgo = "1"
+	}
+	settings = append(settings,
+		BuildSetting{"CGO_ENABLED", cgo},
+		BuildSetting{"GOARCH", b.Context.GOARCH},
+	)
+	if arch.GOEXPERIMENT != "" {
+		settings = append(settings, BuildSetting{"GOEXPERIMENT", arch.GOEXPERIMENT})
+	}
+	settings = append(settings, BuildSetting{"GOOS", b.Context.GOOS})
+	if name, value := arch.Setting(b.Context.GOARCH); value != "" {
+		settings = append(settings, BuildSetting{name, value})
+	}
+	vcs, err := gitSettings(plan.Main.Dir)
+	if err != nil {
+		return bi, err
+	}
+	for _, v := range append(settings, vcs...) {
+		if _, ok := bi.setting(v.Key); !ok {
+			bi.Settings = append(bi.Settings, v)
+		}
+	}
+	return bi, nil
+}
+
+// moduleDeps returns the Deps of bi with the modules of modules, keyed by
+// root directory, providing packages of plan added, sorted by path. The main
+// module is not a dependency.
+func moduleDeps(bi BuildInfo, plan *Plan, modules map[string]Module) []Module {
+	deps := append([]Module(nil), bi.Deps...)
+	seen := map[string]bool{bi.Main.Path: true}
+	for _, v := range deps {
+		seen[v.Path] = true
+	}
+	for _, p := range plan.Packages {
+		if p.Goroot {
+			continue
+		}
+		m, ok := packageModule(modules, p.Dir)
+		if ok && !seen[m.Path] {
+			seen[m.Path] = true
+			deps = append(deps, m)
+		}
+	}
+	sort.SliceStable(deps, func(i, j int) bool { return deps[i].Path < deps[j].Path })
+	return deps
+}
+
+// packageModule returns the module of modules, keyed by root directory,
+// holding dir, the innermost one for nested modules.
+func packageModule(modules map[string]Module, dir string) (Module, bool) {
+	root, module, found := "", Module{}, false
+	for k, v := range modules {
+		k = filepath.Clean(k)
+		if (dir == k || strings.HasPrefix(dir, k+string(filepath.Separator))) && len(k) > len(root) {
+			root, module, found = k, v, true
+		}
+	}
+	return module, found
+}
+
+// gitSettings returns the VCS settings of the git checkout holding dir, none
+// if dir is not in a git checkout.
+func gitSettings(dir string) ([]BuildSetting, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, nil
+	}
+	if _, err := git(dir, "rev-parse", "--show-toplevel"); err != nil {
+		return nil, nil
+	}
+	out, err := git(dir, "-c", "log.showsignature=false", "log", "-1", "--format=%H:%ct")
+	if err != nil {
+		return nil, err
+	}
+	i := strings.Index(out, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("unexpected git log output %q", out)
+	}
+	sec, err := strconv.ParseInt(out[i+1:], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected git log output %q", out)
+	}
+	status, err := git(dir, "status", "--porcelain")
+	if err != nil {
+		return nil, err
+	}
+	return []BuildSetting{
+		{"vcs", "git"},
+		{"vcs.revision", out[:i]},
+		{"vcs.time", time.Unix(sec, 0).UTC().Format(time.RFC3339)},
+		{"vcs.modified", strconv.FormatBool(status != "")},
+	}, nil
+}
@@ -0,0 +1,130 @@
+package build_test
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/gophertest/build/buildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildInfoString(t *testing.T) {
+	bi := build.BuildInfo{
+		Path: "example.com/hello/cmd",
+		Main: build.Module{Path: "example.com/hello", Version: "(devel)"},
+		Deps: []build.Module{
+			{Path: "example.com/a", Version: "v1.0.0", Sum: "h1:a="},
+			{Path: "example.com/b", Version: "v1.1.0", Replace: &build.Module{Path: "../b"}},
+		},
+		Settings: []build.BuildSetting{
+			{"-buildmode", "exe"},
+			{"-ldflags", "-X main.v=1 -s"},
+			{"a=b", "c"},
+		},
+	}
+	assert.Equal(t, "path\texample.com/hello/cmd\n"+
+		"mod\texample.com/hello\t(devel)\t\n"+
+		"dep\texample.com/a\tv1.0.0\th1:a=\n"+
+		"dep\texample.com/b\tv1.1.0\n"+
+		"=>\t../b\t\t\n"+
+		"build\t-buildmode=exe\n"+
+		"build\t-ldflags=\"-X main.v=1 -s\"\n"+
+		"build\t\"a=b\"=c\n", bi.String())
+}
+
+func TestBuilderBuildInfo(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	ctx, restore := testContext(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "buildinfo")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The packages are built from a git checkout of their own.
+	ctx.GOPATH = filepath.Join(dir, "gopath")
+	copyPackages(t, ctx.GOPATH, "hello", "greet")
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = ctx.GOPATH
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=a", "GIT_AUTHOR_EMAIL=a@example.com", "GIT_AUTHOR_DATE=2020-01-02T03:04:05Z",
+			"GIT_COMMITTER_NAME=a", "GIT_COMMITTER_EMAIL=a@example.com", "GIT_COMMITTER_DATE=2020-01-02T03:04:05Z")
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+	commit := git("rev-parse", "HEAD")
+	src := filepath.Join(ctx.GOPATH, "src", "example.com")
+
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	b := &build.Builder{
+		Tools:   ft,
+		Context: ctx,
+		Arch:    build.Arch{GOAMD64: "v3"},
+		WorkDir: filepath.Join(dir, "work"),
+		BuildInfo: &build.BuildInfo{
+			Main:     build.Module{Path: "example.com"},
+			Settings: []build.BuildSetting{{"-compiler", "custom"}},
+		},
+		Modules: map[string]build.Module{
+			filepath.Join(src, "hello"): {Path: "example.com"},
+			filepath.Join(src, "greet"): {Path: "example.com/greet", Version: "v1.2.3", Sum: "h1:greet="},
+		},
+		Stamp: &build.Stamp{},
+	}
+	_, err = b.Build("example.com/hello", "", filepath.Join(dir, "hello"))
+	require.NoError(t, err)
+
+	links := ft.LinkCalls()
+	require.Len(t, links, 1)
+	f, err := os.Open(links[0].ImportConfigFile)
+	require.NoError(t, err)
+	defer f.Close()
+	modinfo := ""
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if strings.HasPrefix(s.Text(), "modinfo ") {
+			modinfo, err = strconv.Unquote(strings.TrimPrefix(s.Text(), "modinfo "))
+			require.NoError(t, err)
+		}
+	}
+	require.True(t, len(modinfo) > 32, modinfo)
+	lines := strings.Split(modinfo[16:len(modinfo)-16], "\n")
+	assert.Equal(t, []string{
+		"path\texample.com/hello",
+		"mod\texample.com\t(devel)\t",
+		"dep\texample.com/greet\tv1.2.3\th1:greet=",
+		"build\t-compiler=custom",
+		"build\t-buildmode=exe",
+		"build\tCGO_ENABLED=0",
+		"build\tGOARCH=amd64",
+		"build\tGOOS=linux",
+		"build\tGOAMD64=v3",
+		"build\tvcs=git",
+		"build\tvcs.revision=" + commit,
+		"build\tvcs.time=2020-01-02T03:04:05Z",
+		"build\tvcs.modified=false",
+		"",
+	}, lines)
+
+	// The build info is part of the link inputs.
+	ft.Reset()
+	b.BuildInfo.Path = "example.com/other"
+	_, err = b.Build("example.com/hello", "", filepath.Join(dir, "hello"))
+	require.NoError(t, err)
+	assert.NotEqual(t, links[0].BuildID, ft.LinkCalls()[0].BuildID)
+}
@@ -0,0 +1,427 @@
+// Package buildtest provides a fake build.Tools for testing code built on top
+// of package build without running the real go tools.
+package buildtest
+
+import (
+	"fmt"
+	gb "go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gophertest/build"
+)
+
+// Tool identifies the tool a Call was made to.
+type Tool string
+
+const (
+	// Assemble is a call to Assemble.
+	Assemble Tool = "asm"
+	// Compile is a call to Compile.
+	Compile Tool = "compile"
+	// Link is a call to Link.
+	Link Tool = "link"
+	// Pack is a call to Pack.
+	Pack Tool = "pack"
+	// BuildID is a call to BuildID.
+	BuildID Tool = "buildid"
+	// Cgo is a call to Cgo.
+	Cgo Tool = "cgo"
+	// CCompile is a call to CCompile.
+	CCompile Tool = "cc"
+	// Version is a call to Version.
+	Version Tool = "version"
+	// BuildCtx is a call to BuildCtx.
+	BuildCtx Tool = "buildctx"
+)
+
+// Response is the scripted result of a single tool invocation.
+type Response struct {
+	// Stdout is written to the Stdout of the args. For BuildID it is the
+	// returned build ID and for Version the returned version.
+	Stdout string
+	// Stderr is written to the Stderr of the args.
+	Stderr string
+	// ExitCode other than 0 makes the call fail with an *ExitError.
+	ExitCode int
+	// Files to create before returning, keyed by path relative to the
+	// WorkingDirectory of the args.
+	Files map[string]string
+}
+
+// Call is a recorded tool invocation.
+type Call struct {
+	Tool Tool
+	// Args is the build.AssembleArgs, build.CompileArgs, build.LinkArgs,
+	// build.PackArgs, build.BuildIDArgs, build.CgoArgs or
+	// build.CCompileArgs the tool was called with, nil for Version and
+	// BuildCtx.
+	Args interface{}
+}
+
+// ExitError is returned by a call whose Response has a non-zero ExitCode.
+type ExitError struct {
+	Tool Tool
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("%s: exit status %d", e.Tool, e.Code)
+}
+
+// FakeTools is an in-memory build.Tools that records calls and replies with
+// scripted responses. The zero value is ready to use, with an empty Context
+// and VersionString.
+type FakeTools struct {
+	mutex     sync.Mutex
+	calls     []Call
+	responses map[Tool][]Response
+
+	// Context returned by BuildCtx when no response is scripted.
+	Context gb.Context
+	// VersionString returned by Version when no response is scripted.
+	VersionString string
+	// WriteOutputs makes successful Assemble, Compile, Link and CCompile
+	// calls create their OutputFile, Cgo calls create their ExportHeader,
+	// and Pack calls adding to an archive create its ObjectFile, so that code
+	// checking for them can be tested.
+	WriteOutputs bool
+}
+
+var (
+	_ build.Tools     = &FakeTools{}
+	_ build.Cgoer     = &FakeTools{}
+	_ build.CCompiler = &FakeTools{}
+)
+
+// NewFakeTools creates a FakeTools that succeeds without output for every
+// call until responses are scripted with Respond.
+func NewFakeTools() *FakeTools {
+	return &FakeTools{
+		Context:       gb.Default,
+		VersionString: "go version go1.13 " + gb.Default.GOOS + "/" + gb.Default.GOARCH,
+	}
+}
+
+// Respond queues responses for tool. Each call to tool consumes one response
+// in order, the last one is repeated once the queue is exhausted.
+func (ft *FakeTools) Respond(tool Tool, responses ...Response) {
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+	if ft.responses == nil {
+		ft.responses = map[Tool][]Response{}
+	}
+	ft.responses[tool] = append(ft.responses[tool], responses...)
+}
+
+// Reset forgets all recorded calls and scripted responses.
+func (ft *FakeTools) Reset() {
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+	ft.calls = nil
+	ft.responses = nil
+}
+
+// Calls returns all recorded calls in order.
+func (ft *FakeTools) Calls() []Call {
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+	return append([]Call(nil), ft.calls...)
+}
+
+// CallsTo returns the recorded calls to tool in order.
+func (ft *FakeTools) CallsTo(tool Tool) []Call {
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+	calls := []Call(nil)
+	for _, v := range ft.calls {
+		if v.Tool == tool {
+			calls = append(calls, v)
+		}
+	}
+	return calls
+}
+
+// AssembleCalls returns the args of all recorded Assemble calls.
+func (ft *FakeTools) AssembleCalls() []build.AssembleArgs {
+	args := []build.AssembleArgs(nil)
+	for _, v := range ft.CallsTo(Assemble) {
+		args = append(args, v.Args.(build.AssembleArgs))
+	}
+	return args
+}
+
+// CompileCalls returns the args of all recorded Compile calls.
+func (ft *FakeTools) CompileCalls() []build.CompileArgs {
+	args := []build.CompileArgs(nil)
+	for _, v := range ft.CallsTo(Compile) {
+		args = append(args, v.Args.(build.CompileArgs))
+	}
+	return args
+}
+
+// LinkCalls returns the args of all recorded Link calls.
+func (ft *FakeTools) LinkCalls() []build.LinkArgs {
+	args := []build.LinkArgs(nil)
+	for _, v := range ft.CallsTo(Link) {
+		args = append(args, v.Args.(build.LinkArgs))
+	}
+	return args
+}
+
+// PackCalls returns the args of all recorded Pack calls.
+func (ft *FakeTools) PackCalls() []build.PackArgs {
+	args := []build.PackArgs(nil)
+	for _, v := range ft.CallsTo(Pack) {
+		args = append(args, v.Args.(build.PackArgs))
+	}
+	return args
+}
+
+// BuildIDCalls returns the args of all recorded BuildID calls.
+func (ft *FakeTools) BuildIDCalls() []build.BuildIDArgs {
+	args := []build.BuildIDArgs(nil)
+	for _, v := range ft.CallsTo(BuildID) {
+		args = append(args, v.Args.(build.BuildIDArgs))
+	}
+	return args
+}
+
+// CgoCalls returns the args of all recorded Cgo calls.
+func (ft *FakeTools) CgoCalls() []build.CgoArgs {
+	args := []build.CgoArgs(nil)
+	for _, v := range ft.CallsTo(Cgo) {
+		args = append(args, v.Args.(build.CgoArgs))
+	}
+	return args
+}
+
+// CCompileCalls returns the args of all recorded CCompile calls.
+func (ft *FakeTools) CCompileCalls() []build.CCompileArgs {
+	args := []build.CCompileArgs(nil)
+	for _, v := range ft.CallsTo(CCompile) {
+		args = append(args, v.Args.(build.CCompileArgs))
+	}
+	return args
+}
+
+// AssertCalled asserts that tool was called at least once.
+func (ft *FakeTools) AssertCalled(t testing.TB, tool Tool) bool {
+	t.Helper()
+	if len(ft.CallsTo(tool)) == 0 {
+		t.Errorf("expected a call to %s, got none", tool)
+		return false
+	}
+	return true
+}
+
+// AssertNotCalled asserts that tool was never called.
+func (ft *FakeTools) AssertNotCalled(t testing.TB, tool Tool) bool {
+	t.Helper()
+	if n := len(ft.CallsTo(tool)); n != 0 {
+		t.Errorf("expected no call to %s, got %d", tool, n)
+		return false
+	}
+	return true
+}
+
+// AssertNumberOfCalls asserts that tool was called exactly n times.
+func (ft *FakeTools) AssertNumberOfCalls(t testing.TB, tool Tool, n int) bool {
+	t.Helper()
+	if got := len(ft.CallsTo(tool)); got != n {
+		t.Errorf("expected %d calls to %s, got %d", n, tool, got)
+		return false
+	}
+	return true
+}
+
+// AssertCallOrder asserts that the recorded calls, ignoring Version and
+// BuildCtx, were made to exactly the given tools in order.
+func (ft *FakeTools) AssertCallOrder(t testing.TB, tools ...Tool) bool {
+	t.Helper()
+	got := []string(nil)
+	for _, v := range ft.Calls() {
+		if v.Tool == Version || v.Tool == BuildCtx {
+			continue
+		}
+		got = append(got, string(v.Tool))
+	}
+	expected := []string(nil)
+	for _, v := range tools {
+		expected = append(expected, string(v))
+	}
+	if strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected calls [%s], got [%s]", strings.Join(expected, " "), strings.Join(got, " "))
+		return false
+	}
+	return true
+}
+
+// AssertCompiled asserts that a package with the import path was compiled.
+func (ft *FakeTools) AssertCompiled(t testing.TB, importPath string) bool {
+	t.Helper()
+	for _, v := range ft.CompileCalls() {
+		if v.PackageImportPath == importPath {
+			return true
+		}
+	}
+	t.Errorf("expected package %s to be compiled", importPath)
+	return false
+}
+
+// call records the call and returns the response to reply with.
+func (ft *FakeTools) call(tool Tool, args interface{}) (Response, bool) {
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+	ft.calls = append(ft.calls, Call{Tool: tool, Args: args})
+	queue := ft.responses[tool]
+	if len(queue) == 0 {
+		return Response{}, false
+	}
+	if len(queue) > 1 {
+		ft.responses[tool] = queue[1:]
+	}
+	return queue[0], true
+}
+
+// reply performs the side effects of resp.
+func reply(tool Tool, resp Response, dir string, stdout, stderr io.Writer) error {
+	for name, content := range resp.Files {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		err := os.MkdirAll(filepath.Dir(name), 0777)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(name, []byte(content), 0666)
+		if err != nil {
+			return err
+		}
+	}
+	if stdout != nil && resp.Stdout != "" {
+		io.WriteString(stdout, resp.Stdout)
+	}
+	if stderr != nil && resp.Stderr != "" {
+		io.WriteString(stderr, resp.Stderr)
+	}
+	if resp.ExitCode != 0 {
+		return &ExitError{Tool: tool, Code: resp.ExitCode}
+	}
+	return nil
+}
+
+// writeOutput creates file, relative to dir, if WriteOutputs is set.
+func (ft *FakeTools) writeOutput(tool Tool, dir, file string) error {
+	if !ft.WriteOutputs || file == "" {
+		return nil
+	}
+	return reply(tool, Response{Files: map[string]string{file: string(tool) + "\n"}}, dir, nil, nil)
+}
+
+// Assemble records the call and replies with the next Assemble response.
+func (ft *FakeTools) Assemble(args build.AssembleArgs) error {
+	resp, _ := ft.call(Assemble, args)
+	if err := reply(Assemble, resp, args.WorkingDirectory, args.Stdout, args.Stderr); err != nil {
+		return err
+	}
+	return ft.writeOutput(Assemble, args.WorkingDirectory, args.OutputFile)
+}
+
+// Compile records the call and replies with the next Compile response.
+func (ft *FakeTools) Compile(args build.CompileArgs) error {
+	resp, _ := ft.call(Compile, args)
+	if err := reply(Compile, resp, args.WorkingDirectory, args.Stdout, args.Stderr); err != nil {
+		return err
+	}
+	return ft.writeOutput(Compile, args.WorkingDirectory, args.OutputFile)
+}
+
+// Link records the call and replies with the next Link response.
+func (ft *FakeTools) Link(args build.LinkArgs) error {
+	resp, _ := ft.call(Link, args)
+	if err := reply(Link, resp, args.WorkingDirectory, args.Stdout, args.Stderr); err != nil {
+		return err
+	}
+	return ft.writeOutput(Link, args.WorkingDirectory, args.OutputFile)
+}
+
+// Pack records the call and replies with the next Pack response.
+func (ft *FakeTools) Pack(args build.PackArgs) error {
+	resp, _ := ft.call(Pack, args)
+	if err := reply(Pack, resp, args.WorkingDirectory, args.Stdout, args.Stderr); err != nil {
+		return err
+	}
+	switch args.Op {
+	case build.AppendNew:
+	case build.Append:
+		// Keep the content of an existing archive.
+		file := args.ObjectFile
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(args.WorkingDirectory, file)
+		}
+		if _, err := os.Stat(file); err == nil {
+			return nil
+		}
+	default:
+		return nil
+	}
+	return ft.writeOutput(Pack, args.WorkingDirectory, args.ObjectFile)
+}
+
+// BuildID records the call and returns the Stdout of the next BuildID
+// response as the build ID.
+func (ft *FakeTools) BuildID(args build.BuildIDArgs) (string, error) {
+	resp, _ := ft.call(BuildID, args)
+	err := reply(BuildID, Response{Stderr: resp.Stderr, ExitCode: resp.ExitCode, Files: resp.Files}, args.WorkingDirectory, nil, args.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(resp.Stdout), nil
+}
+
+// Cgo records the call and replies with the next Cgo response.
+func (ft *FakeTools) Cgo(args build.CgoArgs) error {
+	resp, _ := ft.call(Cgo, args)
+	if err := reply(Cgo, resp, args.WorkingDirectory, args.Stdout, args.Stderr); err != nil {
+		return err
+	}
+	return ft.writeOutput(Cgo, args.WorkingDirectory, args.ExportHeader)
+}
+
+// CCompile records the call and replies with the next CCompile response.
+func (ft *FakeTools) CCompile(args build.CCompileArgs) error {
+	resp, _ := ft.call(CCompile, args)
+	if err := reply(CCompile, resp, args.WorkingDirectory, args.Stdout, args.Stderr); err != nil {
+		return err
+	}
+	return ft.writeOutput(CCompile, args.WorkingDirectory, args.OutputFile)
+}
+
+// Version records the call and returns the Stdout of the next Version
+// response, or VersionString when none is scripted.
+func (ft *FakeTools) Version() (string, error) {
+	resp, ok := ft.call(Version, nil)
+	if !ok {
+		return ft.VersionString, nil
+	}
+	if resp.ExitCode != 0 {
+		return "", &ExitError{Tool: Version, Code: resp.ExitCode}
+	}
+	return strings.TrimSpace(resp.Stdout), nil
+}
+
+// BuildCtx records the call and returns Context. A scripted response can only
+// make it fail.
+func (ft *FakeTools) BuildCtx() (gb.Context, error) {
+	resp, _ := ft.call(BuildCtx, nil)
+	if resp.ExitCode != 0 {
+		return ft.Context, &ExitError{Tool: BuildCtx, Code: resp.ExitCode}
+	}
+	return ft.Context, nil
+}
@@ -0,0 +1,113 @@
+package buildtest_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/gophertest/build/buildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeToolsRecordsCalls(t *testing.T) {
+	ft := buildtest.NewFakeTools()
+	var tools build.Tools = ft
+
+	assert.NoError(t, tools.Assemble(build.AssembleArgs{OutputFile: "a.o"}))
+	assert.NoError(t, tools.Compile(build.CompileArgs{PackageImportPath: "example.com/a"}))
+	assert.NoError(t, tools.Pack(build.PackArgs{Op: build.Append, ObjectFile: "a.a"}))
+	assert.NoError(t, tools.Link(build.LinkArgs{OutputFile: "a.out"}))
+	_, err := tools.Version()
+	assert.NoError(t, err)
+
+	ft.AssertCallOrder(t, buildtest.Assemble, buildtest.Compile, buildtest.Pack, buildtest.Link)
+	ft.AssertNumberOfCalls(t, buildtest.Compile, 1)
+	ft.AssertCalled(t, buildtest.Version)
+	ft.AssertNotCalled(t, buildtest.BuildID)
+	ft.AssertCompiled(t, "example.com/a")
+	assert.Equal(t, "a.o", ft.AssembleCalls()[0].OutputFile)
+	assert.Equal(t, "a.out", ft.LinkCalls()[0].OutputFile)
+	assert.Equal(t, build.PackOp(build.Append), ft.PackCalls()[0].Op)
+	assert.Len(t, ft.Calls(), 5)
+
+	ft.Reset()
+	assert.Empty(t, ft.Calls())
+}
+
+func TestFakeToolsResponses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "buildtest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ft := buildtest.NewFakeTools()
+	ft.Respond(buildtest.Compile,
+		buildtest.Response{
+			Stdout: "out",
+			Stderr: "err",
+			Files:  map[string]string{"obj/a.a": "archive"},
+		},
+		buildtest.Response{ExitCode: 2, Stderr: "syntax error"},
+	)
+	ft.Respond(buildtest.BuildID, buildtest.Response{Stdout: "abc/def\n"})
+	ft.Respond(buildtest.Version, buildtest.Response{Stdout: "go version go99.99.99 linux/amd64"})
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	err = ft.Compile(build.CompileArgs{WorkingDirectory: dir, Stdout: stdout, Stderr: stderr})
+	assert.NoError(t, err)
+	assert.Equal(t, "out", stdout.String())
+	assert.Equal(t, "err", stderr.String())
+	data, err := ioutil.ReadFile(filepath.Join(dir, "obj", "a.a"))
+	assert.NoError(t, err)
+	assert.Equal(t, "archive", string(data))
+
+	for i := 0; i < 2; i++ {
+		stderr.Reset()
+		err = ft.Compile(build.CompileArgs{WorkingDirectory: dir, Stderr: stderr})
+		assert.EqualError(t, err, "compile: exit status 2")
+		assert.Equal(t, "syntax error", stderr.String())
+	}
+
+	id, err := ft.BuildID(build.BuildIDArgs{ObjectFile: "a.a"})
+	assert.NoError(t, err)
+	assert.Equal(t, "abc/def", id)
+
+	version, err := ft.Version()
+	assert.NoError(t, err)
+	assert.Equal(t, "go version go99.99.99 linux/amd64", version)
+
+	ft.Context.GOOS = "plan9"
+	ctx, err := ft.BuildCtx()
+	assert.NoError(t, err)
+	assert.Equal(t, "plan9", ctx.GOOS)
+}
+
+func TestFakeToolsZeroValue(t *testing.T) {
+	ft := &buildtest.FakeTools{}
+	assert.NoError(t, ft.Compile(build.CompileArgs{}))
+	ft.Respond(buildtest.Compile, buildtest.Response{ExitCode: 2})
+	assert.Error(t, ft.Compile(build.CompileArgs{}))
+
+	ft.Reset()
+	assert.NoError(t, ft.Compile(build.CompileArgs{}))
+	ft.Respond(buildtest.Version, buildtest.Response{Stdout: "go version go99.99.99 linux/amd64"})
+	version, err := ft.Version()
+	assert.NoError(t, err)
+	assert.Equal(t, "go version go99.99.99 linux/amd64", version)
+}
+
+func TestFakeToolsAssertionsFail(t *testing.T) {
+	ft := buildtest.NewFakeTools()
+	assert.NoError(t, ft.Link(build.LinkArgs{}))
+
+	mock := &testing.T{}
+	assert.False(t, ft.AssertCalled(mock, buildtest.Compile))
+	assert.False(t, ft.AssertNotCalled(mock, buildtest.Link))
+	assert.False(t, ft.AssertNumberOfCalls(mock, buildtest.Link, 2))
+	assert.False(t, ft.AssertCallOrder(mock, buildtest.Compile, buildtest.Link))
+	assert.False(t, ft.AssertCompiled(mock, "example.com/a"))
+}
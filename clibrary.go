@@ -0,0 +1,98 @@
+package build
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BuildCLibrary builds the main package importPath, as found from srcDir, into
+// a C archive or C shared library written to outputFile, depending on mode.
+// The C header declaring the exported functions is written next to it, see
+// Result.Header.
+func (b *Builder) BuildCLibrary(importPath, srcDir string, mode BuildMode, outputFile string) (*Result, error) {
+	if mode != BuildModeCArchive && mode != BuildModeCShared {
+		return nil, fmt.Errorf("buildmode %s does not produce a C library", mode)
+	}
+	if !b.Context.CgoEnabled {
+		return nil, fmt.Errorf("buildmode %s requires cgo", mode)
+	}
+	if !mode.Supported(b.Context.GOOS, b.Context.GOARCH) {
+		return nil, fmt.Errorf("buildmode %s not supported on %s/%s", mode, b.Context.GOOS, b.Context.GOARCH)
+	}
+	lb := *b
+	lb.BuildMode = mode
+	plan, err := lb.Load(importPath, srcDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCLibrary(plan.Main); err != nil {
+		return nil, err
+	}
+	return lb.BuildPlan(plan, outputFile)
+}
+
+// checkCLibrary checks that p is a main package declaring main and exporting
+// functions with cgo.
+func checkCLibrary(p *Package) error {
+	if p.Name != "main" {
+		return fmt.Errorf("%s: a C library must be built from package main, not %s", p.ImportPath, p.Name)
+	}
+	if len(p.CgoFiles) == 0 {
+		return fmt.Errorf("%s: a C library must import \"C\"", p.ImportPath)
+	}
+	fset := token.NewFileSet()
+	hasMain, hasExport := false, false
+	for _, v := range append(append([]string(nil), p.GoFiles...), p.CgoFiles...) {
+		f, err := parser.ParseFile(fset, filepath.Join(p.Dir, v), nil, parser.ParseComments)
+		if err != nil {
+			return err
+		}
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			if fn.Recv == nil && fn.Name.Name == "main" {
+				hasMain = true
+			}
+			if fn.Doc != nil {
+				for _, c := range fn.Doc.List {
+					if strings.HasPrefix(c.Text, "//export ") {
+						hasExport = true
+					}
+				}
+			}
+		}
+	}
+	if !hasMain {
+		return fmt.Errorf("%s: function main is undeclared in the main package", p.ImportPath)
+	}
+	if !hasExport {
+		return fmt.Errorf("%s: no function exported with //export", p.ImportPath)
+	}
+	return nil
+}
+
+// copyFile copies the content of src to dst.
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
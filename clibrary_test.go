@@ -0,0 +1,161 @@
+package build_test
+
+import (
+	"debug/elf"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/gophertest/build/buildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildCLibrary(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+	ctx.CgoEnabled = true
+	dir, err := ioutil.TempDir("", "clibrary")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	ft.Respond(buildtest.BuildID, buildtest.Response{Stdout: "link/id\n"})
+	b := &build.Builder{Tools: ft, Context: ctx, ExternalLinker: "clang"}
+	output := filepath.Join(dir, "libclib.so")
+	result, err := b.BuildCLibrary("example.com/clib", "", build.BuildModeCShared, output)
+	require.NoError(t, err)
+	assert.Equal(t, output, result.Binary)
+	assert.Equal(t, filepath.Join(dir, "libclib.h"), result.Header)
+	assert.FileExists(t, result.Header)
+	assert.Empty(t, b.BuildMode)
+
+	cgo := ft.CgoCalls()
+	var clib build.CgoArgs
+	for _, v := range cgo {
+		if v.ImportPath == "example.com/clib" {
+			clib = v
+		}
+	}
+	assert.Equal(t, []string{"clib.go"}, clib.Files)
+	assert.NotEmpty(t, clib.ExportHeader)
+	assert.False(t, clib.NoImportRuntimeCgo)
+
+	for _, v := range ft.CompileCalls() {
+		assert.Truef(t, v.Shared, "%s not compiled with -shared", v.PackageImportPath)
+		if v.PackageImportPath == "main" {
+			assert.False(t, v.Complete)
+			assert.Contains(t, v.Files, filepath.Join(clib.ObjectDir, "clib.cgo1.go"))
+			assert.Contains(t, v.Files, filepath.Join(clib.ObjectDir, "_cgo_gotypes.go"))
+		}
+	}
+	ft.AssertCompiled(t, "runtime/cgo")
+	ft.AssertCalled(t, buildtest.CCompile)
+	ccompiled := map[string]bool{}
+	for _, v := range ft.CCompileCalls() {
+		assert.Contains(t, v.Flags, "-fPIC")
+		for _, f := range v.Files {
+			ccompiled[filepath.Base(f)] = true
+		}
+	}
+	// runtime/cgo has Go assembly and assembly for the C compiler.
+	assert.True(t, ccompiled["gcc_amd64.S"])
+	assembled := map[string]bool{}
+	for _, v := range ft.AssembleCalls() {
+		for _, f := range v.Files {
+			assembled[f] = true
+		}
+	}
+	assert.True(t, assembled["asm_amd64.s"])
+	assert.False(t, assembled["gcc_amd64.S"])
+	packed := false
+	for _, v := range ft.PackCalls() {
+		if v.WorkingDirectory == clib.ObjectDir {
+			packed = true
+			assert.Equal(t, filepath.Join(clib.ObjectDir, "dynimportfail"), v.Names[len(v.Names)-1])
+		}
+	}
+	assert.True(t, packed)
+
+	links := ft.LinkCalls()
+	require.Len(t, links, 1)
+	assert.Equal(t, build.BuildModeCShared, links[0].BuildMode)
+	assert.Equal(t, "clang", links[0].ExternalLinker)
+
+	// The build ID of a C archive is not read back with the buildid tool.
+	ft.Reset()
+	output = filepath.Join(dir, "libclib.a")
+	result, err = b.BuildCLibrary("example.com/clib", "", build.BuildModeCArchive, output)
+	require.NoError(t, err)
+	ft.AssertNotCalled(t, buildtest.BuildID)
+	assert.NotEmpty(t, result.BuildID)
+	assert.Equal(t, build.BuildModeCArchive, ft.LinkCalls()[0].BuildMode)
+}
+
+func TestBuildCLibraryError(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+	ctx.CgoEnabled = true
+
+	testCases := []struct {
+		ImportPath string
+		Mode       build.BuildMode
+		Error      string
+	}{
+		{"example.com/clib", build.BuildModeExe, "buildmode exe does not produce a C library"},
+		{"example.com/greet", build.BuildModeCArchive, "example.com/greet: a C library must be built from package main, not greet"},
+		{"example.com/hello", build.BuildModeCArchive, `example.com/hello: a C library must import "C"`},
+		{"example.com/nomain", build.BuildModeCArchive, "example.com/nomain: function main is undeclared in the main package"},
+		{"example.com/noexport", build.BuildModeCShared, "example.com/noexport: no function exported with //export"},
+	}
+	for _, tc := range testCases {
+		ft := buildtest.NewFakeTools()
+		b := &build.Builder{Tools: ft, Context: ctx}
+		_, err := b.BuildCLibrary(tc.ImportPath, "", tc.Mode, "out")
+		assert.EqualError(t, err, tc.Error)
+		ft.AssertNotCalled(t, buildtest.Compile)
+	}
+
+	ctx.CgoEnabled = false
+	b := &build.Builder{Context: ctx}
+	_, err := b.BuildCLibrary("example.com/clib", "", build.BuildModeCArchive, "out")
+	assert.EqualError(t, err, "buildmode c-archive requires cgo")
+
+	ctx.CgoEnabled = true
+	ctx.GOOS = "plan9"
+	b = &build.Builder{Context: ctx}
+	_, err = b.BuildCLibrary("example.com/clib", "", build.BuildModeCShared, "out")
+	assert.True(t, strings.HasPrefix(err.Error(), "buildmode c-shared not supported"), err.Error())
+}
+
+func TestBuildCLibraryDefaultTools(t *testing.T) {
+	ctx, restore := realCgoContext(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "clibrary")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	stderr := &strings.Builder{}
+	b := &build.Builder{Tools: build.DefaultTools, Context: ctx, Stderr: stderr}
+	output := filepath.Join(dir, "libclib.so")
+	result, err := b.BuildCLibrary("example.com/clib", "", build.BuildModeCShared, output)
+	require.NoError(t, err, stderr.String())
+	header, err := ioutil.ReadFile(result.Header)
+	require.NoError(t, err)
+	assert.Contains(t, string(header), "Add(")
+	f, err := elf.Open(output)
+	require.NoError(t, err)
+	defer f.Close()
+	assert.Equal(t, elf.ET_DYN, f.Type)
+	symbols, err := f.DynamicSymbols()
+	require.NoError(t, err)
+	exported := false
+	for _, v := range symbols {
+		exported = exported || v.Name == "Add"
+	}
+	assert.True(t, exported, "Add not exported")
+}
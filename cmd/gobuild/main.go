@@ -0,0 +1,412 @@
+// Gobuild builds Go packages with the build library, as a reference client
+// of its API.
+//
+// Usage:
+//
+//	gobuild <command> [flags] [package]
+//
+// The commands are:
+//
+//	build  build a package, linking commands
+//	test   build the test binary of a package and run it
+//	plan   print the packages of a build in order, without building
+//	cache  print whether each package of a build is up to date
+//	std    build the standard library, or a package of it, into the -cache directory
+//	env    print the build context of the go command
+//
+// The package defaults to the one in the current directory.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	gb "go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gophertest/build"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr, build.DefaultTools))
+}
+
+const usage = `usage: gobuild <command> [flags] [package]
+
+The commands are:
+
+	build  build a package, linking commands
+	test   build the test binary of a package and run it
+	plan   print the packages of a build in order, without building
+	cache  print whether each package of a build is up to date
+	std    build the standard library, or a package of it, into the -cache directory
+	env    print the build context of the go command
+
+Run "gobuild <command> -h" for the flags of a command.
+`
+
+// stringList is a flag that can be repeated.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// command is a command line, parsed into a Builder.
+type command struct {
+	name   string
+	stdout io.Writer
+	stderr io.Writer
+	tools  build.Tools
+
+	output   string
+	goos     string
+	goarch   string
+	tags     string
+	cgo      bool
+	verbose  bool
+	json     bool
+	compile  bool
+	race     bool
+	msan     bool
+	asan     bool
+	runner   build.TestRunner
+	defines  stringList
+	builder  build.Builder
+	pkg      string
+	buildCtx gb.Context
+}
+
+func run(args []string, stdout, stderr io.Writer, tools build.Tools) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	c := &command{name: args[0], stdout: stdout, stderr: stderr, tools: tools}
+	command := map[string]func() error{
+		"build": c.build,
+		"test":  c.test,
+		"plan":  c.plan,
+		"cache": c.cache,
+		"std":   c.std,
+		"env":   c.env,
+	}[c.name]
+	if command == nil {
+		fmt.Fprintf(stderr, "gobuild %s: unknown command\n%s", c.name, usage)
+		return 2
+	}
+	if err := c.parse(args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return 2
+		}
+		fmt.Fprintf(stderr, "gobuild %s: %v\n", c.name, err)
+		return 2
+	}
+	if err := command(); err != nil {
+		fmt.Fprintf(stderr, "gobuild %s: %v\n", c.name, err)
+		return 1
+	}
+	return 0
+}
+
+// parse parses the flags and package of the command line and sets up the
+// builder.
+func (c *command) parse(args []string) error {
+	ctx, err := c.tools.BuildCtx()
+	if err != nil {
+		return err
+	}
+	c.buildCtx = ctx
+	b := &c.builder
+	f := flag.NewFlagSet("gobuild "+c.name, flag.ContinueOnError)
+	f.SetOutput(c.stderr)
+	f.StringVar(&c.output, "o", "", "output file of the binary")
+	f.StringVar(&c.goos, "goos", ctx.GOOS, "GOOS to build for")
+	f.StringVar(&c.goarch, "goarch", ctx.GOARCH, "GOARCH to build for")
+	f.StringVar(&c.tags, "tags", "", "comma separated build tags")
+	f.BoolVar(&c.cgo, "cgo", ctx.CgoEnabled, "enable cgo, only by default when building for the host")
+	f.BoolVar(&c.verbose, "v", false, "print the packages as they are compiled")
+	f.BoolVar(&c.json, "json", false, "print the build events as JSON to stdout instead of the tool output (Builder.Events)")
+	f.BoolVar(&build.DebugLog, "x", false, "print the tool commands")
+	f.Var(&c.defines, "X", "set the string variable importpath.name=value, can be repeated")
+	f.Var((*buildModeFlag)(&b.BuildMode), "buildmode", "build mode of the main package (LinkArgs.BuildMode)")
+	f.BoolVar(&c.race, "race", false, "enable the race detector (Builder.Instrument)")
+	f.BoolVar(&c.msan, "msan", false, "enable interoperation with the memory sanitizer (Builder.Instrument)")
+	f.BoolVar(&c.asan, "asan", false, "enable interoperation with the address sanitizer (Builder.Instrument)")
+	f.StringVar(&b.ExternalLinker, "extld", "", "external linker (LinkArgs.ExternalLinker)")
+	f.BoolVar(&b.TrimPath, "trimpath", false, "remove file system paths from the outputs (CompileArgs.TrimPath)")
+	f.StringVar(&b.PGO, "pgo", "", "profile for profile-guided optimization: off, auto or a file (CompileArgs.ProfileFile)")
+	f.StringVar(&b.WorkDir, "work", "", "work directory, temporary if empty")
+	f.StringVar(&b.CacheDir, "cache", "", "cache directory of the package archives, no caching if empty")
+	f.BoolVar(&c.compile, "c", false, "only build the test binary, into the current directory if -o is not set")
+	f.StringVar(&c.runner.Pattern, "run", "", "run only the tests matching the regexp (TestRunner.Pattern)")
+	f.IntVar(&c.runner.Count, "count", 0, "run each test that many times (TestRunner.Count)")
+	f.DurationVar(&c.runner.Timeout, "timeout", 10*time.Minute, "panic test binaries running longer (TestRunner.Timeout)")
+	f.IntVar(&c.runner.Parallel, "parallel", 0, "maximum number of parallel tests (TestRunner.Parallel)")
+	f.IntVar(&c.runner.Shards, "shards", 0, "split the tests across that many processes (TestRunner.Shards)")
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	switch f.NArg() {
+	case 0:
+		c.pkg = "."
+	case 1:
+		c.pkg = f.Arg(0)
+	default:
+		return fmt.Errorf("too many packages %q", f.Args())
+	}
+
+	cgoSet := false
+	f.Visit(func(v *flag.Flag) {
+		cgoSet = cgoSet || v.Name == "cgo"
+	})
+	ctx.GOOS, ctx.GOARCH = c.goos, c.goarch
+	ctx.CgoEnabled = c.cgo
+	if !cgoSet && (c.goos != gb.Default.GOOS || c.goarch != gb.Default.GOARCH) {
+		ctx.CgoEnabled = false
+	}
+	if c.tags != "" {
+		ctx.BuildTags = strings.Split(c.tags, ",")
+	}
+	b.Tools = c.tools
+	b.Context = ctx
+	b.Stderr = c.stderr
+	if c.json {
+		b.Stderr = nil
+		b.Events = c.stdout
+	}
+	for _, v := range []struct {
+		set        bool
+		instrument build.Instrument
+	}{
+		{c.race, build.InstrumentRace},
+		{c.msan, build.InstrumentMSan},
+		{c.asan, build.InstrumentASan},
+	} {
+		if !v.set {
+			continue
+		}
+		if b.Instrument != "" {
+			return fmt.Errorf("may not use -%s and -%s simultaneously", b.Instrument, v.instrument)
+		}
+		b.Instrument = v.instrument
+	}
+	if len(c.defines) > 0 {
+		b.Stamp = &build.Stamp{Vars: map[string]string{}}
+		for _, v := range c.defines {
+			i := strings.Index(v, "=")
+			if i <= 0 {
+				return fmt.Errorf("-X %s: must be importpath.name=value", v)
+			}
+			b.Stamp.Vars[v[:i]] = v[i+1:]
+		}
+	}
+	return nil
+}
+
+type buildModeFlag build.BuildMode
+
+func (m *buildModeFlag) String() string {
+	return string(*m)
+}
+
+func (m *buildModeFlag) Set(v string) error {
+	*m = buildModeFlag(v)
+	return nil
+}
+
+func (c *command) build() error {
+	plan, err := c.builder.Load(c.pkg, ".")
+	if err != nil {
+		return err
+	}
+	output := c.output
+	if output == "" && plan.Main.Name == "main" {
+		output = c.binaryName(plan.Main.Package, "")
+	}
+	result, err := c.builder.BuildPlan(plan, output)
+	c.printCompiled(plan)
+	if err != nil {
+		return err
+	}
+	if c.verbose {
+		fmt.Fprintf(c.stderr, "build ID %s\n", result.BuildID)
+	}
+	return nil
+}
+
+func (c *command) test() error {
+	pkg, err := c.builder.Context.Import(c.pkg, ".", gb.FindOnly)
+	if err != nil {
+		return err
+	}
+	dir, err := ioutil.TempDir("", "gobuild")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	output := c.output
+	if output == "" {
+		output = c.binaryName(pkg, ".test")
+		if !c.compile {
+			output = filepath.Join(dir, output)
+		}
+	}
+	plan, err := c.builder.LoadTest(c.pkg, ".", dir)
+	if err != nil {
+		return err
+	}
+	_, err = c.builder.BuildPlan(plan, output)
+	c.printCompiled(plan)
+	if err != nil || c.compile {
+		return err
+	}
+	return c.runTest(pkg, output)
+}
+
+// runTest runs the test binary of pkg like "go test", printing the output of
+// the failed tests.
+func (c *command) runTest(pkg *gb.Package, binary string) error {
+	version, err := c.tools.Version()
+	if err != nil {
+		return err
+	}
+	r := c.runner
+	r.Package = pkg.ImportPath
+	r.Version = version
+	r.Dir = pkg.Dir
+	if c.json {
+		r.Events = c.stdout
+	}
+	report, err := r.Run(context.Background(), binary)
+	if err != nil {
+		return err
+	}
+	if !c.json {
+		for _, v := range report.Failed() {
+			fmt.Fprint(c.stdout, v.Output)
+		}
+		fmt.Fprint(c.stdout, report.Output)
+		status := "ok  "
+		if report.Action != "pass" {
+			status = "FAIL"
+		}
+		fmt.Fprintf(c.stdout, "%s\t%s\t%.3fs\n", status, pkg.ImportPath, report.Elapsed.Seconds())
+	}
+	if report.Action != "pass" {
+		return fmt.Errorf("tests failed")
+	}
+	return nil
+}
+
+func (c *command) plan() error {
+	plan, err := c.builder.Load(c.pkg, ".")
+	if err != nil {
+		return err
+	}
+	for _, p := range plan.Packages {
+		fmt.Fprintf(c.stdout, "%s\t%s\n", p.ImportPath, p.Dir)
+	}
+	return nil
+}
+
+func (c *command) cache() error {
+	plan, err := c.builder.Load(c.pkg, ".")
+	if err != nil {
+		return err
+	}
+	stale, err := c.builder.Stale(plan)
+	if err != nil {
+		return err
+	}
+	reasons := map[*build.Package]string{}
+	for _, v := range stale {
+		reasons[v.Package] = v.Reason
+	}
+	for _, p := range plan.Packages {
+		if reason, ok := reasons[p]; ok {
+			fmt.Fprintf(c.stdout, "stale\t%s\t%s\n", p.ImportPath, reason)
+		} else {
+			fmt.Fprintf(c.stdout, "ok\t%s\t%s\n", p.ImportPath, p.BuildID)
+		}
+	}
+	return nil
+}
+
+// std builds the standard library, or the package given and its
+// dependencies, writing its importcfg to -o if set.
+func (c *command) std() error {
+	pkgs := []string(nil)
+	if c.pkg != "." {
+		pkgs = append(pkgs, c.pkg)
+	}
+	result, err := c.builder.BuildStd(pkgs...)
+	if err != nil {
+		return err
+	}
+	c.printCompiled(&build.Plan{Packages: result.Packages})
+	if c.output == "" {
+		return nil
+	}
+	return result.ImportConfig.Write(c.output)
+}
+
+// env prints the context returned by BuildCtx, before the flags apply.
+func (c *command) env() error {
+	ctx := c.buildCtx
+	cgo := "0"
+	if ctx.CgoEnabled {
+		cgo = "1"
+	}
+	for _, v := range [][2]string{
+		{"GOOS", ctx.GOOS},
+		{"GOARCH", ctx.GOARCH},
+		{"GOROOT", ctx.GOROOT},
+		{"GOPATH", ctx.GOPATH},
+		{"CGO_ENABLED", cgo},
+		{"Compiler", ctx.Compiler},
+		{"BuildTags", strings.Join(ctx.BuildTags, ",")},
+		{"ReleaseTags", strings.Join(ctx.ReleaseTags, ",")},
+	} {
+		fmt.Fprintf(c.stdout, "%s=%q\n", v[0], v[1])
+	}
+	return nil
+}
+
+// printCompiled prints the packages compiled by a build with -v.
+func (c *command) printCompiled(plan *build.Plan) {
+	if !c.verbose {
+		return
+	}
+	for _, p := range plan.Packages {
+		if p.Archive != "" && !p.Cached {
+			fmt.Fprintln(c.stderr, p.ImportPath)
+		}
+	}
+}
+
+// binaryName returns the default name of the binary of p in the current
+// directory, like the go command.
+func (c *command) binaryName(p *gb.Package, suffix string) string {
+	name := path.Base(p.ImportPath)
+	if strings.HasPrefix(p.ImportPath, "_/") {
+		// Package outside GOPATH.
+		name = filepath.Base(p.Dir)
+	}
+	name += suffix
+	if c.builder.Context.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	gb "go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/gophertest/build/buildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testTools(t *testing.T) (*buildtest.FakeTools, func()) {
+	gopath, err := filepath.Abs(filepath.Join("..", "..", "testdata"))
+	require.NoError(t, err)
+	value, ok := os.LookupEnv("GO111MODULE")
+	os.Setenv("GO111MODULE", "off")
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	ft.Context = gb.Default
+	ft.Context.GOOS = "linux"
+	ft.Context.GOARCH = "amd64"
+	ft.Context.GOPATH = gopath
+	ft.Context.CgoEnabled = false
+	return ft, func() {
+		if ok {
+			os.Setenv("GO111MODULE", value)
+		} else {
+			os.Unsetenv("GO111MODULE")
+		}
+	}
+}
+
+// realTools sets up the go command to resolve packages from testdata,
+// skipping the test if its tools are not installed. The returned func
+// restores the environment.
+func realTools(t *testing.T) func() {
+	if testing.Short() {
+		t.Skip("builds with the go toolchain")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	for _, v := range []string{"asm", "compile", "link", "pack", "buildid"} {
+		if _, err := os.Stat(filepath.Join(gb.ToolDir, v)); err != nil {
+			t.Skipf("go toolchain not installed: %v", err)
+		}
+	}
+	ft, restore := testTools(t)
+	gopath, ok := os.LookupEnv("GOPATH")
+	os.Setenv("GOPATH", ft.Context.GOPATH)
+	return func() {
+		if ok {
+			os.Setenv("GOPATH", gopath)
+		} else {
+			os.Unsetenv("GOPATH")
+		}
+		restore()
+	}
+}
+
+func runCommand(ft *buildtest.FakeTools, args ...string) (int, string, string) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	code := run(args, stdout, stderr, ft)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestBuild(t *testing.T) {
+	ft, restore := testTools(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "gobuild")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "hello")
+	code, _, stderr := runCommand(ft, "build", "-o", output, "-v", "-trimpath", "-buildmode", "pie", "-extld", "clang", "example.com/hello")
+	require.Equal(t, 0, code, stderr)
+	assert.FileExists(t, output)
+	assert.Contains(t, stderr, "example.com/greet\n")
+	assert.Contains(t, stderr, "build ID ")
+
+	links := ft.LinkCalls()
+	require.Len(t, links, 1)
+	assert.Equal(t, build.BuildModePIE, links[0].BuildMode)
+	assert.Equal(t, "clang", links[0].ExternalLinker)
+	assert.True(t, links[0].TrimGOROOT)
+	for _, v := range ft.CompileCalls() {
+		assert.Equal(t, "linux", v.Context.GOOS)
+		assert.Contains(t, v.TrimPath, v.Context.GOPATH)
+	}
+}
+
+func TestBuildJSON(t *testing.T) {
+	ft, restore := testTools(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "gobuild")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ft.Respond(buildtest.Link, buildtest.Response{Stderr: "link failed\n", ExitCode: 2})
+	code, stdout, stderr := runCommand(ft, "build", "-json", "-o", filepath.Join(dir, "hello"), "example.com/hello")
+	assert.Equal(t, 1, code)
+	assert.NotContains(t, stderr, "link failed")
+	assert.Contains(t, stdout, `"Action":"build-output","ImportPath":"example.com/hello","Output":"link failed\n"}`)
+	assert.Contains(t, stdout, `"Action":"build-fail","ImportPath":"example.com/hello"`)
+}
+
+func TestBuildCrossCompile(t *testing.T) {
+	ft, restore := testTools(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "gobuild")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ft.Context.CgoEnabled = true
+	code, _, stderr := runCommand(ft, "build", "-o", filepath.Join(dir, "hello.exe"), "-goos", "windows", "-goarch", "386", "example.com/hello")
+	require.Equal(t, 0, code, stderr)
+	for _, v := range ft.CompileCalls() {
+		assert.Equal(t, "windows", v.Context.GOOS)
+		assert.Equal(t, "386", v.Context.GOARCH)
+		assert.False(t, v.Context.CgoEnabled)
+	}
+}
+
+func TestTest(t *testing.T) {
+	ft, restore := testTools(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "gobuild")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "tested.test")
+	code, _, stderr := runCommand(ft, "test", "-c", "-o", output, "example.com/tested")
+	require.Equal(t, 0, code, stderr)
+	assert.FileExists(t, output)
+	ft.AssertCompiled(t, "example.com/tested_test")
+
+	// The fake binary cannot be run.
+	code, _, stderr = runCommand(ft, "test", "-run", "TestAdd", "example.com/tested")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "gobuild test: ")
+}
+
+func TestPlan(t *testing.T) {
+	ft, restore := testTools(t)
+	defer restore()
+
+	code, stdout, stderr := runCommand(ft, "plan", "example.com/hello")
+	require.Equal(t, 0, code, stderr)
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	assert.True(t, strings.HasPrefix(lines[len(lines)-1], "example.com/hello\t"+filepath.Join(ft.Context.GOPATH, "src", "example.com", "hello")))
+	assert.Contains(t, stdout, "example.com/greet\t")
+	ft.AssertNotCalled(t, buildtest.Compile)
+}
+
+func TestCache(t *testing.T) {
+	ft, restore := testTools(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "gobuild")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cache := filepath.Join(dir, "cache")
+	code, stdout, stderr := runCommand(ft, "cache", "-cache", cache, "example.com/greet")
+	require.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "stale\texample.com/greet\tnot built\n")
+
+	code, _, stderr = runCommand(ft, "build", "-cache", cache, "example.com/greet")
+	require.Equal(t, 0, code, stderr)
+	code, stdout, stderr = runCommand(ft, "cache", "-cache", cache, "example.com/greet")
+	require.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "ok\texample.com/greet\t")
+	assert.NotContains(t, stdout, "stale")
+}
+
+func TestStd(t *testing.T) {
+	ft, restore := testTools(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "gobuild")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	code, _, stderr := runCommand(ft, "std")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "requires a cache directory")
+
+	cache := filepath.Join(dir, "cache")
+	importcfg := filepath.Join(dir, "importcfg")
+	code, _, stderr = runCommand(ft, "std", "-v", "-cache", cache, "-o", importcfg)
+	require.Equal(t, 0, code, stderr)
+	assert.Contains(t, stderr, "\nfmt\n")
+	ft.AssertCompiled(t, "fmt")
+	cfg, err := build.ReadImportConfig(importcfg)
+	require.NoError(t, err)
+	assert.Equal(t, cache, filepath.Dir(cfg.PackageFile["fmt"]))
+
+	ft.Reset()
+	code, _, stderr = runCommand(ft, "std", "-cache", cache, "-o", importcfg, "fmt")
+	require.Equal(t, 0, code, stderr)
+	cfg, err = build.ReadImportConfig(importcfg)
+	require.NoError(t, err)
+	assert.Contains(t, cfg.PackageFile, "fmt")
+	assert.NotContains(t, cfg.PackageFile, "net/http")
+	ft.AssertNotCalled(t, buildtest.Compile)
+
+	code, _, stderr = runCommand(ft, "std", "-cache", cache, "example.com/greet")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "example.com/greet is not in the standard library")
+}
+
+func TestEnv(t *testing.T) {
+	ft, restore := testTools(t)
+	defer restore()
+
+	code, stdout, stderr := runCommand(ft, "env", "-goos", "windows")
+	require.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "GOOS=\"linux\"\n")
+	assert.Contains(t, stdout, "GOPATH="+`"`+ft.Context.GOPATH+`"`+"\n")
+	assert.Contains(t, stdout, "CGO_ENABLED=\"0\"\n")
+	ft.AssertCalled(t, buildtest.BuildCtx)
+}
+
+func TestErrors(t *testing.T) {
+	ft, restore := testTools(t)
+	defer restore()
+
+	testCases := []struct {
+		Args   []string
+		Code   int
+		Stderr string
+	}{
+		{nil, 2, "usage: gobuild"},
+		{[]string{"frob"}, 2, "gobuild frob: unknown command"},
+		{[]string{"build", "-nope"}, 2, "flag provided but not defined: -nope"},
+		{[]string{"build", "a", "b"}, 2, `gobuild build: too many packages ["a" "b"]`},
+		{[]string{"build", "-X", "novalue", "example.com/hello"}, 2, "gobuild build: -X novalue: must be importpath.name=value"},
+		{[]string{"plan", "example.com/missing"}, 1, "gobuild plan: cannot find package"},
+		{[]string{"build", "-race", "-msan", "example.com/hello"}, 2, "gobuild build: may not use -race and -msan simultaneously"},
+		{[]string{"build", "-race", "-goarch", "386", "example.com/hello"}, 1, "gobuild build: -race is not supported on linux/386"},
+	}
+	for _, tc := range testCases {
+		code, _, stderr := runCommand(ft, tc.Args...)
+		assert.Equal(t, tc.Code, code, "%q", tc.Args)
+		assert.Contains(t, stderr, tc.Stderr, "%q", tc.Args)
+	}
+}
+
+func TestDefaultTools(t *testing.T) {
+	defer realTools(t)()
+	dir, err := ioutil.TempDir("", "gobuild")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	output := filepath.Join(dir, "hello")
+	code := run([]string{"build", "-cgo=false", "-o", output, "example.com/hello"}, stdout, stderr, build.DefaultTools)
+	require.Equal(t, 0, code, stderr.String())
+	out, err := exec.Command(output).CombinedOutput()
+	require.NoError(t, err, string(out))
+	assert.Equal(t, "hello, world\n", string(out))
+
+	code = run([]string{"test", "-cgo=false", "-cache", filepath.Join(dir, "cache"), "example.com/tested"}, stdout, stderr, build.DefaultTools)
+	require.Equal(t, 0, code, stderr.String())
+	assert.Contains(t, stdout.String(), "ok  \texample.com/tested\t")
+}
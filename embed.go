@@ -0,0 +1,172 @@
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	gb "go/build"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// EmbedConfig is the content of the file passed to the compiler with
+// "-embedcfg", resolving the //go:embed patterns of a package.
+type EmbedConfig struct {
+	// Patterns maps each pattern to the files it matches, relative to the
+	// package directory and slash separated.
+	Patterns map[string][]string
+	// Files maps each matched file to its path.
+	Files map[string]string
+}
+
+// NewEmbedConfig resolves the EmbedPatterns of p against its directory, with
+// the rules of the go command: hidden files and files starting with "_" are
+// only embedded from directories when named or with the "all:" prefix,
+// version control directories, other modules and irregular files such as
+// symlinks are never embedded. It returns nil if p embeds nothing.
+func NewEmbedConfig(p *gb.Package) (*EmbedConfig, error) {
+	if len(p.EmbedPatterns) == 0 {
+		return nil, nil
+	}
+	cfg := &EmbedConfig{Patterns: map[string][]string{}, Files: map[string]string{}}
+	for _, pattern := range p.EmbedPatterns {
+		files, err := resolveEmbed(p.Dir, pattern)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %s: %v", pattern, err)
+		}
+		cfg.Patterns[pattern] = files
+		for _, v := range files {
+			cfg.Files[v] = filepath.Join(p.Dir, filepath.FromSlash(v))
+		}
+	}
+	return cfg, nil
+}
+
+// Write writes the config to file as JSON.
+func (c *EmbedConfig) Write(file string) error {
+	data, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0666)
+}
+
+// resolveEmbed returns the files of dir matched by pattern.
+func resolveEmbed(dir, pattern string) ([]string, error) {
+	glob := pattern
+	all := strings.HasPrefix(glob, "all:")
+	if all {
+		glob = glob[len("all:"):]
+	}
+	if _, err := path.Match(glob, ""); err != nil || !validEmbedPattern(glob) {
+		return nil, fmt.Errorf("invalid pattern syntax")
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, filepath.FromSlash(glob)))
+	if err != nil {
+		return nil, err
+	}
+
+	have := map[string]bool{}
+	list := []string(nil)
+	add := func(rel string) {
+		if !have[rel] {
+			have[rel] = true
+			list = append(list, rel)
+		}
+	}
+	for _, file := range matches {
+		rel := filepath.ToSlash(file[len(dir)+1:])
+		for _, elem := range strings.Split(rel, "/") {
+			if badEmbedName(elem) {
+				return nil, fmt.Errorf("cannot embed %s: invalid name %s", rel, elem)
+			}
+		}
+		// Files must be in the module of the package, and not below a file.
+		for d := file; len(d) > len(dir)+1; d = filepath.Dir(d) {
+			if _, err := os.Stat(filepath.Join(d, "go.mod")); err == nil {
+				return nil, fmt.Errorf("cannot embed %s: in different module", rel)
+			}
+			if d != file {
+				if info, err := os.Lstat(d); err == nil && !info.IsDir() {
+					return nil, fmt.Errorf("cannot embed %s: in non-directory %s", rel, d[len(dir)+1:])
+				}
+			}
+		}
+
+		info, err := os.Lstat(file)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case info.Mode().IsRegular():
+			add(rel)
+		case info.IsDir():
+			count := 0
+			err := filepath.Walk(file, func(walk string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				rel := filepath.ToSlash(walk[len(dir)+1:])
+				name := info.Name()
+				if walk != file && (badEmbedName(name) || ((name[0] == '.' || name[0] == '_') && !all)) {
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if info.IsDir() {
+					if _, err := os.Stat(filepath.Join(walk, "go.mod")); err == nil && walk != file {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if !info.Mode().IsRegular() {
+					return nil
+				}
+				count++
+				add(rel)
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			if count == 0 {
+				return nil, fmt.Errorf("cannot embed directory %s: contains no embeddable files", rel)
+			}
+		default:
+			return nil, fmt.Errorf("cannot embed irregular file %s", rel)
+		}
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("no matching files found")
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// validEmbedPattern reports whether pattern is a clean, slash separated,
+// relative path.
+func validEmbedPattern(pattern string) bool {
+	if pattern == "." || pattern == "" {
+		return false
+	}
+	for _, elem := range strings.Split(pattern, "/") {
+		if elem == "" || elem == "." || elem == ".." || strings.Contains(elem, `\`) {
+			return false
+		}
+	}
+	return true
+}
+
+// badEmbedName reports whether name is never embedded, like version control
+// directories.
+func badEmbedName(name string) bool {
+	switch name {
+	case "", ".bzr", ".hg", ".git", ".svn":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package build_test
+
+import (
+	"encoding/json"
+	gb "go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/gophertest/build/buildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewEmbedConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "embed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, v := range []string{
+		"file.txt",
+		"static/a.txt",
+		"static/.hidden",
+		"static/_under",
+		"static/sub/b.txt",
+		"static/.git/config",
+		"static/mod/go.mod",
+		"static/mod/c.txt",
+		"mod/go.mod",
+		"mod/c.txt",
+		"empty/.hidden",
+	} {
+		file := filepath.Join(dir, filepath.FromSlash(v))
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0777))
+		require.NoError(t, ioutil.WriteFile(file, []byte(v), 0666))
+	}
+	require.NoError(t, os.Symlink("file.txt", filepath.Join(dir, "link.txt")))
+	require.NoError(t, os.Symlink("../file.txt", filepath.Join(dir, "static", "link.txt")))
+
+	testCases := []struct {
+		Pattern  string
+		Expected []string
+		Error    string
+	}{
+		{"static", []string{"static/a.txt", "static/sub/b.txt"}, ""},
+		{"all:static", []string{"static/.hidden", "static/_under", "static/a.txt", "static/sub/b.txt"}, ""},
+		{"static/.hidden", []string{"static/.hidden"}, ""},
+		{"*.txt", nil, "pattern *.txt: cannot embed irregular file link.txt"},
+		{"f*.txt", []string{"file.txt"}, ""},
+		{"../file.txt", nil, "pattern ../file.txt: invalid pattern syntax"},
+		{"[", nil, "pattern [: invalid pattern syntax"},
+		{".", nil, "pattern .: invalid pattern syntax"},
+		{"missing*", nil, "pattern missing*: no matching files found"},
+		{"mod/c.txt", nil, "pattern mod/c.txt: cannot embed mod/c.txt: in different module"},
+		{"empty", nil, "pattern empty: cannot embed directory empty: contains no embeddable files"},
+		{"static/.git/config", nil, "pattern static/.git/config: cannot embed static/.git/config: invalid name .git"},
+		{"file.txt/x", nil, "pattern file.txt/x: no matching files found"},
+	}
+	for _, tc := range testCases {
+		p := &gb.Package{Dir: dir, EmbedPatterns: []string{tc.Pattern}}
+		cfg, err := build.NewEmbedConfig(p)
+		if tc.Error != "" {
+			assert.EqualErrorf(t, err, tc.Error, "failed with %s", tc.Pattern)
+			continue
+		}
+		require.NoErrorf(t, err, "failed with %s", tc.Pattern)
+		assert.Equalf(t, tc.Expected, cfg.Patterns[tc.Pattern], "failed with %s", tc.Pattern)
+		for _, v := range tc.Expected {
+			assert.Equal(t, filepath.Join(dir, filepath.FromSlash(v)), cfg.Files[v])
+		}
+	}
+
+	cfg, err := build.NewEmbedConfig(&gb.Package{Dir: dir})
+	require.NoError(t, err)
+	assert.Nil(t, cfg)
+
+	cfg, err = build.NewEmbedConfig(&gb.Package{Dir: dir, EmbedPatterns: []string{"f*.txt", "file.txt"}})
+	require.NoError(t, err)
+	file := filepath.Join(dir, "embedcfg")
+	require.NoError(t, cfg.Write(file))
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	read := map[string]map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(data, &read))
+	assert.Equal(t, map[string]interface{}{
+		"f*.txt":   []interface{}{"file.txt"},
+		"file.txt": []interface{}{"file.txt"},
+	}, read["Patterns"])
+	assert.Equal(t, map[string]interface{}{"file.txt": filepath.Join(dir, "file.txt")}, read["Files"])
+}
+
+func TestBuilderEmbed(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "embed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	b := &build.Builder{Tools: ft, Context: ctx, WorkDir: dir}
+	_, err = b.Build("example.com/embedded", "", "")
+	require.NoError(t, err)
+
+	for _, v := range ft.CompileCalls() {
+		if v.PackageImportPath != "example.com/embedded" {
+			assert.Empty(t, v.EmbedConfigFile)
+			continue
+		}
+		data, err := ioutil.ReadFile(v.EmbedConfigFile)
+		require.NoError(t, err)
+		cfg := build.EmbedConfig{}
+		require.NoError(t, json.Unmarshal(data, &cfg))
+		assert.Equal(t, map[string][]string{"greeting.txt": {"greeting.txt"}}, cfg.Patterns)
+	}
+	ft.AssertCompiled(t, "example.com/embedded")
+}
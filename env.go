@@ -0,0 +1,111 @@
+package build
+
+import (
+	gb "go/build"
+	"os"
+	"strings"
+)
+
+// EnvPolicy controls which variables of the process environment are passed
+// on to the tools.
+type EnvPolicy int
+
+const (
+	// EnvDefault uses the policy of the Tools, which itself defaults to
+	// EnvInherit.
+	EnvDefault EnvPolicy = iota
+	// EnvInherit passes the whole process environment.
+	EnvInherit
+	// EnvAllowlist passes only the variables named in Env.Allowlist.
+	EnvAllowlist
+	// EnvHermetic passes none of the process environment, only Env.Vars and
+	// the variables derived from the build context.
+	EnvHermetic
+)
+
+// Env describes the environment the tools are run with.
+//
+// The environment is built from the process environment filtered by Policy,
+// followed by Vars. The variables derived from the gb.Context of the args
+// (GOOS, GOARCH, GOROOT, GOPATH and CGO_ENABLED) always take precedence.
+type Env struct {
+	// Policy applied to the process environment.
+	Policy EnvPolicy
+	// Allowlist of variable names passed through with EnvAllowlist.
+	Allowlist []string
+	// Vars are extra "KEY=value" variables, overriding inherited ones.
+	Vars []string
+}
+
+// mergeEnv resolves the per call env against the env of the tools. A call
+// env without policy or allowlist uses those of the tools, Vars of both are
+// combined with the call ones taking precedence.
+func mergeEnv(tools Env, call Env) Env {
+	env := Env{
+		Policy:    call.Policy,
+		Allowlist: call.Allowlist,
+	}
+	if env.Policy == EnvDefault {
+		env.Policy = tools.Policy
+	}
+	if env.Allowlist == nil {
+		env.Allowlist = tools.Allowlist
+	}
+	env.Vars = append(append([]string(nil), tools.Vars...), call.Vars...)
+	return env
+}
+
+// environ returns the variables of the process environment allowed by env
+// followed by env.Vars.
+func (env Env) environ() []string {
+	vars := []string(nil)
+	switch env.Policy {
+	case EnvDefault, EnvInherit:
+		vars = os.Environ()
+	case EnvAllowlist:
+		for _, v := range env.Allowlist {
+			if value, ok := os.LookupEnv(v); ok {
+				vars = append(vars, v+"="+value)
+			}
+		}
+	case EnvHermetic:
+	}
+	for _, v := range env.Vars {
+		vars = setEnv(vars, v)
+	}
+	return vars
+}
+
+// contextEnv returns vars with the variables derived from buildCtx set.
+func contextEnv(vars []string, buildCtx gb.Context) []string {
+	vars = setEnv(vars, "GOARCH="+buildCtx.GOARCH)
+	vars = setEnv(vars, "GOOS="+buildCtx.GOOS)
+	vars = setEnv(vars, "GOROOT="+buildCtx.GOROOT)
+	vars = setEnv(vars, "GOPATH="+buildCtx.GOPATH)
+	if buildCtx.CgoEnabled {
+		vars = setEnv(vars, "CGO_ENABLED=1")
+	} else {
+		vars = setEnv(vars, "CGO_ENABLED=0")
+	}
+	return vars
+}
+
+// setEnv appends the "KEY=value" variable kv to vars, dropping any earlier
+// value of KEY.
+func setEnv(vars []string, kv string) []string {
+	key := envKey(kv)
+	newVars := vars[:0]
+	for _, v := range vars {
+		if envKey(v) != key {
+			newVars = append(newVars, v)
+		}
+	}
+	return append(newVars, kv)
+}
+
+func envKey(kv string) string {
+	if i := strings.Index(kv, "="); i >= 0 {
+		return kv[:i]
+	}
+	return kv
+}
@@ -0,0 +1,96 @@
+package build_test
+
+import (
+	"bytes"
+	"fmt"
+	gb "go/build"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnv(t *testing.T) {
+	if os.Getenv("TEST_SUBPROCESS") == "1" {
+		env := []string(nil)
+		for _, v := range os.Environ() {
+			switch strings.SplitN(v, "=", 2)[0] {
+			case "CGO_ENABLED", "GOARCH", "GOCACHE", "GOFLAGS", "GOOS", "GOPATH", "GOROOT", "GOTEST_ALLOWED":
+				env = append(env, v)
+			case "PATH":
+				env = append(env, "PATH")
+			}
+		}
+		sort.Strings(env)
+		fmt.Fprint(os.Stdout, strings.Join(env, " "))
+		os.Exit(0)
+	}
+
+	buildCtx := gb.Context{
+		GOOS:   "goos",
+		GOARCH: "goarch",
+		GOPATH: "go/path",
+		GOROOT: "go/root",
+	}
+	testCases := []struct {
+		ToolsEnv build.Env
+		Env      build.Env
+		Expected string
+	}{
+		{
+			build.Env{},
+			build.Env{},
+			"CGO_ENABLED=0 GOARCH=goarch GOFLAGS=-leaked GOOS=goos GOPATH=go/path GOROOT=go/root GOTEST_ALLOWED=yes PATH",
+		},
+		{
+			build.Env{Policy: build.EnvHermetic, Vars: []string{"TEST_SUBPROCESS=1"}},
+			build.Env{Vars: []string{"GOCACHE=cache", "GOOS=ignored"}},
+			"CGO_ENABLED=0 GOARCH=goarch GOCACHE=cache GOOS=goos GOPATH=go/path GOROOT=go/root",
+		},
+		{
+			build.Env{Policy: build.EnvHermetic},
+			build.Env{Policy: build.EnvAllowlist, Allowlist: []string{"TEST_SUBPROCESS", "GOTEST_ALLOWED"}},
+			"CGO_ENABLED=0 GOARCH=goarch GOOS=goos GOPATH=go/path GOROOT=go/root GOTEST_ALLOWED=yes",
+		},
+		{
+			build.Env{Policy: build.EnvAllowlist, Allowlist: []string{"TEST_SUBPROCESS"}, Vars: []string{"GOFLAGS=-tools"}},
+			build.Env{Vars: []string{"GOFLAGS=-call"}},
+			"CGO_ENABLED=0 GOARCH=goarch GOFLAGS=-call GOOS=goos GOPATH=go/path GOROOT=go/root",
+		},
+	}
+
+	os.Setenv("TEST_SUBPROCESS", "1")
+	defer os.Setenv("TEST_SUBPROCESS", "")
+	defer restoreEnv("GOFLAGS")()
+	defer restoreEnv("GOTEST_ALLOWED")()
+	os.Setenv("GOFLAGS", "-leaked")
+	os.Setenv("GOTEST_ALLOWED", "yes")
+	for c, tc := range testCases {
+		tools := build.NewCmdTools()
+		tools.Env = tc.ToolsEnv
+		tools.Compiler = os.Args[0]
+		tools.CompilerArgs = []string{"-test.run=TestEnv", "--"}
+		stdout := &bytes.Buffer{}
+		err := tools.Compile(build.CompileArgs{
+			Context: buildCtx,
+			Stdout:  stdout,
+			Env:     tc.Env,
+		})
+		assert.NoErrorf(t, err, "failed with case %d", c)
+		assert.Equalf(t, tc.Expected, stdout.String(), "failed with case %d", c)
+	}
+}
+
+func restoreEnv(key string) func() {
+	value, ok := os.LookupEnv(key)
+	return func() {
+		if ok {
+			os.Setenv(key, value)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Actions of BuildEvents. EventOutput and EventFail are those of
+// "go build -json", EventStart and EventPass those of test2json.
+const (
+	// EventStart is emitted before a package is built.
+	EventStart = "start"
+	// EventCacheHit is emitted when the archive of a package is up to date.
+	EventCacheHit = "cache-hit"
+	// EventCacheMiss is emitted when a package must be compiled, with the
+	// reason in Output.
+	EventCacheMiss = "cache-miss"
+	// EventTool is emitted when a tool is run, named by Tool.
+	EventTool = "tool"
+	// EventOutput is a line of output of a tool.
+	EventOutput = "build-output"
+	// EventDiagnostic is a line of output of a tool reporting a position,
+	// emitted after its EventOutput.
+	EventDiagnostic = "diagnostic"
+	// EventPass is emitted when a package or binary was built.
+	EventPass = "pass"
+	// EventFail is emitted when a package or binary failed to build, after
+	// the error as EventOutput.
+	EventFail = "build-fail"
+)
+
+// BuildEvent is a JSON event of the progress of a build. The fields shared
+// with "go build -json" and test2json have the same names.
+type BuildEvent struct {
+	Time       *time.Time `json:",omitempty"`
+	Action     string
+	ImportPath string  `json:",omitempty"`
+	Elapsed    float64 `json:",omitempty"`
+	Output     string  `json:",omitempty"`
+	// Tool is the tool run, for EventTool.
+	Tool string `json:",omitempty"`
+	// File is the output file of the tool, for EventTool.
+	File string `json:",omitempty"`
+	// Pos is the "file:line:column" position of an EventDiagnostic, with
+	// Output the message.
+	Pos string `json:",omitempty"`
+}
+
+// EventWriter writes BuildEvents to an io.Writer as JSON, one per line. It
+// is safe for concurrent use.
+type EventWriter struct {
+	mutex sync.Mutex
+	enc   *json.Encoder
+	// Now returns the Time of the events, time.Now if nil.
+	Now func() time.Time
+}
+
+// NewEventWriter returns an EventWriter writing to w.
+func NewEventWriter(w io.Writer) *EventWriter {
+	return &EventWriter{enc: json.NewEncoder(w)}
+}
+
+// Emit writes e, setting its Time if missing.
+func (w *EventWriter) Emit(e BuildEvent) error {
+	if e.Time == nil {
+		now := time.Now()
+		if w.Now != nil {
+			now = w.Now()
+		}
+		e.Time = &now
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.enc.Encode(e)
+}
+
+// NewEventTools wraps tools to emit an EventTool for every tool run and the
+// output of the tools as EventOutput and EventDiagnostic events, for the
+// package importPath. The output is still written to the writers of the args.
+// Events are best effort, errors writing them are ignored.
+func NewEventTools(tools Tools, events *EventWriter, importPath string) Tools {
+	return &eventTools{Tools: tools, events: events, importPath: importPath}
+}
+
+type eventTools struct {
+	Tools
+	events     *EventWriter
+	importPath string
+}
+
+func (et *eventTools) emit(action, output string) {
+	et.events.Emit(BuildEvent{Action: action, ImportPath: et.importPath, Output: output})
+}
+
+// run emits the EventTool of tool and returns writers emitting the lines
+// written to stdout and stderr, and a func flushing them.
+func (et *eventTools) run(tool, file string, stdout, stderr io.Writer) (io.Writer, io.Writer, func()) {
+	et.events.Emit(BuildEvent{Action: EventTool, ImportPath: et.importPath, Tool: tool, File: file})
+	out := &eventLineWriter{w: stdout, tools: et}
+	if stderr == stdout {
+		return out, out, out.flush
+	}
+	errOut := &eventLineWriter{w: stderr, tools: et}
+	return out, errOut, func() {
+		out.flush()
+		errOut.flush()
+	}
+}
+
+func (et *eventTools) Assemble(args AssembleArgs) error {
+	var flush func()
+	args.Stdout, args.Stderr, flush = et.run("asm", args.OutputFile, args.Stdout, args.Stderr)
+	defer flush()
+	return et.Tools.Assemble(args)
+}
+
+func (et *eventTools) Compile(args CompileArgs) error {
+	var flush func()
+	args.Stdout, args.Stderr, flush = et.run("compile", args.OutputFile, args.Stdout, args.Stderr)
+	defer flush()
+	return et.Tools.Compile(args)
+}
+
+func (et *eventTools) Link(args LinkArgs) error {
+	var flush func()
+	args.Stdout, args.Stderr, flush = et.run("link", args.OutputFile, args.Stdout, args.Stderr)
+	defer flush()
+	return et.Tools.Link(args)
+}
+
+func (et *eventTools) Pack(args PackArgs) error {
+	var flush func()
+	args.Stdout, args.Stderr, flush = et.run("pack", args.ObjectFile, args.Stdout, args.Stderr)
+	defer flush()
+	return et.Tools.Pack(args)
+}
+
+func (et *eventTools) BuildID(args BuildIDArgs) (string, error) {
+	var flush func()
+	_, args.Stderr, flush = et.run("buildid", args.ObjectFile, nil, args.Stderr)
+	defer flush()
+	return et.Tools.BuildID(args)
+}
+
+func (et *eventTools) Cgo(args CgoArgs) error {
+	var flush func()
+	args.Stdout, args.Stderr, flush = et.run("cgo", args.ObjectDir, args.Stdout, args.Stderr)
+	defer flush()
+	tools, err := withCgo(et.Tools)
+	if err != nil {
+		return err
+	}
+	return tools.Cgo(args)
+}
+
+func (et *eventTools) CCompile(args CCompileArgs) error {
+	var flush func()
+	args.Stdout, args.Stderr, flush = et.run("cc", args.OutputFile, args.Stdout, args.Stderr)
+	defer flush()
+	tools, err := withCgo(et.Tools)
+	if err != nil {
+		return err
+	}
+	return tools.CCompile(args)
+}
+
+// diagnosticRegex matches the "file:line[:column]: message" lines of the
+// tools.
+var diagnosticRegex = regexp.MustCompile(`^(\S[^:]*:\d+(?::\d+)?): (.*)$`)
+
+// eventLineWriter emits the lines written to it, and writes them to w if
+// set.
+type eventLineWriter struct {
+	w     io.Writer
+	tools *eventTools
+	mutex sync.Mutex
+	buf   []byte
+}
+
+func (lw *eventLineWriter) Write(b []byte) (int, error) {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+	lw.buf = append(lw.buf, b...)
+	for {
+		i := bytes.IndexByte(lw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		lw.line(string(lw.buf[:i+1]))
+		lw.buf = lw.buf[i+1:]
+	}
+	if lw.w == nil {
+		return len(b), nil
+	}
+	return lw.w.Write(b)
+}
+
+// flush emits the last line if not terminated by a newline.
+func (lw *eventLineWriter) flush() {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+	if len(lw.buf) > 0 {
+		lw.line(string(lw.buf) + "\n")
+		lw.buf = nil
+	}
+}
+
+func (lw *eventLineWriter) line(line string) {
+	lw.tools.emit(EventOutput, line)
+	if m := diagnosticRegex.FindStringSubmatch(line[:len(line)-1]); m != nil {
+		lw.tools.events.Emit(BuildEvent{Action: EventDiagnostic, ImportPath: lw.tools.importPath, Pos: m[1], Output: m[2]})
+	}
+}
@@ -0,0 +1,117 @@
+package build_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gophertest/build"
+	"github.com/gophertest/build/buildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeEvents(t *testing.T, data []byte) []build.BuildEvent {
+	events := []build.BuildEvent(nil)
+	for _, line := range strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n") {
+		e := build.BuildEvent{}
+		require.NoError(t, json.Unmarshal([]byte(line), &e), line)
+		require.NotNil(t, e.Time, line)
+		events = append(events, e)
+	}
+	return events
+}
+
+// eventsOf returns the actions of the events of importPath, with the tool
+// of EventTool events.
+func eventsOf(events []build.BuildEvent, importPath string) []string {
+	actions := []string(nil)
+	for _, e := range events {
+		if e.ImportPath != importPath {
+			continue
+		}
+		if e.Action == build.EventTool {
+			actions = append(actions, e.Action+" "+e.Tool)
+		} else {
+			actions = append(actions, e.Action)
+		}
+	}
+	return actions
+}
+
+func TestEventWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := build.NewEventWriter(buf)
+	w.Now = func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) }
+	require.NoError(t, w.Emit(build.BuildEvent{Action: build.EventOutput, ImportPath: "example.com/a", Output: "x\n"}))
+	require.NoError(t, w.Emit(build.BuildEvent{Action: build.EventFail, ImportPath: "example.com/a", Elapsed: 0.5}))
+	assert.Equal(t, `{"Time":"2020-01-02T03:04:05Z","Action":"build-output","ImportPath":"example.com/a","Output":"x\n"}
+{"Time":"2020-01-02T03:04:05Z","Action":"build-fail","ImportPath":"example.com/a","Elapsed":0.5}
+`, buf.String())
+}
+
+func TestEventTools(t *testing.T) {
+	ft := buildtest.NewFakeTools()
+	ft.Respond(buildtest.Compile, buildtest.Response{
+		Stderr:   "./a.go:3:2: undefined: x\n./a.go:4: too many errors\npartial",
+		ExitCode: 1,
+	})
+	buf := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	tools := build.NewEventTools(ft, build.NewEventWriter(buf), "example.com/a")
+	err := tools.Compile(build.CompileArgs{OutputFile: "a.a", Stdout: stderr, Stderr: stderr})
+	assert.Error(t, err)
+	assert.Equal(t, "./a.go:3:2: undefined: x\n./a.go:4: too many errors\npartial", stderr.String())
+
+	events := decodeEvents(t, buf.Bytes())
+	for i := range events {
+		events[i].Time = nil
+	}
+	assert.Equal(t, []build.BuildEvent{
+		{Action: build.EventTool, ImportPath: "example.com/a", Tool: "compile", File: "a.a"},
+		{Action: build.EventOutput, ImportPath: "example.com/a", Output: "./a.go:3:2: undefined: x\n"},
+		{Action: build.EventDiagnostic, ImportPath: "example.com/a", Pos: "./a.go:3:2", Output: "undefined: x"},
+		{Action: build.EventOutput, ImportPath: "example.com/a", Output: "./a.go:4: too many errors\n"},
+		{Action: build.EventDiagnostic, ImportPath: "example.com/a", Pos: "./a.go:4", Output: "too many errors"},
+		{Action: build.EventOutput, ImportPath: "example.com/a", Output: "partial\n"},
+	}, events)
+}
+
+func TestBuilderEvents(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "events")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	buf := &bytes.Buffer{}
+	b := &build.Builder{Tools: ft, Context: ctx, CacheDir: filepath.Join(dir, "cache"), Events: buf}
+	_, err = b.Build("example.com/hello", "", filepath.Join(dir, "hello"))
+	require.NoError(t, err)
+	events := decodeEvents(t, buf.Bytes())
+	assert.Equal(t, []string{"start", "cache-miss", "tool asm", "tool compile", "tool asm", "tool pack", "pass"}, eventsOf(events, "example.com/greet"))
+	assert.Equal(t, []string{"start", "cache-miss", "tool compile", "tool link", "pass"}, eventsOf(events, "example.com/hello"))
+	assert.Equal(t, "not built", events[1].Output)
+
+	buf.Reset()
+	_, err = b.Build("example.com/hello", "", filepath.Join(dir, "hello"))
+	require.NoError(t, err)
+	events = decodeEvents(t, buf.Bytes())
+	assert.Equal(t, []string{"start", "cache-hit", "pass"}, eventsOf(events, "example.com/greet"))
+
+	buf.Reset()
+	ft.Respond(buildtest.Link, buildtest.Response{Stderr: "undefined: main.main\n", ExitCode: 2})
+	_, err = b.Build("example.com/hello", "", filepath.Join(dir, "hello"))
+	require.Error(t, err)
+	events = decodeEvents(t, buf.Bytes())
+	assert.Equal(t, []string{"start", "cache-hit", "tool link", "build-output", "build-output", "build-fail"}, eventsOf(events, "example.com/hello"))
+	last := events[len(events)-2]
+	assert.Equal(t, err.Error()+"\n", last.Output)
+}
@@ -1,5 +1,38 @@
 package build
 
+import (
+	gb "go/build"
+	"time"
+)
+
 func NewCmdTools() *cmdTools {
 	return &cmdTools{}
 }
+
+type CmdTools = cmdTools
+
+func (s Stamp) WithVCS(dir string) (Stamp, error) {
+	return s.withVCS(dir)
+}
+
+func DiffFiles(file1, file2 string) (*Difference, error) {
+	return diffFiles(file1, file2)
+}
+
+func StampBuildID(file, id string) (string, error) {
+	return stampBuildID(file, id)
+}
+
+func ContentIDOf(data []byte, buildID string) string {
+	return contentID(data, buildID)
+}
+
+func SetKillGrace(d time.Duration) func() {
+	old := killGrace
+	killGrace = d
+	return func() { killGrace = old }
+}
+
+func AsmDefines(ctx gb.Context, arch Arch) []string {
+	return asmDefines(ctx, arch)
+}
@@ -0,0 +1,53 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// ReadExportData returns the export data of a package archive or object file
+// written by the compiler: the __.PKGDEF member of an archive, or the header
+// of an object file.
+func ReadExportData(file string) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(data, []byte("!<arch>\n")):
+		data = data[len("!<arch>\n"):]
+		for len(data) >= 60 {
+			name := strings.TrimSpace(string(data[:16]))
+			size, err := strconv.Atoi(strings.TrimSpace(string(data[48:58])))
+			if err != nil || size < 0 || 60+size > len(data) {
+				return nil, fmt.Errorf("%s: malformed archive", file)
+			}
+			if name == "__.PKGDEF" {
+				return data[60 : 60+size], nil
+			}
+			data = data[60+size+size%2:]
+		}
+		return nil, fmt.Errorf("%s: no __.PKGDEF in archive", file)
+	case bytes.HasPrefix(data, []byte("go object ")):
+		if i := bytes.Index(data, []byte("\n!\n")); i >= 0 {
+			return data[:i+1], nil
+		}
+		return nil, fmt.Errorf("%s: malformed object file", file)
+	}
+	return nil, fmt.Errorf("%s: not a Go archive or object file", file)
+}
+
+// ReadPackage returns the package path compiled into the archive or object
+// file, loaded from its export data.
+func ReadPackage(fset *token.FileSet, file, path string) (*types.Package, error) {
+	if _, err := ReadExportData(file); err != nil {
+		return nil, err
+	}
+	cfg := &ImportConfig{PackageFile: map[string]string{path: file}}
+	return cfg.Importer(fset).Import(path)
+}
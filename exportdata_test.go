@@ -0,0 +1,128 @@
+package build_test
+
+import (
+	"go/ast"
+	gb "go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// compileExported compiles the packages of testdata/src/example.com/exported
+// with the real compiler into dir, and returns their import config.
+func compileExported(t *testing.T, dir string, pack bool) *build.ImportConfig {
+	cfg := &build.ImportConfig{PackageFile: map[string]string{}}
+	for _, v := range []string{"a", "b"} {
+		src, err := filepath.Abs(filepath.Join("testdata", "src", "example.com", "exported", v))
+		require.NoError(t, err)
+		importCfg := filepath.Join(dir, "importcfg")
+		require.NoError(t, cfg.Write(importCfg))
+		output := filepath.Join(dir, v+".o")
+		if pack {
+			output = filepath.Join(dir, v+".a")
+		}
+		err = build.DefaultTools.Compile(build.CompileArgs{
+			Context:           gb.Default,
+			WorkingDirectory:  src,
+			Stderr:            os.Stderr,
+			Files:             []string{v + ".go"},
+			OutputFile:        output,
+			PackageImportPath: "example.com/exported/" + v,
+			ImportConfigFile:  importCfg,
+			Pack:              pack,
+			Complete:          true,
+		})
+		require.NoError(t, err)
+		cfg.PackageFile["example.com/exported/"+v] = output
+	}
+	return cfg
+}
+
+func TestReadExportData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exportdata")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, pack := range []bool{true, false} {
+		cfg := compileExported(t, dir, pack)
+		file := cfg.PackageFile["example.com/exported/a"]
+		data, err := build.ReadExportData(file)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "go object ")
+		assert.Contains(t, string(data), "$$B\n")
+
+		pkg, err := build.ReadPackage(token.NewFileSet(), file, "example.com/exported/a")
+		require.NoError(t, err)
+		assert.Equal(t, "a", pkg.Name())
+		assert.NotNil(t, pkg.Scope().Lookup("T"))
+		assert.NotNil(t, pkg.Scope().Lookup("New"))
+	}
+
+	invalid := filepath.Join(dir, "invalid")
+	require.NoError(t, ioutil.WriteFile(invalid, []byte("not an archive"), 0666))
+	_, err = build.ReadExportData(invalid)
+	assert.EqualError(t, err, invalid+": not a Go archive or object file")
+	_, err = build.ReadPackage(token.NewFileSet(), invalid, "invalid")
+	assert.Error(t, err)
+
+	archive := filepath.Join(dir, "nopkgdef.a")
+	require.NoError(t, ioutil.WriteFile(archive, []byte("!<arch>\n"+
+		"other.o         0           0     0     644     4         `\n"+
+		"data"), 0666))
+	_, err = build.ReadExportData(archive)
+	assert.EqualError(t, err, archive+": no __.PKGDEF in archive")
+
+	truncated := filepath.Join(dir, "truncated.a")
+	require.NoError(t, ioutil.WriteFile(truncated, []byte("!<arch>\n"+
+		"__.PKGDEF       0           0     0     644     400       `\n"+
+		"data"), 0666))
+	_, err = build.ReadExportData(truncated)
+	assert.EqualError(t, err, truncated+": malformed archive")
+}
+
+func TestImportConfigImporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exportdata")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := compileExported(t, dir, true)
+	cfg.ImportMap = map[string]string{"vendored/b": "example.com/exported/b"}
+	fset := token.NewFileSet()
+	imp := cfg.Importer(fset)
+	pkg, err := imp.Import("vendored/b")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com/exported/b", pkg.Path())
+	wrap := pkg.Scope().Lookup("Wrap")
+	require.NotNil(t, wrap)
+	assert.Equal(t, "func(t *example.com/exported/a.T) []*example.com/exported/a.T", wrap.Type().String())
+
+	_, err = imp.Import("example.com/missing")
+	assert.Error(t, err)
+
+	// Type check against the build outputs.
+	conf := types.Config{Importer: imp}
+	files := parseFiles(t, fset, "package c\n\nimport \"example.com/exported/a\"\n\nvar _ = a.New().X\n")
+	_, err = conf.Check("c", fset, files, nil)
+	assert.NoError(t, err)
+	files = parseFiles(t, fset, "package c\n\nimport \"example.com/exported/a\"\n\nvar _ = a.New().y\n")
+	_, err = conf.Check("c", fset, files, nil)
+	assert.Error(t, err)
+}
+
+func parseFiles(t *testing.T, fset *token.FileSet, sources ...string) []*ast.File {
+	files := []*ast.File(nil)
+	for _, v := range sources {
+		f, err := parser.ParseFile(fset, "", v, 0)
+		require.NoError(t, err)
+		files = append(files, f)
+	}
+	return files
+}
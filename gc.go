@@ -0,0 +1,89 @@
+package build
+
+import (
+	gb "go/build"
+	"path/filepath"
+	"strings"
+)
+
+// compilingRuntime reports whether the toolchain of version needs "-+" to
+// compile importPath, as part of the runtime or imported by it. Since go1.22
+// the compiler derives it from "-std" and "-p", the list of the runtime
+// packages is only that of the earlier releases.
+func compilingRuntime(importPath, version string) bool {
+	if minor, ok := goMinorVersion(version); !ok || minor >= 22 {
+		return false
+	}
+	switch importPath {
+	case "runtime", "internal/cpu", "internal/bytealg", "internal/abi":
+		return true
+	}
+	return strings.HasPrefix(importPath, "runtime/internal/")
+}
+
+// incompleteStd lists the standard library packages declaring functions
+// without a body that are implemented elsewhere, like by the runtime through
+// a linkname, although they have no assembly.
+var incompleteStd = map[string]bool{
+	"bytes":           true,
+	"internal/poll":   true,
+	"net":             true,
+	"os":              true,
+	"runtime/metrics": true,
+	"runtime/pprof":   true,
+	"runtime/trace":   true,
+	"sync":            true,
+	"syscall":         true,
+	"time":            true,
+}
+
+// complete reports whether all the functions of pkg have a Go body, so that
+// it can be compiled with "-complete".
+func complete(pkg *gb.Package) bool {
+	ext := len(pkg.CgoFiles) + len(pkg.CFiles) + len(pkg.CXXFiles) + len(pkg.MFiles) +
+		len(pkg.FFiles) + len(pkg.SFiles) + len(pkg.SysoFiles) + len(pkg.SwigFiles) + len(pkg.SwigCXXFiles)
+	return ext == 0 && !(pkg.Goroot && incompleteStd[pkg.ImportPath])
+}
+
+// PackageCompileArgs returns args with the flags depending on pkg set like
+// the go command of version, the "go version" output of the toolchain, does:
+// "-std" for the standard library, "-+" for the runtime and the packages it
+// imports before go1.22, and "-complete" when all the functions have a Go
+// body. Packages with assembly get the "go_asm.h" header, for their assembly
+// to include, and the symabis file generated by SymABIsArgs, for the compiler
+// to write the ABI wrappers of the assembly functions, both in objDir.
+func PackageCompileArgs(args CompileArgs, pkg *gb.Package, objDir, version string) CompileArgs {
+	args.CompilingStandardLibrary = pkg.Goroot
+	args.CompilingRuntimeLibrary = pkg.Goroot && compilingRuntime(pkg.ImportPath, version)
+	args.Complete = complete(pkg)
+	args.AsmHeaderFile = ""
+	args.SymABIsFile = ""
+	if len(pkg.SFiles) > 0 {
+		args.AsmHeaderFile = filepath.Join(objDir, "go_asm.h")
+		args.SymABIsFile = filepath.Join(objDir, "symabis")
+	}
+	return args
+}
+
+// PackageAssembleArgs returns args with the flags depending on pkg set like
+// the go command does: "-p" and "-std" for the standard library, which lets
+// its assembly reference the internal packages of the runtime.
+func PackageAssembleArgs(args AssembleArgs, pkg *gb.Package) AssembleArgs {
+	if args.PackageImportPath == "" {
+		args.PackageImportPath = pkg.ImportPath
+	}
+	args.Std = pkg.Goroot
+	return args
+}
+
+// SymABIsArgs returns args set to generate the symabis file of the assembly
+// of pkg into objDir, read by the compile of PackageCompileArgs. The
+// IncludeDirs of args must hold a "go_asm.h": the compiler only generates it
+// afterwards, an empty one is enough.
+func SymABIsArgs(args AssembleArgs, pkg *gb.Package, objDir string) AssembleArgs {
+	args = PackageAssembleArgs(args, pkg)
+	args.GenSymABIs = true
+	args.Files = pkg.SFiles
+	args.OutputFile = filepath.Join(objDir, "symabis")
+	return args
+}
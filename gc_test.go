@@ -0,0 +1,102 @@
+package build_test
+
+import (
+	gb "go/build"
+	"path/filepath"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPackageCompileArgs(t *testing.T) {
+	testCases := []struct {
+		Package  gb.Package
+		Std      bool
+		Runtime  bool
+		Complete bool
+		Asm      bool
+	}{
+		{gb.Package{ImportPath: "example.com/a", GoFiles: []string{"a.go"}}, false, false, true, false},
+		{gb.Package{ImportPath: "example.com/a", SFiles: []string{"a.s"}}, false, false, false, true},
+		{gb.Package{ImportPath: "example.com/a", CgoFiles: []string{"a.go"}}, false, false, false, false},
+		{gb.Package{ImportPath: "example.com/a", SysoFiles: []string{"a.syso"}}, false, false, false, false},
+		// Only the standard library is special cased.
+		{gb.Package{ImportPath: "runtime"}, false, false, true, false},
+		{gb.Package{ImportPath: "os"}, false, false, true, false},
+		{gb.Package{ImportPath: "fmt", Goroot: true}, true, false, true, false},
+		{gb.Package{ImportPath: "runtime", Goroot: true, SFiles: []string{"asm.s"}}, true, true, false, true},
+		{gb.Package{ImportPath: "runtime/internal/atomic", Goroot: true}, true, true, true, false},
+		{gb.Package{ImportPath: "internal/cpu", Goroot: true}, true, true, true, false},
+		{gb.Package{ImportPath: "internal/bytealg", Goroot: true}, true, true, true, false},
+		{gb.Package{ImportPath: "internal/abi", Goroot: true}, true, true, true, false},
+		{gb.Package{ImportPath: "runtime/race", Goroot: true}, true, false, true, false},
+		// Implemented by the runtime.
+		{gb.Package{ImportPath: "os", Goroot: true}, true, false, false, false},
+		{gb.Package{ImportPath: "sync", Goroot: true}, true, false, false, false},
+		{gb.Package{ImportPath: "runtime/pprof", Goroot: true}, true, false, false, false},
+	}
+	for _, tc := range testCases {
+		p := tc.Package
+		args := build.PackageCompileArgs(build.CompileArgs{SymABIsFile: "old"}, &p, "obj", "go version go1.20 linux/amd64")
+		assert.Equal(t, tc.Std, args.CompilingStandardLibrary, p.ImportPath)
+		assert.Equal(t, tc.Runtime, args.CompilingRuntimeLibrary, p.ImportPath)
+		assert.Equal(t, tc.Complete, args.Complete, p.ImportPath)
+		if tc.Asm {
+			assert.Equal(t, filepath.Join("obj", "go_asm.h"), args.AsmHeaderFile, p.ImportPath)
+			assert.Equal(t, filepath.Join("obj", "symabis"), args.SymABIsFile, p.ImportPath)
+		} else {
+			assert.Empty(t, args.AsmHeaderFile, p.ImportPath)
+			assert.Empty(t, args.SymABIsFile, p.ImportPath)
+		}
+	}
+
+	// Later compilers derive "-+" from "-std" and "-p".
+	for _, v := range []string{"go version go1.22.0 linux/amd64", "go version devel go1.27-abcdef linux/amd64", ""} {
+		p := &gb.Package{ImportPath: "runtime", Goroot: true}
+		args := build.PackageCompileArgs(build.CompileArgs{}, p, "obj", v)
+		assert.True(t, args.CompilingStandardLibrary, v)
+		assert.False(t, args.CompilingRuntimeLibrary, v)
+	}
+}
+
+func TestSymABIsArgs(t *testing.T) {
+	p := &gb.Package{ImportPath: "runtime", Goroot: true, SFiles: []string{"a.s", "b.s"}}
+	args := build.SymABIsArgs(build.AssembleArgs{IncludeDirs: []string{"obj"}}, p, "obj")
+	assert.Equal(t, build.AssembleArgs{
+		IncludeDirs:       []string{"obj"},
+		Files:             []string{"a.s", "b.s"},
+		OutputFile:        filepath.Join("obj", "symabis"),
+		GenSymABIs:        true,
+		PackageImportPath: "runtime",
+		Std:               true,
+	}, args)
+	compile := build.PackageCompileArgs(build.CompileArgs{}, p, "obj", "")
+	assert.Equal(t, compile.SymABIsFile, args.OutputFile)
+
+	args = build.SymABIsArgs(build.AssembleArgs{PackageImportPath: "main"}, p, "obj")
+	assert.Equal(t, "main", args.PackageImportPath)
+}
+
+func TestPackageAssembleArgs(t *testing.T) {
+	testCases := []struct {
+		Package gb.Package
+		Path    string
+		Std     bool
+	}{
+		{gb.Package{ImportPath: "example.com/a"}, "example.com/a", false},
+		{gb.Package{ImportPath: "sync/atomic", Goroot: true}, "sync/atomic", true},
+		{gb.Package{ImportPath: "runtime", Goroot: true}, "runtime", true},
+		// Only the standard library is special cased.
+		{gb.Package{ImportPath: "runtime"}, "runtime", false},
+	}
+	for _, tc := range testCases {
+		p := tc.Package
+		args := build.PackageAssembleArgs(build.AssembleArgs{Std: true}, &p)
+		assert.Equal(t, tc.Path, args.PackageImportPath, p.ImportPath)
+		assert.Equal(t, tc.Std, args.Std, p.ImportPath)
+	}
+	p := &gb.Package{ImportPath: "example.com/a"}
+	args := build.PackageAssembleArgs(build.AssembleArgs{PackageImportPath: "main"}, p)
+	assert.Equal(t, "main", args.PackageImportPath)
+}
@@ -0,0 +1,114 @@
+package build
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ImportConfig maps import paths to compiled packages, as read by the compiler
+// and linker with "-importcfg".
+type ImportConfig struct {
+	// ImportMap maps import paths as written in the source to the import
+	// path of the package, written as "importmap" lines.
+	ImportMap map[string]string
+	// PackageFile maps import paths to package archives, written as
+	// "packagefile" lines.
+	PackageFile map[string]string
+}
+
+// ReadImportConfig reads an importcfg file. The "modinfo" and "packageshlib"
+// directives of the linker are ignored.
+func ReadImportConfig(file string) (*ImportConfig, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cfg := &ImportConfig{ImportMap: map[string]string{}, PackageFile: map[string]string{}}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		verb, args := line, ""
+		if i := strings.Index(line, " "); i >= 0 {
+			verb, args = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		switch verb {
+		case "importmap", "packagefile":
+			i := strings.Index(args, "=")
+			if i <= 0 || i == len(args)-1 {
+				return nil, fmt.Errorf("%s:%d: invalid %s: syntax is \"%s path=file\"", file, n, verb, verb)
+			}
+			if verb == "importmap" {
+				cfg.ImportMap[args[:i]] = args[i+1:]
+			} else {
+				cfg.PackageFile[args[:i]] = args[i+1:]
+			}
+		case "modinfo", "packageshlib":
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown directive %q", file, n, verb)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Write writes the config to file, sorted by import path.
+func (c *ImportConfig) Write(file string) error {
+	buf := &bytes.Buffer{}
+	for _, k := range sortedKeys(c.ImportMap) {
+		fmt.Fprintf(buf, "importmap %s=%s\n", k, c.ImportMap[k])
+	}
+	for _, k := range sortedKeys(c.PackageFile) {
+		fmt.Fprintf(buf, "packagefile %s=%s\n", k, c.PackageFile[k])
+	}
+	return ioutil.WriteFile(file, buf.Bytes(), 0666)
+}
+
+// Importer returns an importer of the packages of the config, reading their
+// export data.
+func (c *ImportConfig) Importer(fset *token.FileSet) types.Importer {
+	imp := importer.ForCompiler(fset, "gc", func(path string) (io.ReadCloser, error) {
+		file, ok := c.PackageFile[path]
+		if !ok {
+			return nil, fmt.Errorf("no packagefile for %s", path)
+		}
+		return os.Open(file)
+	})
+	return &importCfgImporter{cfg: c, imp: imp}
+}
+
+type importCfgImporter struct {
+	cfg *ImportConfig
+	imp types.Importer
+}
+
+func (i *importCfgImporter) Import(path string) (*types.Package, error) {
+	if v, ok := i.cfg.ImportMap[path]; ok {
+		path = v
+	}
+	return i.imp.Import(path)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := []string(nil)
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,58 @@
+package build_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "importcfg")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "importcfg")
+	cfg := &build.ImportConfig{
+		ImportMap:   map[string]string{"b": "vendor/b", "a": "vendor/a"},
+		PackageFile: map[string]string{"vendor/b": "/b.a", "vendor/a": "/a.a", "fmt": "/fmt.a"},
+	}
+	require.NoError(t, cfg.Write(file))
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "importmap a=vendor/a\n"+
+		"importmap b=vendor/b\n"+
+		"packagefile fmt=/fmt.a\n"+
+		"packagefile vendor/a=/a.a\n"+
+		"packagefile vendor/b=/b.a\n", string(data))
+	read, err := build.ReadImportConfig(file)
+	require.NoError(t, err)
+	assert.Equal(t, cfg, read)
+
+	testCases := []struct {
+		Content string
+		Error   string
+	}{
+		{"# comment\n\npackagefile a=/a.a\nmodinfo \"x\"\npackageshlib a=/a.so\n", ""},
+		{"packagefile a\n", "importcfg:1: invalid packagefile: syntax is \"packagefile path=file\""},
+		{"\nimportmap =b\n", "importcfg:2: invalid importmap: syntax is \"importmap path=file\""},
+		{"packagefile a=\n", "importcfg:1: invalid packagefile: syntax is \"packagefile path=file\""},
+		{"unknown a=b\n", "importcfg:1: unknown directive \"unknown\""},
+	}
+	for _, tc := range testCases {
+		require.NoError(t, ioutil.WriteFile(file, []byte(tc.Content), 0666))
+		_, err := build.ReadImportConfig(file)
+		if tc.Error == "" {
+			assert.NoError(t, err)
+		} else {
+			assert.EqualError(t, err, file+tc.Error[len("importcfg"):])
+		}
+	}
+
+	_, err = build.ReadImportConfig(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
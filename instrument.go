@@ -0,0 +1,125 @@
+package build
+
+import (
+	"fmt"
+	gb "go/build"
+	"runtime"
+)
+
+// Instrument is the instrumentation of every package of a build, passed to
+// the compiler and linker as "-race", "-msan" or "-asan".
+type Instrument string
+
+const (
+	// InstrumentRace enables the race detector.
+	InstrumentRace Instrument = "race"
+	// InstrumentMSan enables interoperation with the C memory sanitizer.
+	InstrumentMSan Instrument = "msan"
+	// InstrumentASan enables interoperation with the C address sanitizer.
+	InstrumentASan Instrument = "asan"
+)
+
+// Valid reports whether i is a known instrumentation.
+func (i Instrument) Valid() bool {
+	switch i {
+	case InstrumentRace, InstrumentMSan, InstrumentASan:
+		return true
+	}
+	return false
+}
+
+// Supported reports whether the instrumentation is supported on
+// goos/goarch. It mirrors the support matrix of the go command.
+func (i Instrument) Supported(goos, goarch string) bool {
+	switch i {
+	case InstrumentRace:
+		switch goos {
+		case "linux":
+			switch goarch {
+			case "amd64", "arm64", "loong64", "ppc64le", "riscv64", "s390x":
+				return true
+			}
+		case "darwin":
+			return goarch == "amd64" || goarch == "arm64"
+		case "freebsd", "netbsd", "windows":
+			return goarch == "amd64"
+		}
+	case InstrumentMSan:
+		switch goos {
+		case "linux":
+			return goarch == "amd64" || goarch == "arm64" || goarch == "loong64"
+		case "freebsd":
+			return goarch == "amd64"
+		}
+	case InstrumentASan:
+		if goos == "linux" {
+			switch goarch {
+			case "amd64", "arm64", "loong64", "ppc64le", "riscv64":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Validate checks that the instrumentation is supported by ctx, which must
+// enable cgo except for the race detector on darwin.
+func (i Instrument) Validate(ctx gb.Context) error {
+	if i == "" {
+		return nil
+	}
+	if !i.Valid() {
+		return fmt.Errorf("invalid instrumentation %q", i)
+	}
+	if !i.Supported(ctx.GOOS, ctx.GOARCH) {
+		return fmt.Errorf("-%s is not supported on %s/%s", i, ctx.GOOS, ctx.GOARCH)
+	}
+	if !ctx.CgoEnabled && (ctx.GOOS != "darwin" || i != InstrumentRace) {
+		if ctx.GOOS != runtime.GOOS || ctx.GOARCH != runtime.GOARCH {
+			return fmt.Errorf("-%s requires cgo", i)
+		}
+		return fmt.Errorf("-%s requires cgo; enable cgo by setting CGO_ENABLED=1", i)
+	}
+	return nil
+}
+
+// InstallSuffix returns the suffix of the package directory of the
+// instrumented packages, like "race".
+func (i Instrument) InstallSuffix() string {
+	return string(i)
+}
+
+// Context returns ctx with the build tag and install suffix of the
+// instrumentation added, as the packages of an instrumented build are loaded
+// with.
+func (i Instrument) Context(ctx gb.Context) gb.Context {
+	if i == "" {
+		return ctx
+	}
+	if ctx.InstallSuffix != "" {
+		ctx.InstallSuffix += "_"
+	}
+	ctx.InstallSuffix += i.InstallSuffix()
+	ctx.BuildTags = append(append([]string(nil), ctx.BuildTags...), string(i))
+	return ctx
+}
+
+// runtimePackage returns the package implementing the instrumentation,
+// linked into every instrumented binary.
+func (i Instrument) runtimePackage() string {
+	if i == "" {
+		return ""
+	}
+	return "runtime/" + string(i)
+}
+
+// cFlags returns the flags of the C compiler and linker for cgo.
+func (i Instrument) cFlags() []string {
+	switch i {
+	case InstrumentMSan:
+		return []string{"-fsanitize=memory"}
+	case InstrumentASan:
+		return []string{"-fsanitize=address"}
+	}
+	return nil
+}
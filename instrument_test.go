@@ -0,0 +1,161 @@
+package build_test
+
+import (
+	gb "go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/gophertest/build/buildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstrumentValidate(t *testing.T) {
+	testCases := []struct {
+		Instrument build.Instrument
+		GOOS       string
+		GOARCH     string
+		Cgo        bool
+		Err        string
+	}{
+		{"", "linux", "386", false, ""},
+		{build.InstrumentRace, "linux", "amd64", true, ""},
+		{build.InstrumentRace, "windows", "amd64", true, ""},
+		{build.InstrumentRace, "darwin", "arm64", false, ""},
+		{build.InstrumentRace, "linux", "386", true, "-race is not supported on linux/386"},
+		{build.InstrumentMSan, "freebsd", "amd64", true, ""},
+		{build.InstrumentMSan, "darwin", "arm64", true, "-msan is not supported on darwin/arm64"},
+		{build.InstrumentMSan, "freebsd", "amd64", false, "-msan requires cgo"},
+		{build.InstrumentASan, "linux", "riscv64", true, ""},
+		{build.InstrumentASan, "freebsd", "amd64", true, "-asan is not supported on freebsd/amd64"},
+		{"tsan", "linux", "amd64", true, `invalid instrumentation "tsan"`},
+	}
+	for _, tc := range testCases {
+		ctx := gb.Default
+		ctx.GOOS, ctx.GOARCH, ctx.CgoEnabled = tc.GOOS, tc.GOARCH, tc.Cgo
+		err := tc.Instrument.Validate(ctx)
+		if tc.Err == "" {
+			assert.NoError(t, err, "%s %s/%s", tc.Instrument, tc.GOOS, tc.GOARCH)
+		} else if assert.Error(t, err, "%s %s/%s", tc.Instrument, tc.GOOS, tc.GOARCH) {
+			assert.Equal(t, tc.Err, err.Error())
+		}
+	}
+
+	ctx := gb.Default
+	ctx.GOOS, ctx.GOARCH, ctx.CgoEnabled = runtime.GOOS, runtime.GOARCH, false
+	if runtime.GOOS != "darwin" {
+		err := build.InstrumentRace.Validate(ctx)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "enable cgo by setting CGO_ENABLED=1")
+	}
+}
+
+func TestInstrumentContext(t *testing.T) {
+	ctx := gb.Default
+	ctx.BuildTags = []string{"a"}
+	ctx.InstallSuffix = "shared"
+	race := build.InstrumentRace.Context(ctx)
+	assert.Equal(t, []string{"a", "race"}, race.BuildTags)
+	assert.Equal(t, "shared_race", race.InstallSuffix)
+	assert.Equal(t, []string{"a"}, ctx.BuildTags)
+	assert.Equal(t, ctx, build.Instrument("").Context(ctx))
+}
+
+func TestBuilderInstrument(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "instrument")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	b := &build.Builder{Tools: ft, Context: ctx, Instrument: build.InstrumentRace}
+	_, err = b.Build("example.com/hello", "", filepath.Join(dir, "hello"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "-race requires cgo")
+	ft.AssertNotCalled(t, buildtest.Compile)
+
+	b.Context.CgoEnabled = true
+	plan, err := b.Load("example.com/hello", "")
+	require.NoError(t, err)
+	sysos := map[string]bool{}
+	for _, p := range plan.Packages {
+		for _, v := range p.SysoFiles {
+			sysos[filepath.Join(p.Dir, v)] = false
+		}
+	}
+	// The race detector runtime is a syso of runtime/race.
+	assert.NotEmpty(t, sysos)
+	_, err = b.BuildPlan(plan, filepath.Join(dir, "hello"))
+	require.NoError(t, err)
+	ft.AssertCompiled(t, "runtime/race")
+	for _, v := range ft.CompileCalls() {
+		assert.True(t, v.Race, v.PackageImportPath)
+		assert.False(t, v.MSan || v.ASan, v.PackageImportPath)
+		assert.Equal(t, "race", v.InstallSuffix, v.PackageImportPath)
+	}
+	links := ft.LinkCalls()
+	require.Len(t, links, 1)
+	assert.True(t, links[0].Race)
+	assert.Equal(t, "race", links[0].InstallSuffix)
+	assert.Equal(t, build.BuildMode(""), links[0].BuildMode)
+	for _, v := range ft.PackCalls() {
+		for _, name := range v.Names {
+			if _, ok := sysos[name]; ok {
+				sysos[name] = true
+			}
+		}
+	}
+	for k, v := range sysos {
+		assert.True(t, v, "%s packed", k)
+	}
+
+	// The memory sanitizer instruments the C code and needs PIE off
+	// linux/amd64.
+	ft.Reset()
+	b.Instrument = build.InstrumentMSan
+	b.Context.GOARCH = "arm64"
+	_, err = b.Build("example.com/hello", "", filepath.Join(dir, "hello"))
+	require.NoError(t, err)
+	for _, v := range ft.CompileCalls() {
+		assert.True(t, v.MSan, v.PackageImportPath)
+		assert.Equal(t, "msan", v.InstallSuffix, v.PackageImportPath)
+	}
+	require.NotEmpty(t, ft.CgoCalls())
+	for _, v := range ft.CgoCalls() {
+		assert.Contains(t, v.CFlags, "-fsanitize=memory")
+		assert.Contains(t, v.LDFlags, "-fsanitize=memory")
+	}
+	links = ft.LinkCalls()
+	require.Len(t, links, 1)
+	assert.True(t, links[0].MSan)
+	assert.Equal(t, build.BuildModePIE, links[0].BuildMode)
+	ft.AssertCompiled(t, "runtime/msan")
+}
+
+func TestBuilderInstrumentDefaultTools(t *testing.T) {
+	ctx, restore := realCgoContext(t)
+	defer restore()
+	if !build.InstrumentRace.Supported(ctx.GOOS, ctx.GOARCH) {
+		t.Skip("race detector not supported")
+	}
+	dir, err := ioutil.TempDir("", "instrument")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	stderr := &strings.Builder{}
+	b := &build.Builder{Tools: build.DefaultTools, Context: ctx, Stderr: stderr, Instrument: build.InstrumentRace}
+	binary := filepath.Join(dir, "hello")
+	_, err = b.Build("example.com/hello", "", binary)
+	require.NoError(t, err, stderr.String())
+	out, err := exec.Command(binary).CombinedOutput()
+	require.NoError(t, err, string(out))
+	assert.Equal(t, "hello, world\n", string(out))
+}
@@ -17,6 +17,10 @@ type AssembleArgs struct {
 	WorkingDirectory string
 	Stdout           io.Writer
 	Stderr           io.Writer
+	// Env of the tool, on top of the Env of the Tools.
+	Env Env
+	// Arch settings of the build, validated against Context.GOARCH.
+	Arch Arch
 	// Files to assemble.
 	Files []string
 	// TrimPath is "-trimpath string"
@@ -33,6 +37,10 @@ type AssembleArgs struct {
 	Shared bool
 	// DynamicLink is "-dynlink"
 	DynamicLink bool
+	// PackageImportPath is "-p string"
+	PackageImportPath string
+	// Std is "-std"
+	Std bool
 }
 
 // Compiler provides access to the `go tool compile` tool.
@@ -47,6 +55,10 @@ type CompileArgs struct {
 	WorkingDirectory string
 	Stdout           io.Writer
 	Stderr           io.Writer
+	// Env of the tool, on top of the Env of the Tools.
+	Env Env
+	// Arch settings of the build, validated against Context.GOARCH.
+	Arch Arch
 	// Files to compile.
 	Files []string
 	// TrimPath is "-trimpath string"
@@ -67,12 +79,16 @@ type CompileArgs struct {
 	IncludeDirs []string
 	// Concurrency is "-c=int"
 	Concurrency int
+	// ASan is "-asan"
+	ASan bool
 	// AsmHeaderFile is "-asmhdr string"
 	AsmHeaderFile string
 	// Complete is "-complete"
 	Complete bool
 	// DynamicLink is "-dynlink"
 	DynamicLink bool
+	// EmbedConfigFile is "-embedcfg string"
+	EmbedConfigFile string
 	// GoVersion is "-goversion string"
 	GoVersion string
 	// HaltOnError is "-h"
@@ -95,6 +111,8 @@ type CompileArgs struct {
 	PackageImportPath string
 	// Pack is "-pack"
 	Pack bool
+	// ProfileFile is "-pgoprofile string"
+	ProfileFile string
 	// Race is "-race"
 	Race bool
 	// Shared is "-shared"
@@ -119,22 +137,28 @@ type LinkArgs struct {
 	WorkingDirectory string
 	Stdout           io.Writer
 	Stderr           io.Writer
+	// Env of the tool, on top of the Env of the Tools.
+	Env Env
+	// Arch settings of the build, validated against Context.GOARCH.
+	Arch Arch
 	// Files to link.
 	Files []string
 	// EntrySymbolName is "-E string"
 	EntrySymbolName string
 	// HeaderType is "-H string"
-	HeaderType string
+	HeaderType HeaderType
 	// ELFDynamicLinker is "-I string"
 	ELFDynamicLinker string
 	// LibraryPaths is "-L string [-L string ...]"
 	LibraryPaths []string
 	// StringDefines is "-X string [-X string ...]"
 	StringDefines []string
+	// ASan is "-asan"
+	ASan bool
 	// BuildID is "-buildid string"
 	BuildID string
 	// BuildMode is "-buildmode string"
-	BuildMode string
+	BuildMode BuildMode
 	// ExternalTar is "-extar string"
 	ExternalTar string
 	// ExternalLinker is "-extld string"
@@ -156,7 +180,7 @@ type LinkArgs struct {
 	// LibGCC is "-libgcc string"
 	LibGCC string
 	// LinkMode is "-linkmode string"
-	LinkMode string
+	LinkMode LinkMode
 	// LinkShared is "-linkshared"
 	LinkShared bool
 	// MSan is "-msan"
@@ -169,6 +193,10 @@ type LinkArgs struct {
 	Race bool
 	// TempDir is "-tmpdir string"
 	TempDir string
+	// TrimGOROOT omits GOROOT from the binary, as reported by
+	// runtime.GOROOT, by clearing GOROOT and setting GOROOT_FINAL for older
+	// linkers.
+	TrimGOROOT bool
 	// RejectUnsafePackages is "-u"
 	RejectUnsafePackages bool
 }
@@ -202,6 +230,8 @@ type PackArgs struct {
 	WorkingDirectory string
 	Stdout           io.Writer
 	Stderr           io.Writer
+	// Env of the tool, on top of the Env of the Tools.
+	Env Env
 	// Op the operation to perform on the object file.
 	Op PackOp
 	// ObjectFile to operate on
@@ -221,9 +251,73 @@ type BuildIDArgs struct {
 	Context          gb.Context
 	WorkingDirectory string
 	Stderr           io.Writer
+	// Env of the tool, on top of the Env of the Tools.
+	Env Env
 
 	// ObjectFile to read or write BuildID
 	ObjectFile string
 	// Write is "-w"
 	Write bool
 }
+
+// Cgoer provides access to the `go tool cgo` tool.
+type Cgoer interface {
+	// Cgo runs the cgo tool.
+	Cgo(args CgoArgs) error
+}
+
+// CgoArgs passed to Cgo.
+type CgoArgs struct {
+	Context          gb.Context
+	WorkingDirectory string
+	Stdout           io.Writer
+	Stderr           io.Writer
+	// Env of the tool, on top of the Env of the Tools.
+	Env Env
+	// Arch settings of the build, validated against Context.GOARCH.
+	Arch Arch
+	// Files to translate.
+	Files []string
+	// ObjectDir is "-objdir string"
+	ObjectDir string
+	// ImportPath is "-importpath string"
+	ImportPath string
+	// ExportHeader is "-exportheader string"
+	ExportHeader string
+	// TrimPath is "-trimpath string"
+	TrimPath string
+	// LDFlags is "-ldflags string"
+	LDFlags string
+	// NoImportRuntimeCgo is "-import_runtime_cgo=false"
+	NoImportRuntimeCgo bool
+	// NoImportSyscall is "-import_syscall=false"
+	NoImportSyscall bool
+	// CFlags passed to the C compiler, after "--".
+	CFlags []string
+}
+
+// CCompiler provides access to the C compiler used for cgo.
+type CCompiler interface {
+	// CCompile runs the C compiler to compile files to an object file.
+	CCompile(args CCompileArgs) error
+}
+
+// CCompileArgs passed to CCompile.
+type CCompileArgs struct {
+	Context          gb.Context
+	WorkingDirectory string
+	Stdout           io.Writer
+	Stderr           io.Writer
+	// Env of the tool, on top of the Env of the Tools.
+	Env Env
+	// Files to compile.
+	Files []string
+	// OutputFile is "-o string"
+	OutputFile string
+	// IncludeDirs is "-I string [-I string ...]"
+	IncludeDirs []string
+	// Defines is "-D string [-D string ...]"
+	Defines []string
+	// Flags passed as is, before the files.
+	Flags []string
+}
@@ -0,0 +1,249 @@
+package build
+
+import (
+	"fmt"
+)
+
+// BuildMode is the kind of object file produced by the linker, passed as
+// "-buildmode string".
+type BuildMode string
+
+const (
+	// BuildModeExe links an executable.
+	BuildModeExe BuildMode = "exe"
+	// BuildModePIE links a position independent executable.
+	BuildModePIE BuildMode = "pie"
+	// BuildModeCArchive links a C archive.
+	BuildModeCArchive BuildMode = "c-archive"
+	// BuildModeCShared links a C shared library.
+	BuildModeCShared BuildMode = "c-shared"
+	// BuildModeShared links a Go shared library.
+	BuildModeShared BuildMode = "shared"
+	// BuildModePlugin links a Go plugin.
+	BuildModePlugin BuildMode = "plugin"
+)
+
+// Supported reports whether the build mode is supported on goos/goarch. It
+// mirrors the support matrix of the go command.
+func (m BuildMode) Supported(goos, goarch string) bool {
+	platform := goos + "/" + goarch
+	switch m {
+	case BuildModeExe:
+		return true
+	case BuildModePIE:
+		switch platform {
+		case "linux/386", "linux/amd64", "linux/arm", "linux/arm64", "linux/loong64", "linux/ppc64", "linux/ppc64le", "linux/riscv64", "linux/s390x",
+			"android/amd64", "android/arm", "android/arm64", "android/386",
+			"freebsd/amd64",
+			"darwin/amd64", "darwin/arm64",
+			"ios/amd64", "ios/arm64",
+			"aix/ppc64",
+			"openbsd/arm64",
+			"windows/386", "windows/amd64", "windows/arm64":
+			return true
+		}
+	case BuildModeCArchive:
+		switch goos {
+		case "aix", "darwin", "ios", "windows":
+			return true
+		case "linux":
+			switch goarch {
+			case "386", "amd64", "arm", "armbe", "arm64", "arm64be", "loong64", "ppc64", "ppc64le", "riscv64", "s390x":
+				return true
+			}
+		case "freebsd":
+			return goarch == "amd64"
+		}
+	case BuildModeCShared:
+		switch platform {
+		case "linux/amd64", "linux/arm", "linux/arm64", "linux/loong64", "linux/386", "linux/ppc64", "linux/ppc64le", "linux/riscv64", "linux/s390x",
+			"android/amd64", "android/arm", "android/arm64", "android/386",
+			"freebsd/amd64",
+			"darwin/amd64", "darwin/arm64",
+			"windows/amd64", "windows/386", "windows/arm64",
+			"wasip1/wasm":
+			return true
+		}
+	case BuildModeShared:
+		switch platform {
+		case "linux/386", "linux/amd64", "linux/arm", "linux/arm64", "linux/ppc64", "linux/ppc64le", "linux/s390x":
+			return true
+		}
+	case BuildModePlugin:
+		switch platform {
+		case "linux/amd64", "linux/arm", "linux/arm64", "linux/386", "linux/loong64", "linux/riscv64", "linux/s390x", "linux/ppc64", "linux/ppc64le",
+			"android/amd64", "android/386",
+			"darwin/amd64", "darwin/arm64",
+			"freebsd/amd64":
+			return true
+		}
+	}
+	return false
+}
+
+// Valid reports whether m is a known build mode.
+func (m BuildMode) Valid() bool {
+	switch m {
+	case BuildModeExe, BuildModePIE, BuildModeCArchive, BuildModeCShared, BuildModeShared, BuildModePlugin:
+		return true
+	}
+	return false
+}
+
+// LinkMode selects whether the linker uses an external linker, passed as
+// "-linkmode string".
+type LinkMode string
+
+const (
+	// LinkModeAuto lets the linker decide.
+	LinkModeAuto LinkMode = "auto"
+	// LinkModeInternal links with the go linker only.
+	LinkModeInternal LinkMode = "internal"
+	// LinkModeExternal links with the external linker.
+	LinkModeExternal LinkMode = "external"
+)
+
+// Valid reports whether m is a known link mode.
+func (m LinkMode) Valid() bool {
+	switch m {
+	case LinkModeAuto, LinkModeInternal, LinkModeExternal:
+		return true
+	}
+	return false
+}
+
+// HeaderType is the executable format written by the linker, passed as
+// "-H string".
+type HeaderType string
+
+// Header types accepted by the linker, named after the GOOS they apply to.
+const (
+	HeaderTypeAIX        HeaderType = "aix"
+	HeaderTypeAndroid    HeaderType = "android"
+	HeaderTypeDarwin     HeaderType = "darwin"
+	HeaderTypeDragonfly  HeaderType = "dragonfly"
+	HeaderTypeFreeBSD    HeaderType = "freebsd"
+	HeaderTypeIllumos    HeaderType = "illumos"
+	HeaderTypeIOS        HeaderType = "ios"
+	HeaderTypeJS         HeaderType = "js"
+	HeaderTypeLinux      HeaderType = "linux"
+	HeaderTypeNetBSD     HeaderType = "netbsd"
+	HeaderTypeOpenBSD    HeaderType = "openbsd"
+	HeaderTypePlan9      HeaderType = "plan9"
+	HeaderTypeSolaris    HeaderType = "solaris"
+	HeaderTypeWASIP1     HeaderType = "wasip1"
+	HeaderTypeWindows    HeaderType = "windows"
+	HeaderTypeWindowsGUI HeaderType = "windowsgui"
+)
+
+// Valid reports whether h is a known header type.
+func (h HeaderType) Valid() bool {
+	switch h {
+	case HeaderTypeAIX, HeaderTypeAndroid, HeaderTypeDarwin, HeaderTypeDragonfly,
+		HeaderTypeFreeBSD, HeaderTypeIllumos, HeaderTypeIOS, HeaderTypeJS,
+		HeaderTypeLinux, HeaderTypeNetBSD, HeaderTypeOpenBSD, HeaderTypePlan9,
+		HeaderTypeSolaris, HeaderTypeWASIP1, HeaderTypeWindows, HeaderTypeWindowsGUI:
+		return true
+	}
+	return false
+}
+
+// mustLinkExternal reports whether the build mode requires an external
+// linker on goos/goarch, mirroring the go linker.
+func mustLinkExternal(m BuildMode, goos, goarch string, cgo bool) bool {
+	switch m {
+	case BuildModeCArchive, BuildModeShared, BuildModePlugin:
+		return true
+	case BuildModeCShared:
+		return goarch != "wasm"
+	case BuildModePIE:
+		switch goos + "/" + goarch {
+		case "android/arm64",
+			"darwin/amd64", "darwin/arm64",
+			"linux/amd64", "linux/arm64", "linux/loong64", "linux/ppc64", "linux/ppc64le", "linux/s390x",
+			"windows/386", "windows/amd64", "windows/arm64":
+		default:
+			return true
+		}
+	}
+	if cgo {
+		switch goarch {
+		case "mips", "mipsle", "mips64", "mips64le":
+			return true
+		case "ppc64":
+			if goos == "aix" {
+				return true
+			}
+		}
+		switch goos {
+		case "android", "dragonfly":
+			return true
+		}
+	}
+	switch goos {
+	case "android":
+		return goarch != "arm64"
+	case "ios":
+		return goarch == "arm64"
+	}
+	return false
+}
+
+// codegen returns whether code must be compiled and assembled with -shared or
+// -dynlink for the build mode on goos/goarch, mirroring the go command.
+func codegen(m BuildMode, goos, goarch string) (shared, dynlink bool) {
+	switch m {
+	case BuildModeCArchive:
+		switch goos {
+		case "darwin", "ios":
+			return goarch == "arm64", false
+		case "dragonfly", "freebsd", "illumos", "linux", "netbsd", "openbsd", "solaris":
+			return true, false
+		}
+	case BuildModeCShared:
+		switch goos {
+		case "linux", "android", "freebsd":
+			return true, false
+		}
+	case BuildModePIE:
+		switch goos {
+		case "aix", "windows":
+		default:
+			return true, false
+		}
+	case BuildModeShared, BuildModePlugin:
+		return false, true
+	}
+	return false, false
+}
+
+// Validate checks the Arch, BuildMode, LinkMode and HeaderType of the args,
+// and that they are supported by Context.GOOS and Context.GOARCH.
+func (args LinkArgs) Validate() error {
+	goos, goarch := args.Context.GOOS, args.Context.GOARCH
+	if err := args.Arch.Validate(goarch); err != nil {
+		return err
+	}
+	if args.BuildMode != "" {
+		if !args.BuildMode.Valid() {
+			return fmt.Errorf("invalid buildmode %q", args.BuildMode)
+		}
+		if !args.BuildMode.Supported(goos, goarch) {
+			return fmt.Errorf("buildmode %s not supported on %s/%s", args.BuildMode, goos, goarch)
+		}
+	}
+	if args.LinkMode != "" && !args.LinkMode.Valid() {
+		return fmt.Errorf("invalid linkmode %q", args.LinkMode)
+	}
+	if args.LinkMode == LinkModeInternal && mustLinkExternal(args.BuildMode, goos, goarch, args.Context.CgoEnabled) {
+		mode := args.BuildMode
+		if mode == "" {
+			mode = BuildModeExe
+		}
+		return fmt.Errorf("linkmode internal not supported with buildmode %s on %s/%s", mode, goos, goarch)
+	}
+	if args.HeaderType != "" && !args.HeaderType.Valid() {
+		return fmt.Errorf("invalid header type %q", args.HeaderType)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package build_test
+
+import (
+	"bytes"
+	gb "go/build"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLinkArgsValidate(t *testing.T) {
+	testCases := []struct {
+		Args  build.LinkArgs
+		Error string
+	}{
+		{build.LinkArgs{Context: gb.Context{GOOS: "linux", GOARCH: "amd64"}}, ""},
+		{
+			build.LinkArgs{
+				Context:    gb.Context{GOOS: "linux", GOARCH: "amd64"},
+				BuildMode:  build.BuildModePIE,
+				LinkMode:   build.LinkModeInternal,
+				HeaderType: build.HeaderTypeLinux,
+			},
+			"",
+		},
+		{build.LinkArgs{Context: gb.Context{GOOS: "darwin", GOARCH: "arm64"}, BuildMode: build.BuildModeCShared}, ""},
+		{build.LinkArgs{Context: gb.Context{GOOS: "windows", GOARCH: "amd64"}, HeaderType: build.HeaderTypeWindowsGUI}, ""},
+		{build.LinkArgs{Context: gb.Context{GOOS: "linux", GOARCH: "amd64"}, BuildMode: "c-shared "}, `invalid buildmode "c-shared "`},
+		{build.LinkArgs{Context: gb.Context{GOOS: "linux", GOARCH: "amd64"}, BuildMode: "exe2"}, `invalid buildmode "exe2"`},
+		{build.LinkArgs{Context: gb.Context{GOOS: "windows", GOARCH: "amd64"}, BuildMode: build.BuildModePlugin}, "buildmode plugin not supported on windows/amd64"},
+		{build.LinkArgs{Context: gb.Context{GOOS: "linux", GOARCH: "mips"}, BuildMode: build.BuildModeCArchive}, "buildmode c-archive not supported on linux/mips"},
+		{build.LinkArgs{Context: gb.Context{GOOS: "linux", GOARCH: "amd64"}, LinkMode: "extern"}, `invalid linkmode "extern"`},
+		{
+			build.LinkArgs{Context: gb.Context{GOOS: "linux", GOARCH: "amd64"}, BuildMode: build.BuildModeCShared, LinkMode: build.LinkModeInternal},
+			"linkmode internal not supported with buildmode c-shared on linux/amd64",
+		},
+		{
+			build.LinkArgs{Context: gb.Context{GOOS: "android", GOARCH: "arm"}, LinkMode: build.LinkModeInternal},
+			"linkmode internal not supported with buildmode exe on android/arm",
+		},
+		{build.LinkArgs{Context: gb.Context{GOOS: "linux", GOARCH: "amd64"}, HeaderType: "elf"}, `invalid header type "elf"`},
+		{build.LinkArgs{Context: gb.Context{GOOS: "linux", GOARCH: "amd64"}, Arch: build.Arch{GOARM: "7"}}, "GOARM is not supported with GOARCH=amd64"},
+	}
+	for c, tc := range testCases {
+		err := tc.Args.Validate()
+		if tc.Error == "" {
+			assert.NoErrorf(t, err, "failed with case %d", c)
+		} else {
+			assert.EqualErrorf(t, err, tc.Error, "failed with case %d", c)
+		}
+	}
+}
+
+func TestLinkValidatesBeforeRunning(t *testing.T) {
+	tools := build.NewCmdTools()
+	tools.Linker = "/nonexistent/link"
+	err := tools.Link(build.LinkArgs{
+		Context:   gb.Context{GOOS: "linux", GOARCH: "amd64"},
+		Stdout:    &bytes.Buffer{},
+		BuildMode: "exe2",
+	})
+	assert.EqualError(t, err, `invalid buildmode "exe2"`)
+}
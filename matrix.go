@@ -0,0 +1,269 @@
+package build
+
+import (
+	"fmt"
+	gb "go/build"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Target is a platform to build for.
+type Target struct {
+	GOOS   string
+	GOARCH string
+	// Arch settings of the target, such as GOAMD64 or GOARM.
+	Arch Arch
+}
+
+// ParseTarget parses a target written as "goos/goarch", optionally followed
+// by "/variant" setting the sub-architecture of goarch, like "linux/amd64/v3"
+// or "linux/arm/7".
+func ParseTarget(s string) (Target, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Target{}, fmt.Errorf("invalid target %q, must be goos/goarch[/variant]", s)
+	}
+	t := Target{GOOS: parts[0], GOARCH: parts[1]}
+	if len(parts) == 3 && !t.Arch.SetSetting(t.GOARCH, parts[2]) {
+		return Target{}, fmt.Errorf("invalid target %q, GOARCH=%s has no variants", s, t.GOARCH)
+	}
+	return t, t.Validate()
+}
+
+// String returns the target in the format parsed by ParseTarget.
+func (t Target) String() string {
+	s := t.GOOS + "/" + t.GOARCH
+	if _, v := t.Arch.Setting(t.GOARCH); v != "" {
+		s += "/" + v
+	}
+	return s
+}
+
+// Validate checks that go/build knows GOOS and GOARCH, that the pair is a
+// supported port and that the Arch settings apply to it.
+func (t Target) Validate() error {
+	if !knownOS(t.GOOS) {
+		return fmt.Errorf("unknown GOOS %q", t.GOOS)
+	}
+	if !knownArch(t.GOARCH) {
+		return fmt.Errorf("unknown GOARCH %q", t.GOARCH)
+	}
+	if !platforms[t.GOOS+"/"+t.GOARCH] {
+		return fmt.Errorf("unsupported GOOS/GOARCH pair %s/%s", t.GOOS, t.GOARCH)
+	}
+	return t.Arch.Validate(t.GOARCH)
+}
+
+// knownOS reports whether go/build knows goos, in which case it excludes files
+// named after goos when building for another system.
+func knownOS(goos string) bool {
+	return !matchFile("x_" + goos + ".go")
+}
+
+// knownArch reports whether go/build knows goarch, in which case it excludes
+// files named after goarch when building for another architecture.
+func knownArch(goarch string) bool {
+	return !matchFile("x_" + goarch + ".go")
+}
+
+func matchFile(name string) bool {
+	ctx := gb.Context{
+		GOOS:   "unknown",
+		GOARCH: "unknown",
+		OpenFile: func(string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader("package p\n")), nil
+		},
+	}
+	match, err := ctx.MatchFile("", name)
+	return err == nil && match
+}
+
+// platforms are the ports listed by "go tool dist list".
+var platforms = map[string]bool{
+	"aix/ppc64":       true,
+	"android/386":     true,
+	"android/amd64":   true,
+	"android/arm":     true,
+	"android/arm64":   true,
+	"darwin/amd64":    true,
+	"darwin/arm64":    true,
+	"dragonfly/amd64": true,
+	"freebsd/386":     true,
+	"freebsd/amd64":   true,
+	"freebsd/arm":     true,
+	"freebsd/arm64":   true,
+	"illumos/amd64":   true,
+	"ios/amd64":       true,
+	"ios/arm64":       true,
+	"js/wasm":         true,
+	"linux/386":       true,
+	"linux/amd64":     true,
+	"linux/arm":       true,
+	"linux/arm64":     true,
+	"linux/loong64":   true,
+	"linux/mips":      true,
+	"linux/mips64":    true,
+	"linux/mips64le":  true,
+	"linux/mipsle":    true,
+	"linux/ppc64":     true,
+	"linux/ppc64le":   true,
+	"linux/riscv64":   true,
+	"linux/s390x":     true,
+	"netbsd/386":      true,
+	"netbsd/amd64":    true,
+	"netbsd/arm":      true,
+	"netbsd/arm64":    true,
+	"openbsd/386":     true,
+	"openbsd/amd64":   true,
+	"openbsd/arm":     true,
+	"openbsd/arm64":   true,
+	"openbsd/ppc64":   true,
+	"openbsd/riscv64": true,
+	"plan9/386":       true,
+	"plan9/amd64":     true,
+	"plan9/arm":       true,
+	"solaris/amd64":   true,
+	"wasip1/wasm":     true,
+	"windows/386":     true,
+	"windows/amd64":   true,
+	"windows/arm64":   true,
+}
+
+// Matrix builds a command for several targets concurrently.
+type Matrix struct {
+	// Builder is the template of the builder of each target. The GOOS,
+	// GOARCH and Arch of the target replace its own, and cgo is only kept
+	// enabled for the host platform. WorkDir and CacheDir, if set, get a
+	// subdirectory per target. Stderr and Events are shared by the targets,
+	// written to by one at a time.
+	Builder Builder
+	// Targets to build.
+	Targets []Target
+	// OutputDir receives the binaries, named after the command and target
+	// like "name_linux_amd64_v3".
+	OutputDir string
+	// Name of the binaries, the last element of the import path if empty.
+	Name string
+	// Parallelism is the number of targets built at once, all if <= 0.
+	Parallelism int
+}
+
+// TargetResult is the result of building a Matrix target.
+type TargetResult struct {
+	Target Target
+	// Binary is the path of the linked executable.
+	Binary string
+	// BuildID of the binary.
+	BuildID string
+	// Size of the binary in bytes.
+	Size int64
+	// Diagnostics written by the tools.
+	Diagnostics string
+	// Err is the error building the target, if any.
+	Err error
+}
+
+// Build builds the command importPath, as found from srcDir, for all the
+// targets. Targets are validated before anything is built, failures building
+// a target are reported in its result.
+func (m *Matrix) Build(importPath, srcDir string) ([]TargetResult, error) {
+	for _, t := range m.Targets {
+		if err := t.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	name := m.Name
+	if name == "" {
+		name = path.Base(importPath)
+		if gb.IsLocalImport(importPath) {
+			dir, err := filepath.Abs(filepath.Join(srcDir, importPath))
+			if err != nil {
+				return nil, err
+			}
+			name = filepath.Base(dir)
+		}
+	}
+	parallelism := m.Parallelism
+	if parallelism <= 0 {
+		parallelism = len(m.Targets)
+	}
+
+	// The targets share the writers of the builder.
+	b := m.Builder
+	if b.Stderr != nil {
+		b.Stderr = &syncWriter{w: b.Stderr}
+	}
+	if b.Events != nil {
+		b.Events = &syncWriter{w: b.Events}
+	}
+	results := make([]TargetResult, len(m.Targets))
+	sem := make(chan struct{}, parallelism)
+	wg := sync.WaitGroup{}
+	for i, t := range m.Targets {
+		wg.Add(1)
+		go func(i int, t Target) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = m.buildTarget(b, t, importPath, srcDir, name)
+		}(i, t)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+func (m *Matrix) buildTarget(b Builder, t Target, importPath, srcDir, name string) TargetResult {
+	dir := targetDir(t)
+	b.Context.GOOS = t.GOOS
+	b.Context.GOARCH = t.GOARCH
+	b.Context.CgoEnabled = b.Context.CgoEnabled && t.GOOS == runtime.GOOS && t.GOARCH == runtime.GOARCH
+	b.Arch = t.Arch
+	b.Arch.GOEXPERIMENT = m.Builder.Arch.GOEXPERIMENT
+	if b.WorkDir != "" {
+		b.WorkDir = filepath.Join(b.WorkDir, dir)
+	}
+	if b.CacheDir != "" {
+		b.CacheDir = filepath.Join(b.CacheDir, dir)
+	}
+	binary := filepath.Join(m.OutputDir, name+"_"+dir)
+	if t.GOOS == "windows" {
+		binary += ".exe"
+	}
+
+	result := TargetResult{Target: t}
+	r, err := b.Build(importPath, srcDir, binary)
+	if r != nil {
+		result.Binary = r.Binary
+		result.BuildID = r.BuildID
+		result.Size = r.Size
+		result.Diagnostics = r.Diagnostics
+	}
+	result.Err = err
+	return result
+}
+
+// syncWriter serializes the writes to w.
+type syncWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.w.Write(p)
+}
+
+// targetDir returns a file name friendly form of t, like "linux_arm_7".
+func targetDir(t Target) string {
+	s := t.GOOS + "_" + t.GOARCH
+	if _, v := t.Arch.Setting(t.GOARCH); v != "" {
+		s += "_" + strings.Replace(v, ",", "-", -1)
+	}
+	return s
+}
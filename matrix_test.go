@@ -0,0 +1,188 @@
+package build_test
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/gophertest/build/buildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTarget(t *testing.T) {
+	testCases := []struct {
+		Target   string
+		Expected build.Target
+		Error    string
+	}{
+		{"linux/amd64", build.Target{GOOS: "linux", GOARCH: "amd64"}, ""},
+		{"linux/amd64/v3", build.Target{GOOS: "linux", GOARCH: "amd64", Arch: build.Arch{GOAMD64: "v3"}}, ""},
+		{"linux/arm/7", build.Target{GOOS: "linux", GOARCH: "arm", Arch: build.Arch{GOARM: "7"}}, ""},
+		{"windows/386/softfloat", build.Target{GOOS: "windows", GOARCH: "386", Arch: build.Arch{GO386: "softfloat"}}, ""},
+		{"linux", build.Target{}, `invalid target "linux", must be goos/goarch[/variant]`},
+		{"linux/s390x/z15", build.Target{}, `invalid target "linux/s390x/z15", GOARCH=s390x has no variants`},
+		{"plan10/amd64", build.Target{GOOS: "plan10", GOARCH: "amd64"}, `unknown GOOS "plan10"`},
+		{"linux/amd65", build.Target{GOOS: "linux", GOARCH: "amd65"}, `unknown GOARCH "amd65"`},
+		{"windows/mips", build.Target{GOOS: "windows", GOARCH: "mips"}, "unsupported GOOS/GOARCH pair windows/mips"},
+		{"linux/amd64/v9", build.Target{GOOS: "linux", GOARCH: "amd64", Arch: build.Arch{GOAMD64: "v9"}}, "invalid GOAMD64=v9: must be v1, v2, v3, v4"},
+	}
+	for c, tc := range testCases {
+		target, err := build.ParseTarget(tc.Target)
+		if tc.Error == "" {
+			assert.NoErrorf(t, err, "failed with case %d", c)
+			assert.Equalf(t, tc.Target, target.String(), "failed with case %d", c)
+		} else {
+			assert.EqualErrorf(t, err, tc.Error, "failed with case %d", c)
+		}
+		assert.Equalf(t, tc.Expected, target, "failed with case %d", c)
+	}
+}
+
+func TestMatrixBuild(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "matrix")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	targets := []build.Target(nil)
+	for _, v := range []string{"linux/amd64/v3", "windows/386", "linux/arm/7"} {
+		target, err := build.ParseTarget(v)
+		require.NoError(t, err)
+		targets = append(targets, target)
+	}
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	ft.Respond(buildtest.BuildID, buildtest.Response{Stdout: "id"})
+	m := &build.Matrix{
+		Builder: build.Builder{
+			Tools:    ft,
+			Context:  ctx,
+			CacheDir: filepath.Join(dir, "cache"),
+		},
+		Targets:     targets,
+		OutputDir:   filepath.Join(dir, "bin"),
+		Parallelism: 2,
+	}
+	results, err := m.Build("example.com/hello", "")
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	for i, name := range []string{"hello_linux_amd64_v3", "hello_windows_386.exe", "hello_linux_arm_7"} {
+		r := results[i]
+		assert.NoErrorf(t, r.Err, "failed with %s", r.Target)
+		assert.Equal(t, targets[i], r.Target)
+		assert.Equal(t, filepath.Join(dir, "bin", name), r.Binary)
+		assert.Equal(t, "id", r.BuildID)
+		assert.Equal(t, int64(len("link\n")), r.Size)
+		assert.FileExists(t, r.Binary)
+	}
+
+	links := []string(nil)
+	for _, v := range ft.LinkCalls() {
+		links = append(links, v.Context.GOOS+"/"+v.Context.GOARCH+"/"+v.Arch.GOAMD64+v.Arch.GOARM)
+	}
+	sort.Strings(links)
+	assert.Equal(t, []string{"linux/amd64/v3", "linux/arm/7", "windows/386/"}, links)
+
+	caches, err := ioutil.ReadDir(filepath.Join(dir, "cache"))
+	require.NoError(t, err)
+	names := []string(nil)
+	for _, v := range caches {
+		names = append(names, v.Name())
+	}
+	assert.Equal(t, []string{"linux_amd64_v3", "linux_arm_7", "windows_386"}, names)
+
+	m.Targets = []build.Target{{GOOS: "windows", GOARCH: "mips"}}
+	_, err = m.Build("example.com/hello", "")
+	assert.EqualError(t, err, "unsupported GOOS/GOARCH pair windows/mips")
+}
+
+// TestMatrixBuildEvents checks, run with -race, that the targets share the
+// writers of the builder safely.
+func TestMatrixBuildEvents(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "matrix")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	targets := []build.Target(nil)
+	for _, v := range []string{"linux/amd64", "linux/arm64", "linux/386", "windows/amd64"} {
+		target, err := build.ParseTarget(v)
+		require.NoError(t, err)
+		targets = append(targets, target)
+	}
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	ft.Respond(buildtest.Compile, buildtest.Response{Stderr: "warning\n"})
+	events := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	m := &build.Matrix{
+		Builder:   build.Builder{Tools: ft, Context: ctx, Events: events, Stderr: stderr},
+		Targets:   targets,
+		OutputDir: filepath.Join(dir, "bin"),
+	}
+	results, err := m.Build("example.com/hello", "")
+	require.NoError(t, err)
+	for _, r := range results {
+		require.NoError(t, r.Err, r.Target.String())
+	}
+
+	passes := 0
+	dec := json.NewDecoder(events)
+	for dec.More() {
+		e := build.BuildEvent{}
+		require.NoError(t, dec.Decode(&e))
+		if e.Action == build.EventPass && e.ImportPath == "example.com/hello" {
+			passes++
+		}
+	}
+	assert.Equal(t, len(targets), passes)
+	assert.Equal(t, len(ft.CompileCalls()), strings.Count(stderr.String(), "warning\n"))
+	assert.Empty(t, strings.Replace(stderr.String(), "warning\n", "", -1))
+}
+
+func TestMatrixBuildDefaultTools(t *testing.T) {
+	ctx, restore := realContext(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "matrix")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	host := build.Target{GOOS: runtime.GOOS, GOARCH: runtime.GOARCH}
+	cross := build.Target{GOOS: "linux", GOARCH: "arm64"}
+	if host == cross {
+		cross.GOARCH = "amd64"
+	}
+	m := &build.Matrix{
+		Builder:   build.Builder{Tools: build.DefaultTools, Context: ctx},
+		Targets:   []build.Target{host, cross},
+		OutputDir: dir,
+	}
+	results, err := m.Build("example.com/hello", "")
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	for _, r := range results {
+		require.NoError(t, r.Err, "%s: %s", r.Target, r.Diagnostics)
+		assert.NotEmpty(t, r.BuildID, r.Target.String())
+	}
+	out, err := exec.Command(results[0].Binary).CombinedOutput()
+	require.NoError(t, err, string(out))
+	assert.Equal(t, "hello, world\n", string(out))
+
+	f, err := elf.Open(results[1].Binary)
+	require.NoError(t, err)
+	defer f.Close()
+	machine := map[string]elf.Machine{"arm64": elf.EM_AARCH64, "amd64": elf.EM_X86_64}[cross.GOARCH]
+	assert.Equal(t, machine, f.Machine)
+}
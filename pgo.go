@@ -0,0 +1,86 @@
+package build
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Values of Builder.PGO other than a profile path.
+const (
+	// PGOOff disables profile-guided optimization.
+	PGOOff = "off"
+	// PGOAuto uses the default.pgo file next to the main package, if any and
+	// if the toolchain supports it.
+	PGOAuto = "auto"
+)
+
+// pgoProfile returns the absolute path of the profile of the build of plan
+// selected by b.PGO, empty if none, and the hash of its content.
+func (b *Builder) pgoProfile(plan *Plan, version string) (string, string, error) {
+	file := ""
+	switch b.PGO {
+	case "", PGOOff:
+		return "", "", nil
+	case PGOAuto:
+		if plan.Main.Name != "main" || !supportsPGO(version) {
+			return "", "", nil
+		}
+		file = filepath.Join(plan.Main.Dir, "default.pgo")
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			return "", "", nil
+		}
+	default:
+		if !supportsPGO(version) {
+			return "", "", fmt.Errorf("profile-guided optimization requires go1.21 or later, have %q", version)
+		}
+		abs, err := filepath.Abs(b.PGO)
+		if err != nil {
+			return "", "", err
+		}
+		file = abs
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", "", err
+	}
+	return file, fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+// supportsPGO reports whether the toolchain of the "go version" output
+// supports profile-guided optimization.
+func supportsPGO(version string) bool {
+	minor, ok := goMinorVersion(version)
+	return ok && minor >= 21
+}
+
+// goMinorVersion returns the minor version of the go1.x toolchain of the
+// "go version" output. Development versions are reported as the latest.
+func goMinorVersion(version string) (int, bool) {
+	for _, v := range strings.Fields(version) {
+		if v == "devel" {
+			return int(^uint(0) >> 1), true
+		}
+		if !strings.HasPrefix(v, "go1") {
+			continue
+		}
+		v = strings.TrimPrefix(strings.TrimPrefix(v, "go1"), ".")
+		if v == "" {
+			return 0, true
+		}
+		end := 0
+		for end < len(v) && v[end] >= '0' && v[end] <= '9' {
+			end++
+		}
+		minor, err := strconv.Atoi(v[:end])
+		if err != nil {
+			return 0, false
+		}
+		return minor, true
+	}
+	return 0, false
+}
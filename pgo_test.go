@@ -0,0 +1,133 @@
+package build_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/gophertest/build/buildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilderPGO(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "pgo")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	profile, err := filepath.Abs("testdata/src/example.com/pgo/default.pgo")
+	require.NoError(t, err)
+
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	ft.VersionString = "go version go1.21.0 linux/amd64"
+	b := &build.Builder{
+		Tools:    ft,
+		Context:  ctx,
+		CacheDir: filepath.Join(dir, "cache"),
+		PGO:      build.PGOAuto,
+	}
+	_, err = b.Build("example.com/pgo", "", filepath.Join(dir, "pgo"))
+	require.NoError(t, err)
+	ft.AssertCompiled(t, "runtime")
+	for _, v := range ft.CompileCalls() {
+		assert.Equalf(t, profile, v.ProfileFile, "failed with %s", v.PackageImportPath)
+	}
+
+	// The profile is part of the cache key.
+	ft.Reset()
+	b.PGO = build.PGOOff
+	_, err = b.Build("example.com/pgo", "", filepath.Join(dir, "pgo"))
+	require.NoError(t, err)
+	ft.AssertCompiled(t, "runtime")
+	for _, v := range ft.CompileCalls() {
+		assert.Empty(t, v.ProfileFile)
+	}
+
+	// The same profile hits the cache.
+	ft.Reset()
+	b.PGO = "testdata/src/example.com/pgo/default.pgo"
+	_, err = b.Build("example.com/pgo", "", filepath.Join(dir, "pgo"))
+	require.NoError(t, err)
+	ft.AssertNotCalled(t, buildtest.Compile)
+
+	// Explicit profiles apply to any package.
+	ft.Reset()
+	b.CacheDir = ""
+	_, err = b.Build("example.com/greet", "", "")
+	require.NoError(t, err)
+	ft.AssertCompiled(t, "example.com/greet")
+	for _, v := range ft.CompileCalls() {
+		assert.Equal(t, profile, v.ProfileFile)
+	}
+
+	// Auto only picks default.pgo if there is one.
+	ft.Reset()
+	b.PGO = build.PGOAuto
+	_, err = b.Build("example.com/hello", "", filepath.Join(dir, "hello"))
+	require.NoError(t, err)
+	for _, v := range ft.CompileCalls() {
+		assert.Empty(t, v.ProfileFile)
+	}
+}
+
+func TestBuilderPGODefaultTools(t *testing.T) {
+	ctx, restore := realContext(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "pgo")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	stderr := &strings.Builder{}
+	b := &build.Builder{Tools: build.DefaultTools, Context: ctx, Stderr: stderr, PGO: build.PGOAuto}
+	binary := filepath.Join(dir, "pgo")
+	_, err = b.Build("example.com/pgo", "", binary)
+	require.NoError(t, err, stderr.String())
+	out, err := exec.Command(binary).CombinedOutput()
+	require.NoError(t, err, string(out))
+	assert.Equal(t, "hello, world\n", string(out))
+}
+
+func TestBuilderPGOVersion(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "pgo")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	testCases := []struct {
+		Version string
+		PGO     string
+		Profile bool
+		Error   string
+	}{
+		{"go version go1.13 linux/amd64", build.PGOAuto, false, ""},
+		{"go version go1.20.5 linux/amd64", build.PGOAuto, false, ""},
+		{"go version go1.21rc2 linux/amd64", build.PGOAuto, true, ""},
+		{"go version go1.27.1 linux/amd64", build.PGOAuto, true, ""},
+		{"go version devel go1.28-abcdef linux/amd64", build.PGOAuto, true, ""},
+		{"go version go1.13 linux/amd64", "testdata/src/example.com/pgo/default.pgo", false,
+			`profile-guided optimization requires go1.21 or later, have "go version go1.13 linux/amd64"`},
+		{"go version go1.21.0 linux/amd64", "testdata/missing.pgo", false, "open"},
+	}
+	for _, tc := range testCases {
+		ft := buildtest.NewFakeTools()
+		ft.WriteOutputs = true
+		ft.VersionString = tc.Version
+		b := &build.Builder{Tools: ft, Context: ctx, PGO: tc.PGO}
+		_, err := b.Build("example.com/pgo", "", filepath.Join(dir, "pgo"))
+		if tc.Error != "" {
+			require.Errorf(t, err, "failed with %s", tc.Version)
+			assert.Containsf(t, err.Error(), tc.Error, "failed with %s", tc.Version)
+			ft.AssertNotCalled(t, buildtest.Compile)
+			continue
+		}
+		require.NoErrorf(t, err, "failed with %s", tc.Version)
+		assert.Equalf(t, tc.Profile, ft.CompileCalls()[0].ProfileFile != "", "failed with %s", tc.Version)
+	}
+}
@@ -0,0 +1,161 @@
+package build
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// BuildPlugin builds the main package importPath, as found from srcDir, into
+// a Go plugin written to outputFile. All packages are compiled for dynamic
+// linking and the plugin path is derived from the content of the plugin, so
+// that rebuilding an unchanged plugin gives the same path.
+//
+// If host is set, it is the executable loading the plugin, which must import
+// package plugin. The packages shared by the host and the plugin must come
+// from the same sources and toolchain, as the runtime refuses to load the
+// plugin otherwise: the plugin is removed if they do not.
+func (b *Builder) BuildPlugin(importPath, srcDir, host, outputFile string) (*Result, error) {
+	if !b.Context.CgoEnabled {
+		return nil, fmt.Errorf("buildmode plugin requires cgo")
+	}
+	if !BuildModePlugin.Supported(b.Context.GOOS, b.Context.GOARCH) {
+		return nil, fmt.Errorf("buildmode plugin not supported on %s/%s", b.Context.GOOS, b.Context.GOARCH)
+	}
+	hostHashes := map[string]string(nil)
+	if host != "" {
+		var err error
+		if hostHashes, err = packageHashes(host); err != nil {
+			return nil, err
+		}
+		if len(hostHashes) == 0 {
+			return nil, fmt.Errorf("%s: no package hashes, the host does not import plugin", host)
+		}
+	}
+	pb := *b
+	pb.BuildMode = BuildModePlugin
+	plan, err := pb.Load(importPath, srcDir)
+	if err != nil {
+		return nil, err
+	}
+	if plan.Main.Name != "main" {
+		return nil, fmt.Errorf("%s: a plugin must be built from package main, not %s", plan.Main.ImportPath, plan.Main.Name)
+	}
+	result, err := pb.BuildPlan(plan, outputFile)
+	if err != nil || host == "" {
+		return result, err
+	}
+	pluginHashes, err := packageHashes(outputFile)
+	if err == nil {
+		err = checkPluginHost(pluginHashes, hostHashes)
+	}
+	if err != nil {
+		os.Remove(outputFile)
+		return nil, err
+	}
+	return result, nil
+}
+
+// pluginPath returns the path identifying the plugin built from the main
+// package p, derived from its fingerprint like the go command does for
+// plugins built from files.
+func pluginPath(p *Package) string {
+	return "plugin/unnamed-" + p.Fingerprint
+}
+
+// checkPluginHost checks that the packages of a plugin also in its host have
+// the same hash, as the runtime refuses to load the plugin otherwise.
+func checkPluginHost(plugin, host map[string]string) error {
+	paths := []string(nil)
+	for k := range plugin {
+		paths = append(paths, k)
+	}
+	sort.Strings(paths)
+	for _, v := range paths {
+		if hash, ok := host[v]; ok && hash != plugin[v] {
+			return fmt.Errorf("plugin was built with a different version of package %s", v)
+		}
+	}
+	return nil
+}
+
+// pkgHashPrefixes are the prefixes of the symbols holding the hash of each
+// package, recorded by the linker in plugins and in the binaries importing
+// package plugin.
+var pkgHashPrefixes = []string{"go:link.pkghashbytes.", "go.link.pkghashbytes."}
+
+// packageHashes returns the package hashes of the ELF or Mach-O binary file,
+// keyed by import path.
+func packageHashes(file string) (map[string]string, error) {
+	if f, err := elf.Open(file); err == nil {
+		defer f.Close()
+		syms, err := f.Symbols()
+		if err == elf.ErrNoSymbols {
+			return map[string]string{}, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		hashes := map[string]string{}
+		for _, s := range syms {
+			path := pkgHashPath(s.Name)
+			if path == "" || int(s.Section) >= len(f.Sections) {
+				continue
+			}
+			hash, err := sectionBytes(f.Sections[s.Section].ReaderAt, f.Sections[s.Section].Addr, s.Value, s.Size)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %v", file, s.Name, err)
+			}
+			hashes[path] = hash
+		}
+		return hashes, nil
+	}
+	if f, err := macho.Open(file); err == nil {
+		defer f.Close()
+		hashes := map[string]string{}
+		if f.Symtab == nil {
+			return hashes, nil
+		}
+		for _, s := range f.Symtab.Syms {
+			path := pkgHashPath(strings.TrimPrefix(s.Name, "_"))
+			if path == "" || s.Sect == 0 || int(s.Sect) > len(f.Sections) {
+				continue
+			}
+			sect := f.Sections[s.Sect-1]
+			hash, err := sectionBytes(sect, sect.Addr, s.Value, 8)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %v", file, s.Name, err)
+			}
+			hashes[path] = hash
+		}
+		return hashes, nil
+	}
+	return nil, fmt.Errorf("%s: not an ELF or Mach-O binary", file)
+}
+
+func pkgHashPath(symbol string) string {
+	for _, v := range pkgHashPrefixes {
+		if strings.HasPrefix(symbol, v) {
+			return symbol[len(v):]
+		}
+	}
+	return ""
+}
+
+// sectionBytes returns the size bytes at addr of the section at sectAddr,
+// hex encoded.
+func sectionBytes(r io.ReaderAt, sectAddr, addr, size uint64) (string, error) {
+	if addr < sectAddr {
+		return "", fmt.Errorf("address %#x out of section", addr)
+	}
+	data := make([]byte, size)
+	if _, err := r.ReadAt(data, int64(addr-sectAddr)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(data), nil
+}
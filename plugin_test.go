@@ -0,0 +1,130 @@
+package build_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/gophertest/build/buildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildPlugin(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+	ctx.CgoEnabled = true
+	dir, err := ioutil.TempDir("", "plugin")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	b := &build.Builder{Tools: ft, Context: ctx, CacheDir: filepath.Join(dir, "cache")}
+	output := filepath.Join(dir, "greet.so")
+	result, err := b.BuildPlugin("example.com/greetplugin", "", "", output)
+	require.NoError(t, err)
+	assert.Equal(t, output, result.Binary)
+	assert.Empty(t, b.BuildMode)
+
+	pluginPath := ""
+	for _, v := range ft.CompileCalls() {
+		assert.Truef(t, v.DynamicLink, "%s not compiled with -dynlink", v.PackageImportPath)
+		if strings.HasPrefix(v.PackageImportPath, "plugin/unnamed-") {
+			pluginPath = v.PackageImportPath
+		}
+	}
+	require.NotEmpty(t, pluginPath)
+	ft.AssertCompiled(t, "example.com/greet")
+	links := ft.LinkCalls()
+	require.Len(t, links, 1)
+	assert.Equal(t, build.BuildModePlugin, links[0].BuildMode)
+	assert.Equal(t, pluginPath, links[0].PluginPath)
+
+	// The plugin path only depends on the content of the plugin.
+	ft.Reset()
+	_, err = b.BuildPlugin("example.com/greetplugin", "", "", output)
+	require.NoError(t, err)
+	ft.AssertNotCalled(t, buildtest.Compile)
+	assert.Equal(t, pluginPath, ft.LinkCalls()[0].PluginPath)
+}
+
+func TestBuildPluginError(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+	ctx.CgoEnabled = true
+	dir, err := ioutil.TempDir("", "plugin")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	b := &build.Builder{Tools: ft, Context: ctx}
+	host := filepath.Join(dir, "hello")
+	_, err = b.Build("example.com/hello", "", host)
+	require.NoError(t, err)
+	ft.Reset()
+	_, err = b.BuildPlugin("example.com/greetplugin", "", host, "out")
+	assert.EqualError(t, err, host+": not an ELF or Mach-O binary")
+	ft.AssertNotCalled(t, buildtest.Compile)
+
+	_, err = b.BuildPlugin("example.com/greet", "", "", "out")
+	assert.EqualError(t, err, "example.com/greet: a plugin must be built from package main, not greet")
+
+	ctx.CgoEnabled = false
+	b = &build.Builder{Tools: ft, Context: ctx}
+	_, err = b.BuildPlugin("example.com/greetplugin", "", "", "out")
+	assert.EqualError(t, err, "buildmode plugin requires cgo")
+
+	ctx.CgoEnabled = true
+	ctx.GOOS = "windows"
+	b = &build.Builder{Tools: ft, Context: ctx}
+	_, err = b.BuildPlugin("example.com/greetplugin", "", "", "out")
+	assert.EqualError(t, err, "buildmode plugin not supported on windows/amd64")
+	ft.AssertNotCalled(t, buildtest.Compile)
+}
+
+func TestBuildPluginDefaultTools(t *testing.T) {
+	ctx, restore := realCgoContext(t)
+	defer restore()
+	if !build.BuildModePlugin.Supported(ctx.GOOS, ctx.GOARCH) {
+		t.Skipf("buildmode plugin not supported on %s/%s", ctx.GOOS, ctx.GOARCH)
+	}
+	dir, err := ioutil.TempDir("", "plugin")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The sources are copied to change greet once the host is built.
+	ctx.GOPATH = filepath.Join(dir, "gopath")
+	copyPackages(t, ctx.GOPATH, "greet", "greetplugin", "pluginhost")
+
+	stderr := &strings.Builder{}
+	b := &build.Builder{Tools: build.DefaultTools, Context: ctx, Stderr: stderr, CacheDir: filepath.Join(dir, "cache")}
+	host := filepath.Join(dir, "host")
+	_, err = b.Build("example.com/pluginhost", "", host)
+	require.NoError(t, err, stderr.String())
+	output := filepath.Join(dir, "greet.so")
+	_, err = b.BuildPlugin("example.com/greetplugin", "", host, output)
+	require.NoError(t, err, stderr.String())
+	out, err := exec.Command(host, output).CombinedOutput()
+	require.NoError(t, err, string(out))
+	assert.Equal(t, "host: hello, world\nplugin: hello, world\n", string(out))
+
+	greet := filepath.Join(ctx.GOPATH, "src", "example.com", "greet", "greet.go")
+	f, err := os.OpenFile(greet, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString("\n// Bye returns a farewell.\nfunc Bye() string { return \"bye\" }\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, err = b.BuildPlugin("example.com/greetplugin", "", host, output)
+	assert.EqualError(t, err, "plugin was built with a different version of package example.com/greet")
+	_, err = os.Stat(output)
+	assert.True(t, os.IsNotExist(err), "plugin not removed")
+
+	_, err = b.BuildPlugin("example.com/greetplugin", "", os.Args[0], output)
+	assert.EqualError(t, err, os.Args[0]+": no package hashes, the host does not import plugin")
+}
@@ -0,0 +1,191 @@
+package build
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ReproducibilityReport lists the differences between two builds of the same
+// package.
+type ReproducibilityReport struct {
+	// Differences of the package archives in dependency order, then of the
+	// binary.
+	Differences []Difference
+}
+
+// Difference is an output that differs between two builds.
+type Difference struct {
+	// Package whose archive differs, empty if the binary differs.
+	Package string
+	// Member is the archive member or binary section holding the first
+	// differing byte, empty if unknown.
+	Member string
+	// Offset of the first differing byte in the file.
+	Offset int64
+}
+
+// Reproducible reports whether both builds produced the same outputs.
+func (r *ReproducibilityReport) Reproducible() bool {
+	return len(r.Differences) == 0
+}
+
+// String returns the differences, one per line.
+func (r *ReproducibilityReport) String() string {
+	buf := &bytes.Buffer{}
+	for _, v := range r.Differences {
+		fmt.Fprintf(buf, "%s\n", v)
+	}
+	return buf.String()
+}
+
+func (d Difference) String() string {
+	what := "binary"
+	if d.Package != "" {
+		what = "archive of " + d.Package
+	}
+	if d.Member == "" {
+		return fmt.Sprintf("%s differs at offset %d", what, d.Offset)
+	}
+	return fmt.Sprintf("%s differs in %s at offset %d", what, d.Member, d.Offset)
+}
+
+// VerifyReproducible builds a package twice without caching, in different
+// work directories and with different temporary directories in the
+// environment, and compares the archives and binary byte for byte. TrimPath
+// is set for both builds; build IDs only depend on the inputs of the build.
+func (b *Builder) VerifyReproducible(importPath, srcDir string) (*ReproducibilityReport, error) {
+	dir, err := ioutil.TempDir("", "gophertest-reproducible")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	results := []*Result(nil)
+	for _, v := range []string{"a", "b"} {
+		tmp := filepath.Join(dir, v, "tmp")
+		if err := os.MkdirAll(tmp, 0777); err != nil {
+			return nil, err
+		}
+		c := *b
+		c.TrimPath = true
+		c.WorkDir = filepath.Join(dir, v, "work")
+		c.CacheDir = ""
+		c.Env.Vars = append(append([]string(nil), b.Env.Vars...), "TMPDIR="+tmp, "GOTMPDIR="+tmp, "GOPHERTEST_BUILD="+v)
+		result, err := c.Build(importPath, srcDir, filepath.Join(dir, v, "out", "binary"))
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	report := &ReproducibilityReport{}
+	first, second := results[0].Plan.Packages, results[1].Plan.Packages
+	if len(first) != len(second) {
+		return nil, fmt.Errorf("%s: builds have %d and %d packages", importPath, len(first), len(second))
+	}
+	for i, p := range first {
+		d, err := diffFiles(p.Archive, second[i].Archive)
+		if err != nil {
+			return nil, err
+		}
+		if d != nil {
+			d.Package = p.ImportPath
+			report.Differences = append(report.Differences, *d)
+		}
+	}
+	if results[0].Binary != "" {
+		d, err := diffFiles(results[0].Binary, results[1].Binary)
+		if err != nil {
+			return nil, err
+		}
+		if d != nil {
+			report.Differences = append(report.Differences, *d)
+		}
+	}
+	return report, nil
+}
+
+// diffFiles returns where file1 and file2 first differ, nil if they are
+// identical.
+func diffFiles(file1, file2 string) (*Difference, error) {
+	data1, err := ioutil.ReadFile(file1)
+	if err != nil {
+		return nil, err
+	}
+	data2, err := ioutil.ReadFile(file2)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(data1, data2) {
+		return nil, nil
+	}
+	offset := 0
+	for offset < len(data1) && offset < len(data2) && data1[offset] == data2[offset] {
+		offset++
+	}
+	d := &Difference{Offset: int64(offset)}
+	if bytes.HasPrefix(data1, []byte("!<arch>\n")) {
+		d.Member = archiveMember(data1, offset)
+	} else {
+		d.Member = binarySection(file1, int64(offset))
+	}
+	return d, nil
+}
+
+// archiveMember returns the name of the member of the ar archive data
+// holding offset, including its header.
+func archiveMember(data []byte, offset int) string {
+	pos := len("!<arch>\n")
+	for pos+60 <= len(data) {
+		size, err := strconv.Atoi(strings.TrimSpace(string(data[pos+48 : pos+58])))
+		if err != nil || size < 0 {
+			return ""
+		}
+		end := pos + 60 + size + size%2
+		if offset < end {
+			return strings.TrimSuffix(strings.TrimSpace(string(data[pos:pos+16])), "/")
+		}
+		pos = end
+	}
+	return ""
+}
+
+// binarySection returns the name of the section of the ELF, Mach-O or PE
+// binary file holding offset.
+func binarySection(file string, offset int64) string {
+	if f, err := elf.Open(file); err == nil {
+		defer f.Close()
+		for _, s := range f.Sections {
+			if s.Type != elf.SHT_NOBITS && offset >= int64(s.Offset) && offset < int64(s.Offset+s.FileSize) {
+				return s.Name
+			}
+		}
+		return ""
+	}
+	if f, err := macho.Open(file); err == nil {
+		defer f.Close()
+		for _, s := range f.Sections {
+			if offset >= int64(s.Offset) && offset < int64(s.Offset)+int64(s.Size) {
+				return s.Seg + "," + s.Name
+			}
+		}
+		return ""
+	}
+	if f, err := pe.Open(file); err == nil {
+		defer f.Close()
+		for _, s := range f.Sections {
+			if offset >= int64(s.Offset) && offset < int64(s.Offset)+int64(s.Size) {
+				return s.Name
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,123 @@
+package build_test
+
+import (
+	"debug/elf"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/gophertest/build/buildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noisyTools compiles archives embedding their output path, like a compiler
+// not trimming the work directory.
+type noisyTools struct {
+	*buildtest.FakeTools
+}
+
+func (nt noisyTools) Compile(args build.CompileArgs) error {
+	if err := nt.FakeTools.Compile(args); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(args.OutputFile, arArchive("__.PKGDEF", args.OutputFile), 0666)
+}
+
+func arArchive(members ...string) []byte {
+	data := "!<arch>\n"
+	for i := 0; i < len(members); i += 2 {
+		content := members[i+1]
+		data += fmt.Sprintf("%-16s%-12s%-6s%-6s%-8s%-10d`\n", members[i], "0", "0", "0", "644", len(content))
+		data += content
+		if len(content)%2 == 1 {
+			data += "\n"
+		}
+	}
+	return []byte(data)
+}
+
+func TestVerifyReproducible(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	b := &build.Builder{Tools: ft, Context: ctx}
+	report, err := b.VerifyReproducible("example.com/hello", "")
+	require.NoError(t, err)
+	assert.True(t, report.Reproducible(), report.String())
+	assert.False(t, b.TrimPath)
+
+	compiles := ft.CompileCalls()
+	require.NotEmpty(t, compiles)
+	for _, v := range compiles {
+		assert.Contains(t, v.TrimPath, ";"+filepath.Join(ctx.GOPATH, "src")+"=>")
+	}
+	// Both builds run every tool, with the same build IDs.
+	assert.Len(t, ft.LinkCalls(), 2)
+	assert.Equal(t, ft.LinkCalls()[0].BuildID, ft.LinkCalls()[1].BuildID)
+	assert.NotEqual(t, ft.LinkCalls()[0].WorkingDirectory, ft.LinkCalls()[1].WorkingDirectory)
+}
+
+func TestVerifyReproducibleDifferences(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	b := &build.Builder{Tools: noisyTools{ft}, Context: ctx}
+	report, err := b.VerifyReproducible("example.com/hello", "")
+	require.NoError(t, err)
+	assert.False(t, report.Reproducible())
+	require.NotEmpty(t, report.Differences)
+	d := report.Differences[0]
+	assert.NotEmpty(t, d.Package)
+	assert.Equal(t, "__.PKGDEF", d.Member)
+	assert.Contains(t, report.String(), "archive of "+d.Package+" differs in __.PKGDEF at offset")
+}
+
+func TestDiffFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diff")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name string, data []byte) string {
+		file := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(file, data, 0666))
+		return file
+	}
+
+	a := write("a.a", arArchive("__.PKGDEF", "export", "_go_.o", "object a"))
+	b := write("b.a", arArchive("__.PKGDEF", "export", "_go_.o", "object b"))
+	d, err := build.DiffFiles(a, a)
+	require.NoError(t, err)
+	assert.Nil(t, d)
+	d, err = build.DiffFiles(a, b)
+	require.NoError(t, err)
+	require.NotNil(t, d)
+	assert.Equal(t, "_go_.o", d.Member)
+	assert.Equal(t, int64(strings.Index(string(arArchive("__.PKGDEF", "export", "_go_.o", "object a")), "object a")+7), d.Offset)
+
+	exe, err := ioutil.ReadFile(os.Args[0])
+	require.NoError(t, err)
+	f, err := elf.Open(os.Args[0])
+	if err != nil {
+		t.Skip("test binary is not ELF")
+	}
+	text := f.Section(".text")
+	f.Close()
+	require.NotNil(t, text)
+	first := write("first", exe)
+	offset := text.Offset + text.FileSize/2
+	exe[offset]++
+	second := write("second", exe)
+	d, err = build.DiffFiles(first, second)
+	require.NoError(t, err)
+	require.NotNil(t, d)
+	assert.Equal(t, build.Difference{Member: ".text", Offset: int64(offset)}, *d)
+	assert.Equal(t, fmt.Sprintf("binary differs in .text at offset %d", offset), d.String())
+}
@@ -0,0 +1,92 @@
+package build
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ResponseFileThreshold is the total length of the arguments, in bytes,
+	// above which they are passed to a tool through an @file response file
+	// instead of on the command line. A value <= 0 disables response files.
+	ResponseFileThreshold = 30 << 10
+)
+
+// responseFileArgs returns args unchanged when they fit on the command line.
+// Otherwise the arguments are written to a temporary response file in dir and
+// a single "@file" argument is returned in their place. The returned cleanup
+// func removes the response file and must always be called.
+func responseFileArgs(dir string, args []string) ([]string, func(), error) {
+	cleanup := func() {}
+	if !useResponseFile(args) {
+		return args, cleanup, nil
+	}
+	f, err := ioutil.TempFile(dir, "args")
+	if err != nil {
+		return nil, cleanup, err
+	}
+	cleanup = func() { os.Remove(f.Name()) }
+	buf := &bytes.Buffer{}
+	for _, v := range args {
+		buf.WriteString(encodeArg(v))
+		buf.WriteByte('\n')
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		cleanup()
+		return nil, func() {}, err
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return nil, func() {}, err
+	}
+	name := f.Name()
+	if dir != "" {
+		// The tool runs in dir, so refer to the file relative to it.
+		name = filepath.Base(name)
+	}
+	return []string{"@" + name}, cleanup, nil
+}
+
+func useResponseFile(args []string) bool {
+	if ResponseFileThreshold <= 0 {
+		return false
+	}
+	n := 0
+	for _, v := range args {
+		n += len(v) + 1
+	}
+	return n > ResponseFileThreshold
+}
+
+// encodeArg encodes an argument using the GCC-compatible quoting understood
+// by the go tools when reading response files.
+func encodeArg(arg string) string {
+	if arg == "" {
+		return `""`
+	}
+	if !strings.ContainsAny(arg, " \t\n\r'\"\\$`") {
+		return arg
+	}
+	b := &strings.Builder{}
+	b.WriteByte('"')
+	for _, r := range arg {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '"':
+			b.WriteString(`\"`)
+		case '$':
+			b.WriteString(`\$`)
+		case '`':
+			b.WriteString("\\`")
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
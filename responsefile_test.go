@@ -0,0 +1,143 @@
+package build_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseFile(t *testing.T) {
+	if os.Getenv("TEST_SUBPROCESS") == "1" {
+		args := []string(nil)
+		for i, v := range os.Args {
+			if v == "--" {
+				args = os.Args[i+1:]
+			}
+		}
+		for _, v := range args {
+			if !strings.HasPrefix(v, "@") {
+				fmt.Fprintf(os.Stdout, "%s\n", v)
+				continue
+			}
+			data, err := ioutil.ReadFile(v[1:])
+			if err != nil {
+				fmt.Fprint(os.Stderr, err)
+				os.Exit(1)
+			}
+			fmt.Fprint(os.Stdout, string(data))
+		}
+		os.Exit(0)
+	}
+
+	dir, err := ioutil.TempDir("", "responsefile")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	threshold := build.ResponseFileThreshold
+	defer func() { build.ResponseFileThreshold = threshold }()
+
+	testCases := []struct {
+		Name      string
+		Threshold int
+		Run       func(tools *build.CmdTools, stdout *bytes.Buffer) error
+		Expected  string
+	}{
+		{
+			"assemble",
+			1,
+			func(tools *build.CmdTools, stdout *bytes.Buffer) error {
+				tools.Assembler = os.Args[0]
+				tools.AssemblerArgs = []string{"-test.run=TestResponseFile", "--"}
+				return tools.Assemble(build.AssembleArgs{
+					WorkingDirectory: dir,
+					Stdout:           stdout,
+					OutputFile:       "of",
+					Files:            []string{"a.s", "b.s"},
+				})
+			},
+			"-o\nof\na.s\nb.s\n",
+		},
+		{
+			"compile",
+			1,
+			func(tools *build.CmdTools, stdout *bytes.Buffer) error {
+				tools.Compiler = os.Args[0]
+				tools.CompilerArgs = []string{"-test.run=TestResponseFile", "--"}
+				return tools.Compile(build.CompileArgs{
+					WorkingDirectory:  dir,
+					Stdout:            stdout,
+					PackageImportPath: "p",
+					Files:             []string{"a b.go", `c"d\e.go`, "$f`.go", ""},
+				})
+			},
+			"-p\np\n\"a b.go\"\n\"c\\\"d\\\\e.go\"\n\"\\$f\\`.go\"\n\"\"\n",
+		},
+		{
+			"link",
+			1,
+			func(tools *build.CmdTools, stdout *bytes.Buffer) error {
+				tools.Linker = os.Args[0]
+				tools.LinkerArgs = []string{"-test.run=TestResponseFile", "--"}
+				return tools.Link(build.LinkArgs{
+					WorkingDirectory: dir,
+					Stdout:           stdout,
+					OutputFile:       "of",
+					Files:            []string{"a.a"},
+				})
+			},
+			"-o\nof\na.a\n",
+		},
+		{
+			"pack",
+			1,
+			func(tools *build.CmdTools, stdout *bytes.Buffer) error {
+				tools.Packer = os.Args[0]
+				tools.PackerArgs = []string{"-test.run=TestResponseFile", "--"}
+				return tools.Pack(build.PackArgs{
+					WorkingDirectory: dir,
+					Stdout:           stdout,
+					Op:               build.Append,
+					ObjectFile:       "obj",
+					Names:            []string{"a.o", "b.o"},
+				})
+			},
+			"r\nobj\na.o\nb.o\n",
+		},
+		{
+			"below threshold",
+			1 << 10,
+			func(tools *build.CmdTools, stdout *bytes.Buffer) error {
+				tools.Compiler = os.Args[0]
+				tools.CompilerArgs = []string{"-test.run=TestResponseFile", "--"}
+				return tools.Compile(build.CompileArgs{
+					WorkingDirectory: dir,
+					Stdout:           stdout,
+					Files:            []string{"a b.go"},
+				})
+			},
+			"a b.go\n",
+		},
+	}
+
+	os.Setenv("TEST_SUBPROCESS", "1")
+	defer os.Setenv("TEST_SUBPROCESS", "")
+	for _, tc := range testCases {
+		build.ResponseFileThreshold = tc.Threshold
+		stdout := &bytes.Buffer{}
+		err := tc.Run(build.NewCmdTools(), stdout)
+		assert.NoErrorf(t, err, "failed with case %s", tc.Name)
+		assert.Equalf(t, tc.Expected, stdout.String(), "failed with case %s", tc.Name)
+
+		leftover, err := filepath.Glob(filepath.Join(dir, "args*"))
+		assert.NoError(t, err)
+		assert.Emptyf(t, leftover, "response file not removed in case %s", tc.Name)
+	}
+}
@@ -0,0 +1,158 @@
+package build
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Build IDs have the format of cmd/go: the action ID, a hash of the inputs of
+// a build, followed by the content ID, a hash of its output, separated by
+// "/". The compiler records "actionID/actionID" in the archive, the content
+// ID is stamped once the archive is complete.
+
+// ActionID returns the action ID of buildID, its first element.
+func ActionID(buildID string) string {
+	if i := strings.Index(buildID, "/"); i >= 0 {
+		return buildID[:i]
+	}
+	return buildID
+}
+
+// ContentID returns the content ID of buildID, its last element.
+func ContentID(buildID string) string {
+	return buildID[strings.LastIndex(buildID, "/")+1:]
+}
+
+// StalePackage is a package that needs to be compiled.
+type StalePackage struct {
+	*Package
+	// Reason the package is stale, like "not built" or "dependency fmt is
+	// stale".
+	Reason string
+}
+
+// Stale returns the packages of plan that BuildPlan would compile, in
+// dependency order. A package is up to date if its archive, in CacheDir or
+// left in WorkDir by a previous build, has the build ID of its sources,
+// flags and dependencies. The ID, Fingerprint, Archive and BuildID of the up
+// to date packages are set.
+func (b *Builder) Stale(plan *Plan) ([]StalePackage, error) {
+	if b.WorkDir == "" && b.CacheDir == "" {
+		stale := []StalePackage(nil)
+		for _, p := range plan.Packages {
+			stale = append(stale, StalePackage{p, "not built"})
+		}
+		return stale, nil
+	}
+	a, err := b.newAction(plan, b.WorkDir, ioutil.Discard)
+	if err != nil {
+		return nil, err
+	}
+	stale := []StalePackage(nil)
+	isStale := map[*Package]bool{}
+	for _, p := range plan.Packages {
+		reason := ""
+		for _, v := range p.Deps {
+			if isStale[v] {
+				reason = fmt.Sprintf("dependency %s is stale", v.ImportPath)
+				break
+			}
+		}
+		if reason == "" {
+			if reason, err = a.upToDate(p); err != nil {
+				return nil, fmt.Errorf("%s: %v", p.ImportPath, err)
+			}
+		}
+		if reason != "" {
+			isStale[p] = true
+			stale = append(stale, StalePackage{p, reason})
+		}
+	}
+	return stale, nil
+}
+
+// upToDate sets the IDs and archive of p, and looks for its archive. It
+// returns why p is stale, nothing if the archive is up to date.
+func (a *action) upToDate(p *Package) (string, error) {
+	fingerprint, id, err := a.packageID(p)
+	if err != nil {
+		return "", err
+	}
+	p.Fingerprint = fingerprint
+	p.ID = id
+	archive := filepath.Join(a.workDir, filepath.FromSlash(p.ImportPath), "_pkg_.a")
+	if a.b.CacheDir != "" {
+		archive = filepath.Join(a.b.CacheDir, id+".a")
+	}
+	data, err := ioutil.ReadFile(archive)
+	if os.IsNotExist(err) {
+		return "not built", nil
+	} else if err != nil {
+		return "", err
+	}
+	buildID := ""
+	if a.b.CacheDir != "" {
+		// Cached archives are named by their action ID.
+		buildID = id + "/" + contentID(data, findBuildID(data, id))
+	} else {
+		buildID, err = a.buildID(archive)
+		if err != nil {
+			return fmt.Sprintf("build ID unreadable: %v", err), nil
+		}
+		if ActionID(buildID) != id {
+			return fmt.Sprintf("build ID %s, want action ID %s", buildID, id), nil
+		}
+		if ContentID(buildID) != contentID(data, buildID) {
+			return "archive modified since built", nil
+		}
+	}
+	p.Archive = archive
+	p.BuildID = buildID
+	if len(p.CgoFiles) > 0 {
+		header := strings.TrimSuffix(archive, ".a") + ".h"
+		if _, err := os.Stat(header); err == nil {
+			p.Header = header
+		}
+	}
+	return "", nil
+}
+
+// findBuildID returns the build ID with the action ID id recorded in data,
+// empty if none.
+func findBuildID(data []byte, id string) string {
+	i := bytes.Index(data, []byte(id+"/"))
+	if i < 0 || i+2*len(id)+1 > len(data) {
+		return ""
+	}
+	return string(data[i : i+2*len(id)+1])
+}
+
+// contentID hashes data with every occurrence of buildID zeroed, like cmd/go.
+func contentID(data []byte, buildID string) string {
+	if buildID != "" {
+		data = bytes.Replace(data, []byte(buildID), make([]byte, len(buildID)), -1)
+	}
+	sum := sha256.Sum256(data)
+	return hashToString(sum[:])
+}
+
+// stampBuildID replaces the build ID "id/id" recorded by the compiler in
+// file with "id/contentID" and returns it.
+func stampBuildID(file, id string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	old := id + "/" + id
+	buildID := id + "/" + contentID(data, old)
+	if !bytes.Contains(data, []byte(old)) {
+		return buildID, nil
+	}
+	data = bytes.Replace(data, []byte(old), []byte(buildID), -1)
+	return buildID, ioutil.WriteFile(file, data, 0666)
+}
@@ -0,0 +1,153 @@
+package build_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/gophertest/build/buildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildIDElements(t *testing.T) {
+	testCases := []struct {
+		BuildID string
+		Action  string
+		Content string
+	}{
+		{"a/c", "a", "c"},
+		{"link/main/maincontent/c", "link", "c"},
+		{"a", "a", "a"},
+		{"", "", ""},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.Action, build.ActionID(tc.BuildID), tc.BuildID)
+		assert.Equal(t, tc.Content, build.ContentID(tc.BuildID), tc.BuildID)
+	}
+}
+
+func TestStampBuildID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "buildid")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	id := "AAAAAAAAAAAAAAAAAAAA"
+	file := filepath.Join(dir, "pkg.a")
+	require.NoError(t, ioutil.WriteFile(file, []byte("header "+id+"/"+id+" object "+id+"/"+id+"\n"), 0666))
+	buildID, err := build.StampBuildID(file, id)
+	require.NoError(t, err)
+	assert.Equal(t, id, build.ActionID(buildID))
+	assert.Len(t, build.ContentID(buildID), len(id))
+	assert.NotEqual(t, id, build.ContentID(buildID))
+
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "header "+buildID+" object "+buildID+"\n", string(data))
+	// The content ID does not depend on the build ID recorded in the file.
+	assert.Equal(t, build.ContentID(buildID), build.ContentIDOf(data, buildID))
+}
+
+func TestBuilderStale(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "stale")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	b := &build.Builder{Tools: ft, Context: ctx, WorkDir: filepath.Join(dir, "work")}
+	plan, err := b.Load("example.com/hello", "")
+	require.NoError(t, err)
+	stale, err := b.Stale(plan)
+	require.NoError(t, err)
+	require.Len(t, stale, len(plan.Packages))
+	assert.Equal(t, "not built", stale[0].Reason)
+	ft.AssertNotCalled(t, buildtest.Compile)
+
+	binary := filepath.Join(dir, "hello")
+	_, err = b.BuildPlan(plan, binary)
+	require.NoError(t, err)
+	buildIDs := []buildtest.Response(nil)
+	for _, p := range plan.Packages {
+		assert.Equal(t, p.ID, build.ActionID(p.BuildID), p.ImportPath)
+		buildIDs = append(buildIDs, buildtest.Response{Stdout: p.BuildID + "\n"})
+	}
+
+	// Up to date archives are read back with the BuildIDer.
+	plan, err = b.Load("example.com/hello", "")
+	require.NoError(t, err)
+	ft.Reset()
+	ft.Respond(buildtest.BuildID, buildIDs...)
+	stale, err = b.Stale(plan)
+	require.NoError(t, err)
+	assert.Empty(t, stale)
+	ft.AssertNumberOfCalls(t, buildtest.BuildID, len(plan.Packages))
+
+	// A modified archive makes the package and its dependents stale.
+	var greet *build.Package
+	for _, p := range plan.Packages {
+		if p.ImportPath == "example.com/greet" {
+			greet = p
+		}
+	}
+	require.NotNil(t, greet)
+	require.NoError(t, ioutil.WriteFile(greet.Archive, []byte("modified\n"), 0666))
+	plan, err = b.Load("example.com/hello", "")
+	require.NoError(t, err)
+	ft.Reset()
+	ft.Respond(buildtest.BuildID, buildIDs...)
+	stale, err = b.Stale(plan)
+	require.NoError(t, err)
+	require.Len(t, stale, 2)
+	assert.Equal(t, "example.com/greet", stale[0].ImportPath)
+	assert.Equal(t, "archive modified since built", stale[0].Reason)
+	assert.Equal(t, "example.com/hello", stale[1].ImportPath)
+	assert.Equal(t, "dependency example.com/greet is stale", stale[1].Reason)
+
+	// The rebuilt archive has the same content ID as before, so that its
+	// dependents are still up to date.
+	ft.Reset()
+	ft.Respond(buildtest.BuildID, buildIDs...)
+	_, err = b.BuildPlan(plan, binary)
+	require.NoError(t, err)
+	compiled := []string(nil)
+	for _, v := range ft.CompileCalls() {
+		compiled = append(compiled, v.PackageImportPath)
+	}
+	assert.Equal(t, []string{"example.com/greet"}, compiled)
+	for _, p := range plan.Packages {
+		assert.Equal(t, p.ImportPath != "example.com/greet", p.Cached, p.ImportPath)
+	}
+	data, err := ioutil.ReadFile(greet.Archive)
+	require.NoError(t, err)
+	assert.False(t, bytes.Equal([]byte("modified\n"), data))
+}
+
+func TestBuilderStaleMismatch(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "stale")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	b := &build.Builder{Tools: ft, Context: ctx, WorkDir: filepath.Join(dir, "work")}
+	plan, err := b.Load("example.com/greet", "")
+	require.NoError(t, err)
+	_, err = b.BuildPlan(plan, "")
+	require.NoError(t, err)
+
+	ft.Reset()
+	ft.Respond(buildtest.BuildID, buildtest.Response{Stdout: "other/id\n"})
+	stale, err := b.Stale(plan)
+	require.NoError(t, err)
+	require.NotEmpty(t, stale)
+	assert.Equal(t, "build ID other/id, want action ID "+plan.Packages[0].ID, stale[0].Reason)
+	assert.Len(t, stale, len(plan.Packages))
+}
@@ -0,0 +1,167 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"go/types"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Stamp sets string variables of a binary at link time, with "-X".
+type Stamp struct {
+	// VersionVar, CommitVar and DateVar are the "importpath.name" of the
+	// variables set to Version, Commit and Date. The variables of the main
+	// package are named "main.name", or by the import path of the command.
+	VersionVar string
+	CommitVar  string
+	DateVar    string
+	// Version of the binary.
+	Version string
+	// Commit the binary is built from.
+	Commit string
+	// Date of the build, set in RFC 3339 format if not zero.
+	Date time.Time
+	// Vars are other variables to set, keyed by "importpath.name".
+	Vars map[string]string
+	// VCS fills an empty Version and Commit from the git checkout holding
+	// the main package.
+	VCS bool
+}
+
+// Defines returns the "importpath.name=value" definitions of the stamp,
+// sorted by variable.
+func (s Stamp) Defines() []string {
+	vars := map[string]string{}
+	for k, v := range s.Vars {
+		vars[k] = v
+	}
+	if s.VersionVar != "" && s.Version != "" {
+		vars[s.VersionVar] = s.Version
+	}
+	if s.CommitVar != "" && s.Commit != "" {
+		vars[s.CommitVar] = s.Commit
+	}
+	if s.DateVar != "" && !s.Date.IsZero() {
+		vars[s.DateVar] = s.Date.UTC().Format(time.RFC3339)
+	}
+	keys := []string(nil)
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	defines := []string(nil)
+	for _, k := range keys {
+		defines = append(defines, k+"="+vars[k])
+	}
+	return defines
+}
+
+// LDFlags returns the definitions of the stamp as a "-ldflags" value for the
+// go command, quoting the definitions containing spaces or quotes as
+// cmd/internal/quoted does: with ' if they contain ", with " otherwise. A
+// definition containing both cannot be quoted.
+func (s Stamp) LDFlags() (string, error) {
+	flags := []string(nil)
+	for _, v := range s.Defines() {
+		if strings.ContainsAny(v, " \t\n\r'\"") {
+			switch {
+			case strings.Contains(v, `"`) && strings.Contains(v, "'"):
+				return "", fmt.Errorf("-X %s: value contains both ' and \"", v)
+			case strings.Contains(v, `"`):
+				v = "'" + v + "'"
+			default:
+				v = `"` + v + `"`
+			}
+		}
+		flags = append(flags, "-X", v)
+	}
+	return strings.Join(flags, " "), nil
+}
+
+// linkDefines returns defines with the variables of the command main, named
+// by its import path, renamed to the path it is compiled with, as the linker
+// only knows them by it.
+func linkDefines(defines []string, main *Package, compilePath string) []string {
+	if main.ImportPath == compilePath {
+		return defines
+	}
+	prefix := main.ImportPath + "."
+	renamed := []string(nil)
+	for _, v := range defines {
+		if symbol := v[:strings.Index(v, "=")]; strings.HasPrefix(symbol, prefix) && !strings.ContainsAny(symbol[len(prefix):], "./") {
+			v = compilePath + v[len(main.ImportPath):]
+		}
+		renamed = append(renamed, v)
+	}
+	return renamed
+}
+
+// Check checks that every variable of the stamp is a string variable of a
+// package of plan, using the export data of the built packages.
+func (s Stamp) Check(plan *Plan) error {
+	cfg := importConfig(nil, plan.Packages)
+	imp := cfg.Importer(token.NewFileSet())
+	for _, v := range s.Defines() {
+		symbol := v[:strings.Index(v, "=")]
+		i := strings.LastIndex(symbol, ".")
+		if i <= 0 || i < strings.LastIndex(symbol, "/") || i == len(symbol)-1 {
+			return fmt.Errorf("-X %s: invalid variable, must be importpath.name", symbol)
+		}
+		path, name := symbol[:i], symbol[i+1:]
+		if path == "main" && plan.Main.Name == "main" {
+			path = plan.Main.ImportPath
+		}
+		if _, ok := cfg.PackageFile[path]; !ok {
+			return fmt.Errorf("-X %s: package %s is not linked", symbol, path)
+		}
+		pkg, err := imp.Import(path)
+		if err != nil {
+			return fmt.Errorf("-X %s: %v", symbol, err)
+		}
+		obj := pkg.Scope().Lookup(name)
+		if obj == nil {
+			return fmt.Errorf("-X %s: no variable %s in package %s", symbol, name, path)
+		}
+		if _, ok := obj.(*types.Var); !ok || !types.Identical(obj.Type(), types.Typ[types.String]) {
+			return fmt.Errorf("-X %s: %s is not a string variable", symbol, types.ObjectString(obj, types.RelativeTo(pkg)))
+		}
+	}
+	return nil
+}
+
+// withVCS returns the stamp with an empty Version and Commit filled from the
+// git checkout holding dir.
+func (s Stamp) withVCS(dir string) (Stamp, error) {
+	if s.Commit == "" {
+		commit, err := git(dir, "rev-parse", "HEAD")
+		if err != nil {
+			return s, err
+		}
+		s.Commit = commit
+	}
+	if s.Version == "" {
+		version, err := git(dir, "describe", "--tags", "--always", "--dirty")
+		if err != nil {
+			return s, err
+		}
+		s.Version = version
+	}
+	return s, nil
+}
+
+func git(dir string, args ...string) (string, error) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
@@ -0,0 +1,180 @@
+package build_test
+
+import (
+	gb "go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gophertest/build"
+	"github.com/gophertest/build/buildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStampDefines(t *testing.T) {
+	s := build.Stamp{
+		VersionVar: "main.version",
+		Version:    "v1.0.0",
+		CommitVar:  "main.commit",
+		DateVar:    "example.com/info.Date",
+		Date:       time.Date(2020, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600)),
+		Vars: map[string]string{
+			"main.name":  "hello world",
+			"main.quote": "it's",
+		},
+	}
+	assert.Equal(t, []string{
+		"example.com/info.Date=2020-01-02T02:04:05Z",
+		"main.name=hello world",
+		"main.quote=it's",
+		"main.version=v1.0.0",
+	}, s.Defines())
+	ldflags, err := s.LDFlags()
+	require.NoError(t, err)
+	assert.Equal(t, `-X example.com/info.Date=2020-01-02T02:04:05Z -X "main.name=hello world" -X "main.quote=it's" -X main.version=v1.0.0`, ldflags)
+
+	s.Vars["main.name"] = `say "hello"`
+	ldflags, err = s.LDFlags()
+	require.NoError(t, err)
+	assert.Contains(t, ldflags, `-X 'main.name=say "hello"'`)
+
+	s.Vars["main.name"] = `it's "hello"`
+	_, err = s.LDFlags()
+	assert.EqualError(t, err, `-X main.name=it's "hello": value contains both ' and "`)
+}
+
+func TestStampCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stamp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	src, err := filepath.Abs("testdata/src/example.com/stamped")
+	require.NoError(t, err)
+
+	archive := filepath.Join(dir, "stamped.a")
+	err = build.DefaultTools.Compile(build.CompileArgs{
+		Context:           gb.Default,
+		WorkingDirectory:  src,
+		Stderr:            os.Stderr,
+		Files:             []string{"stamped.go"},
+		OutputFile:        archive,
+		PackageImportPath: "main",
+		Pack:              true,
+		Complete:          true,
+	})
+	require.NoError(t, err)
+	p := &build.Package{
+		Package: &gb.Package{ImportPath: "example.com/stamped", Name: "main"},
+		Archive: archive,
+	}
+	plan := &build.Plan{Main: p, Packages: []*build.Package{p}}
+
+	testCases := []struct {
+		Symbol string
+		Error  string
+	}{
+		{"main.Version", ""},
+		{"example.com/stamped.Commit", ""},
+		{"main.Missing", "-X main.Missing: no variable Missing in package example.com/stamped"},
+		{"main.Count", "-X main.Count: var Count int is not a string variable"},
+		{"main.Name", `-X main.Name: const Name untyped string is not a string variable`},
+		{"example.com/other.Version", "-X example.com/other.Version: package example.com/other is not linked"},
+		{"Version", "-X Version: invalid variable, must be importpath.name"},
+		{"example.com/stamped", "-X example.com/stamped: invalid variable, must be importpath.name"},
+	}
+	for _, tc := range testCases {
+		err := build.Stamp{Vars: map[string]string{tc.Symbol: "v"}}.Check(plan)
+		if tc.Error == "" {
+			assert.NoErrorf(t, err, "failed with %s", tc.Symbol)
+		} else {
+			assert.EqualErrorf(t, err, tc.Error, "failed with %s", tc.Symbol)
+		}
+	}
+}
+
+func TestStampVCS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stamp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=a", "GIT_AUTHOR_EMAIL=a@example.com",
+			"GIT_COMMITTER_NAME=a", "GIT_COMMITTER_EMAIL=a@example.com")
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+		return string(out)
+	}
+	git("init", "-q")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0666))
+	git("add", "main.go")
+	git("commit", "-q", "-m", "initial")
+	git("tag", "v1.2.3")
+	commit := git("rev-parse", "HEAD")
+
+	s, err := build.Stamp{VCS: true}.WithVCS(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "v1.2.3", s.Version)
+	assert.Equal(t, commit[:len(commit)-1], s.Commit)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package main // changed\n"), 0666))
+	s, err = build.Stamp{Version: "v2", VCS: true}.WithVCS(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "v2", s.Version)
+	s, err = build.Stamp{VCS: true}.WithVCS(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "v1.2.3-dirty", s.Version)
+
+	_, err = build.Stamp{VCS: true}.WithVCS(os.TempDir())
+	assert.Error(t, err)
+}
+
+func TestBuilderStamp(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	b := &build.Builder{
+		Tools:   ft,
+		Context: ctx,
+		Stamp:   &build.Stamp{Vars: map[string]string{"example.com/missing.Version": "v1"}},
+	}
+	_, err := b.Build("example.com/hello", "", "hello")
+	assert.EqualError(t, err, "-X example.com/missing.Version: package example.com/missing is not linked")
+	ft.AssertNotCalled(t, buildtest.Link)
+}
+
+func TestBuilderStampDefaultTools(t *testing.T) {
+	ctx, restore := realContext(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "stamp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The variables of the command are set by its import path too.
+	stderr := &strings.Builder{}
+	b := &build.Builder{
+		Tools:   build.DefaultTools,
+		Context: ctx,
+		Stderr:  stderr,
+		Stamp: &build.Stamp{
+			VersionVar: "example.com/stamped.Version",
+			Version:    "v1.0.0",
+			CommitVar:  "main.Commit",
+			Commit:     "abc",
+		},
+	}
+	binary := filepath.Join(dir, "stamped")
+	_, err = b.Build("example.com/stamped", "", binary)
+	require.NoError(t, err, stderr.String())
+	out, err := exec.Command(binary).CombinedOutput()
+	require.NoError(t, err, string(out))
+	assert.Equal(t, "v1.0.0 abc\n", string(out))
+}
@@ -0,0 +1,133 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	gb "go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// StdResult is the standard library built by BuildStd.
+type StdResult struct {
+	// Packages of the standard library in dependency order, with their
+	// archives in the cache directory.
+	Packages []*Package
+	// ImportConfig maps the import paths of Packages to their archives, to
+	// compile and link against the standard library.
+	ImportConfig *ImportConfig
+	// Diagnostics written by the tools.
+	Diagnostics string
+}
+
+// LoadStd loads the packages of the standard library for Context, all those
+// of GOROOT/src but the commands, in dependency order. Packages without files
+// for Context are left out, as is runtime/cgo when cgo is disabled. Given
+// importPaths, it only loads those packages and their dependencies.
+func (b *Builder) LoadStd(importPaths ...string) ([]*Package, error) {
+	if err := b.Instrument.Validate(b.Context); err != nil {
+		return nil, err
+	}
+	l := &loader{ctx: b.loadContext(), packages: map[string]*Package{}, loading: map[string]bool{}}
+	if len(importPaths) > 0 {
+		for _, v := range importPaths {
+			pkg, err := l.ctx.Import(v, "", 0)
+			if err != nil {
+				return nil, err
+			}
+			if !pkg.Goroot {
+				return nil, fmt.Errorf("%s is not in the standard library", v)
+			}
+			if _, err := l.loadPackage(pkg); err != nil {
+				return nil, err
+			}
+		}
+		return l.order, nil
+	}
+	root := filepath.Join(b.Context.GOROOT, "src")
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() || path == root {
+			return nil
+		}
+		name := info.Name()
+		importPath := filepath.ToSlash(path[len(root)+1:])
+		if name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || importPath == "cmd" {
+			return filepath.SkipDir
+		}
+		// builtin only documents the predeclared identifiers, and runtime/cgo
+		// only builds with cgo.
+		if importPath == "builtin" || importPath == "runtime/cgo" && !l.ctx.CgoEnabled {
+			return nil
+		}
+		pkg, err := l.ctx.Import(importPath, "", 0)
+		if _, ok := err.(*gb.NoGoError); ok {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, err = l.loadPackage(pkg)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l.order, nil
+}
+
+// BuildStd compiles the standard library for Context into CacheDir, which
+// must be set, or only the packages of importPaths and their dependencies.
+// Later builds with the same CacheDir and settings reuse the archives,
+// packages already in the cache are not compiled again.
+func (b *Builder) BuildStd(importPaths ...string) (*StdResult, error) {
+	if b.CacheDir == "" {
+		return nil, fmt.Errorf("building the standard library requires a cache directory")
+	}
+	if err := b.Arch.Validate(b.Context.GOARCH); err != nil {
+		return nil, err
+	}
+	packages, err := b.LoadStd(importPaths...)
+	if err != nil {
+		return nil, err
+	}
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("no standard library packages in %s", b.Context.GOROOT)
+	}
+	workDir := b.WorkDir
+	if workDir == "" {
+		dir, err := ioutil.TempDir("", "gophertest-build")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		workDir = dir
+	}
+	result := &StdResult{Packages: packages}
+	diag := &bytes.Buffer{}
+	defer func() { result.Diagnostics = diag.String() }()
+	stderr := io.Writer(diag)
+	if b.Stderr != nil {
+		stderr = io.MultiWriter(diag, b.Stderr)
+	}
+	plan := &Plan{Main: packages[len(packages)-1], Packages: packages}
+	a, err := b.newAction(plan, workDir, stderr)
+	if err != nil {
+		return result, err
+	}
+	if b.Events != nil {
+		a.events = NewEventWriter(b.Events)
+	}
+	for _, p := range packages {
+		if err := a.build(p, false); err != nil {
+			return result, err
+		}
+	}
+	result.ImportConfig = importConfig(nil, packages)
+	return result, nil
+}
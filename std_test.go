@@ -0,0 +1,165 @@
+package build_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/gophertest/build/buildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilderLoadStd(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+
+	b := &build.Builder{Context: ctx}
+	packages, err := b.LoadStd()
+	require.NoError(t, err)
+	index := map[string]int{}
+	for i, p := range packages {
+		assert.True(t, p.Goroot, p.ImportPath)
+		assert.NotEqual(t, "main", p.Name, p.ImportPath)
+		assert.False(t, strings.HasPrefix(p.ImportPath, "cmd/"), p.ImportPath)
+		assert.NotContains(t, strings.Split(p.ImportPath, "/"), "testdata")
+		for _, dep := range p.Deps {
+			j, ok := index[dep.ImportPath]
+			if assert.Truef(t, ok, "%s before %s", dep.ImportPath, p.ImportPath) {
+				assert.True(t, j < i)
+			}
+		}
+		index[p.ImportPath] = i
+	}
+	for _, v := range []string{"runtime", "fmt", "net/http", "vendor/golang.org/x/net/http2/hpack"} {
+		assert.Contains(t, index, v)
+	}
+	assert.NotContains(t, index, "builtin")
+	assert.NotContains(t, index, "runtime/cgo")
+	// Files for other systems are left out.
+	assert.NotContains(t, index, "internal/syscall/windows")
+
+	packages, err = b.LoadStd("fmt")
+	require.NoError(t, err)
+	index = map[string]int{}
+	for i, p := range packages {
+		index[p.ImportPath] = i
+	}
+	assert.Equal(t, len(packages)-1, index["fmt"])
+	assert.Contains(t, index, "runtime")
+	assert.Contains(t, index, "sync/atomic")
+	assert.NotContains(t, index, "net/http")
+
+	_, err = b.LoadStd("example.com/greet")
+	require.Error(t, err)
+	assert.Equal(t, "example.com/greet is not in the standard library", err.Error())
+}
+
+func TestBuilderBuildStd(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "std")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	b := &build.Builder{Tools: ft, Context: ctx}
+	_, err = b.BuildStd()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "requires a cache directory")
+	ft.AssertNotCalled(t, buildtest.Compile)
+
+	b.CacheDir = filepath.Join(dir, "cache")
+	result, err := b.BuildStd()
+	require.NoError(t, err)
+	require.NotEmpty(t, result.Packages)
+	assert.Len(t, ft.CompileCalls(), len(result.Packages))
+	ft.AssertCompiled(t, "runtime")
+	ft.AssertCompiled(t, "net/http")
+	for _, v := range ft.CompileCalls() {
+		assert.True(t, v.CompilingStandardLibrary, v.PackageImportPath)
+		switch v.PackageImportPath {
+		case "runtime", "internal/cpu":
+			assert.True(t, v.CompilingRuntimeLibrary, v.PackageImportPath)
+			assert.False(t, v.Complete, v.PackageImportPath)
+			assert.NotEmpty(t, v.SymABIsFile, v.PackageImportPath)
+		case "os":
+			assert.False(t, v.CompilingRuntimeLibrary || v.Complete, v.PackageImportPath)
+		case "fmt":
+			assert.False(t, v.CompilingRuntimeLibrary, v.PackageImportPath)
+			assert.True(t, v.Complete, v.PackageImportPath)
+		}
+	}
+	assert.Empty(t, ft.LinkCalls())
+	require.NotEmpty(t, ft.AssembleCalls())
+	for _, v := range ft.AssembleCalls() {
+		assert.True(t, v.Std, v.PackageImportPath)
+	}
+	for _, p := range result.Packages {
+		file := result.ImportConfig.PackageFile[p.ImportPath]
+		assert.Equal(t, b.CacheDir, filepath.Dir(file), p.ImportPath)
+		assert.FileExists(t, file)
+	}
+
+	// Builds with the same cache reuse the standard library.
+	ft.Reset()
+	_, err = b.Build("example.com/hello", "", filepath.Join(dir, "hello"))
+	require.NoError(t, err)
+	for _, v := range ft.CompileCalls() {
+		assert.NotContains(t, result.ImportConfig.PackageFile, v.PackageImportPath)
+	}
+	assert.NotEmpty(t, ft.CompileCalls())
+	ft.Reset()
+	_, err = b.BuildStd()
+	require.NoError(t, err)
+	ft.AssertNotCalled(t, buildtest.Compile)
+}
+
+func TestBuilderBuildStdDefaultTools(t *testing.T) {
+	ctx, restore := realContext(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "std")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	stderr := &strings.Builder{}
+	b := &build.Builder{Tools: build.DefaultTools, Context: ctx, Stderr: stderr, CacheDir: filepath.Join(dir, "cache")}
+	result, err := b.BuildStd("runtime", "sync/atomic", "fmt")
+	require.NoError(t, err, stderr.String())
+
+	// Compile and link a command against the standard library built.
+	importcfg := filepath.Join(dir, "importcfg")
+	require.NoError(t, result.ImportConfig.Write(importcfg))
+	main := filepath.Join(dir, "main.go")
+	err = ioutil.WriteFile(main, []byte("package main\n\nimport \"fmt\"\n\nfunc main() { fmt.Println(\"hello, std\") }\n"), 0666)
+	require.NoError(t, err)
+	err = build.DefaultTools.Compile(build.CompileArgs{
+		Context:           ctx,
+		WorkingDirectory:  dir,
+		Stderr:            stderr,
+		Files:             []string{main},
+		OutputFile:        filepath.Join(dir, "main.a"),
+		PackageImportPath: "main",
+		ImportConfigFile:  importcfg,
+		Pack:              true,
+	})
+	require.NoError(t, err, stderr.String())
+	binary := filepath.Join(dir, "hello")
+	err = build.DefaultTools.Link(build.LinkArgs{
+		Context:          ctx,
+		WorkingDirectory: dir,
+		Stderr:           stderr,
+		OutputFile:       binary,
+		ImportConfigFile: importcfg,
+		Files:            []string{filepath.Join(dir, "main.a")},
+	})
+	require.NoError(t, err, stderr.String())
+	out, err := exec.Command(binary).CombinedOutput()
+	require.NoError(t, err, string(out))
+	assert.Equal(t, "hello, std\n", string(out))
+}
@@ -0,0 +1,52 @@
+package api
+
+const Max = 20
+
+var Name = "api"
+
+func F(a int, b int) string { return "" }
+
+func G() {}
+
+func Renamed(x int, g func(string) error) int { return 0 }
+
+func H() {}
+
+type T struct {
+	A int64
+	C string
+	c bool
+}
+
+func (T) M(b ...int) {}
+
+func (*T) Q() {}
+
+type I interface {
+	Read(buf []byte) error
+	Close() error
+	Write()
+}
+
+type Sealed interface {
+	Read() error
+	Write()
+	sealed()
+}
+
+type ID int
+
+var Changed int
+
+func Gen[T comparable](v T) T { return v }
+
+func Map[A comparable, B any](x map[A]B) {}
+
+type Number interface{ ~int | ~string }
+
+type List[T comparable] []T
+
+type Pair[A comparable, B any] struct {
+	Key   A
+	Value B
+}
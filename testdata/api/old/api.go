@@ -0,0 +1,50 @@
+package api
+
+const Max = 10
+
+var Name = "api"
+
+var Removed int
+
+func F(a int) string { return "" }
+
+func G() {}
+
+func Renamed(a int, f func(s string) error) (n int) { return 0 }
+
+type T struct {
+	A int
+	B string
+	c bool
+}
+
+func (T) M(a ...int) {}
+
+func (*T) P(x int) {}
+
+type I interface {
+	Read(p []byte) error
+	Close()
+}
+
+type Sealed interface {
+	Read() error
+	sealed()
+}
+
+type ID int
+
+type Changed int
+
+func Gen[T any](v T) T { return v }
+
+func Map[K comparable, V any](m map[K]V) {}
+
+type Number interface{ ~int }
+
+type List[T any] []T
+
+type Pair[K comparable, V any] struct {
+	Key   K
+	Value V
+}
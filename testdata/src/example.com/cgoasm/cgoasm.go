@@ -0,0 +1,11 @@
+package cgoasm
+
+// int one(void) { return 1; }
+import "C"
+
+// One returns 1.
+func One() int {
+	return int(C.one())
+}
+
+func two() int
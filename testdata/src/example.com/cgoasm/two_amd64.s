@@ -0,0 +1,5 @@
+#include "textflag.h"
+
+TEXT ·two(SB),NOSPLIT,$0-8
+	MOVQ $2, ret+0(FP)
+	RET
@@ -0,0 +1,11 @@
+package main
+
+// #include <stdint.h>
+import "C"
+
+//export Add
+func Add(a, b C.int32_t) C.int32_t {
+	return a + b
+}
+
+func main() {}
@@ -0,0 +1,7 @@
+package embedded
+
+import _ "embed"
+
+// Greeting is embedded from greeting.txt.
+//go:embed greeting.txt
+var Greeting string
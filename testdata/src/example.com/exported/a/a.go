@@ -0,0 +1,12 @@
+package a
+
+// T is exported.
+type T struct {
+	X int
+	y int
+}
+
+// New returns a T.
+func New() *T {
+	return &T{}
+}
@@ -0,0 +1,8 @@
+package b
+
+import "example.com/exported/a"
+
+// Wrap wraps a T.
+func Wrap(t *a.T) []*a.T {
+	return []*a.T{t}
+}
@@ -0,0 +1,7 @@
+package greet
+
+func name() string {
+	return string(rune(letter())) + "orld"
+}
+
+func letter() int64
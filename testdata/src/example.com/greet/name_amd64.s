@@ -0,0 +1,6 @@
+#include "textflag.h"
+
+// func letter() int64
+TEXT ·letter(SB), NOSPLIT, $0-8
+	MOVQ $119, ret+0(FP)
+	RET
@@ -0,0 +1,7 @@
+// +build !amd64
+
+package greet
+
+func name() string {
+	return "world"
+}
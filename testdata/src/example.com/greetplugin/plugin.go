@@ -0,0 +1,8 @@
+package main
+
+import "example.com/greet"
+
+// Greet is looked up by the host.
+func Greet() string {
+	return greet.Hello()
+}
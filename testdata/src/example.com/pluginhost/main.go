@@ -0,0 +1,26 @@
+// Command pluginhost prints its greeting and the one of the plugin given as
+// argument.
+package main
+
+import (
+	"fmt"
+	"os"
+	"plugin"
+
+	"example.com/greet"
+)
+
+func main() {
+	p, err := plugin.Open(os.Args[1])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	f, err := p.Lookup("Greet")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("host:", greet.Hello())
+	fmt.Println("plugin:", f.(func() string)())
+}
@@ -0,0 +1,14 @@
+package main
+
+// Set at link time.
+var (
+	Version string
+	Commit  = "unknown"
+	Count   int
+)
+
+const Name = "stamped"
+
+func main() {
+	println(Version, Commit)
+}
@@ -0,0 +1,29 @@
+package tested
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAdd(t *testing.T) {
+	if Add(1, 2) != 3 {
+		t.Fatal("1+2 != 3")
+	}
+}
+
+func Testlower(t *testing.T) {}
+
+func BenchmarkAdd(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		Add(i, i)
+	}
+}
+
+func ExampleAdd() {
+	fmt.Println(Add(1, 2))
+	// Output: 3
+}
+
+func ExampleAdd_noOutput() {
+	Add(1, 2)
+}
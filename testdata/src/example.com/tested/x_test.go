@@ -0,0 +1,18 @@
+package tested_test
+
+import (
+	"os"
+	"testing"
+
+	"example.com/usestested"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(m.Run())
+}
+
+func TestTwice(t *testing.T) {
+	if usestested.Twice(2) != 4 {
+		t.Fatal("twice 2 != 4")
+	}
+}
@@ -0,0 +1,8 @@
+package usestested
+
+import "example.com/tested"
+
+// Twice returns a+a.
+func Twice(a int) int {
+	return tested.Add(a, a)
+}
@@ -0,0 +1,347 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	gb "go/build"
+	"go/doc"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BuildTest builds the test binary of the package importPath, as found from
+// srcDir, into outputFile, like "go test -c".
+func (b *Builder) BuildTest(importPath, srcDir, outputFile string) (*Result, error) {
+	dir, err := ioutil.TempDir("", "gophertest-testmain")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	plan, err := b.LoadTest(importPath, srcDir, dir)
+	if err != nil {
+		return nil, err
+	}
+	return b.BuildPlan(plan, outputFile)
+}
+
+// LoadTest loads the test binary of the package importPath, as found from
+// srcDir: the package compiled with its test files, its external test
+// package and the generated main package, written to dir as _testmain.go.
+// Dependencies of the external test package importing the package use the
+// version compiled with the test files.
+func (b *Builder) LoadTest(importPath, srcDir, dir string) (*Plan, error) {
+	if err := b.Instrument.Validate(b.Context); err != nil {
+		return nil, err
+	}
+	ctx := b.loadContext()
+	pkg, err := ctx.Import(importPath, srcDir, 0)
+	if err != nil {
+		return nil, err
+	}
+	version, err := b.tools().Version()
+	if err != nil {
+		return nil, err
+	}
+	funcs, err := loadTestFuncs(pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &loader{ctx: ctx, packages: map[string]*Package{}, loading: map[string]bool{}}
+	test := *pkg
+	test.GoFiles = append(append([]string(nil), pkg.GoFiles...), pkg.TestGoFiles...)
+	test.Imports = mergeImports(pkg.Imports, pkg.TestImports)
+	test.EmbedPatterns = mergeImports(pkg.EmbedPatterns, pkg.TestEmbedPatterns)
+	ptest, err := l.loadPackage(&test)
+	if err != nil {
+		return nil, err
+	}
+	deps := []*Package{ptest}
+	if len(pkg.XTestGoFiles) > 0 {
+		xtest := &gb.Package{
+			Dir:           pkg.Dir,
+			Name:          pkg.Name + "_test",
+			ImportPath:    pkg.ImportPath + "_test",
+			Root:          pkg.Root,
+			SrcRoot:       pkg.SrcRoot,
+			Goroot:        pkg.Goroot,
+			GoFiles:       pkg.XTestGoFiles,
+			Imports:       pkg.XTestImports,
+			EmbedPatterns: pkg.XTestEmbedPatterns,
+		}
+		pxtest, err := l.loadPackage(xtest)
+		if err != nil {
+			return nil, err
+		}
+		deps = append(deps, pxtest)
+	}
+
+	src, err := funcs.testMain(pkg.ImportPath, version)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "_testmain.go"), src, 0666); err != nil {
+		return nil, err
+	}
+	imports := []string{"testing", "testing/internal/testdeps"}
+	if funcs.TestMain == nil || funcs.ExitCode {
+		imports = append(imports, "os")
+	}
+	if funcs.TestMain != nil && funcs.ExitCode {
+		imports = append(imports, "reflect")
+	}
+	main, err := l.loadPackage(&gb.Package{
+		Dir:        dir,
+		Name:       "main",
+		ImportPath: pkg.ImportPath + ".test",
+		GoFiles:    []string{"_testmain.go"},
+		Imports:    imports,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The packages under test are imported by path, not loaded.
+	main.Deps = append(main.Deps, deps...)
+	for _, v := range linkDeps(b.Context, b.mode(), b.Instrument) {
+		if _, err := l.load(v, ""); err != nil {
+			return nil, err
+		}
+	}
+	for i, v := range l.order {
+		if v == main {
+			l.order = append(append(l.order[:i:i], l.order[i+1:]...), main)
+			break
+		}
+	}
+	return &Plan{Main: main, Packages: l.order}, nil
+}
+
+// mergeImports returns the sorted union of a and b.
+func mergeImports(a, b []string) []string {
+	seen := map[string]bool{}
+	merged := []string(nil)
+	for _, v := range append(append([]string(nil), a...), b...) {
+		if !seen[v] {
+			seen[v] = true
+			merged = append(merged, v)
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
+
+// testFuncs are the tests of a package, as found by cmd/go.
+type testFuncs struct {
+	Tests       []testFunc
+	Benchmarks  []testFunc
+	FuzzTargets []testFunc
+	Examples    []testFunc
+	TestMain    *testFunc
+	// NeedTest and NeedXtest are set if functions of the package or of the
+	// external test package are referenced.
+	NeedTest  bool
+	NeedXtest bool
+	// ImportXtest is set if there is an external test package.
+	ImportXtest bool
+	// Fuzz and ExitCode are set if the testing package supports fuzzing
+	// and the exit code of TestMain returning.
+	Fuzz     bool
+	ExitCode bool
+	// ImportPath of the package under test.
+	ImportPath string
+}
+
+type testFunc struct {
+	Package   string
+	Name      string
+	Output    string
+	Unordered bool
+}
+
+// loadTestFuncs parses the test files of pkg for tests, benchmarks, fuzz
+// targets, examples and TestMain.
+func loadTestFuncs(pkg *gb.Package) (*testFuncs, error) {
+	t := &testFuncs{ImportPath: pkg.ImportPath, ImportXtest: len(pkg.XTestGoFiles) > 0}
+	for _, v := range pkg.TestGoFiles {
+		if err := t.load(filepath.Join(pkg.Dir, v), "_test", &t.NeedTest); err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range pkg.XTestGoFiles {
+		if err := t.load(filepath.Join(pkg.Dir, v), "_xtest", &t.NeedXtest); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *testFuncs) load(file, pkg string, seen *bool) error {
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.ParseComments)
+	if err != nil {
+		return err
+	}
+	for _, d := range f.Decls {
+		fn, ok := d.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil {
+			continue
+		}
+		name := fn.Name.String()
+		switch {
+		case name == "TestMain" && isTestFunc(fn, "M"):
+			if t.TestMain != nil {
+				return fmt.Errorf("%s: multiple definitions of TestMain", file)
+			}
+			t.TestMain = &testFunc{Package: pkg, Name: name}
+			*seen = true
+		case isTest(name, "Test") && isTestFunc(fn, "T"):
+			t.Tests = append(t.Tests, testFunc{Package: pkg, Name: name})
+			*seen = true
+		case isTest(name, "Benchmark") && isTestFunc(fn, "B"):
+			t.Benchmarks = append(t.Benchmarks, testFunc{Package: pkg, Name: name})
+			*seen = true
+		case isTest(name, "Fuzz") && isTestFunc(fn, "F"):
+			t.FuzzTargets = append(t.FuzzTargets, testFunc{Package: pkg, Name: name})
+			*seen = true
+		}
+	}
+	examples := doc.Examples(f)
+	sort.Slice(examples, func(i, j int) bool { return examples[i].Order < examples[j].Order })
+	for _, e := range examples {
+		// Examples without output are compiled but not run.
+		if e.Output == "" && !e.EmptyOutput {
+			continue
+		}
+		t.Examples = append(t.Examples, testFunc{Package: pkg, Name: "Example" + e.Name, Output: e.Output, Unordered: e.Unordered})
+		*seen = true
+	}
+	return nil
+}
+
+// isTest reports whether name is prefix followed by nothing or by a
+// character that is not lower case, like "TestFoo" but not "Testfoo".
+func isTest(name, prefix string) bool {
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	if len(name) == len(prefix) {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(name[len(prefix):])
+	return !unicode.IsLower(r)
+}
+
+// isTestFunc reports whether fn has a single parameter of type *testing.arg,
+// as written in the source.
+func isTestFunc(fn *ast.FuncDecl, arg string) bool {
+	if fn.Type.Results != nil && len(fn.Type.Results.List) > 0 ||
+		fn.Type.Params.List == nil || len(fn.Type.Params.List) != 1 || len(fn.Type.Params.List[0].Names) > 1 {
+		return false
+	}
+	ptr, ok := fn.Type.Params.List[0].Type.(*ast.StarExpr)
+	if !ok {
+		return false
+	}
+	switch x := ptr.X.(type) {
+	case *ast.Ident:
+		// Dot imported testing.
+		return x.Name == arg
+	case *ast.SelectorExpr:
+		return x.Sel.Name == arg
+	}
+	return false
+}
+
+// testMain returns the source of the main package of the test binary, for
+// the testing package of the toolchain version.
+func (t *testFuncs) testMain(importPath, version string) ([]byte, error) {
+	minor, ok := goMinorVersion(version)
+	if !ok {
+		return nil, fmt.Errorf("unknown go version %q", version)
+	}
+	t.Fuzz = minor >= 18
+	t.ExitCode = minor >= 15
+	if !t.Fuzz && len(t.FuzzTargets) > 0 {
+		return nil, fmt.Errorf("fuzz targets require go1.18 or later, have %q", version)
+	}
+	buf := &bytes.Buffer{}
+	if err := testMainTemplate.Execute(buf, t); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var testMainTemplate = template.Must(template.New("main").Parse(`// Code generated by gophertest/build. DO NOT EDIT.
+
+package main
+
+import (
+{{- if or (not .TestMain) .ExitCode}}
+	"os"
+{{- end}}
+{{- if and .TestMain .ExitCode}}
+	"reflect"
+{{- end}}
+	"testing"
+	"testing/internal/testdeps"
+
+	{{if .NeedTest}}_test{{else}}_{{end}} {{printf "%q" .ImportPath}}
+{{- if .ImportXtest}}
+	{{if .NeedXtest}}_xtest{{else}}_{{end}} {{printf "%q" (printf "%s_test" .ImportPath)}}
+{{- end}}
+)
+
+var tests = []testing.InternalTest{
+{{- range .Tests}}
+	{"{{.Name}}", {{.Package}}.{{.Name}}},
+{{- end}}
+}
+
+var benchmarks = []testing.InternalBenchmark{
+{{- range .Benchmarks}}
+	{"{{.Name}}", {{.Package}}.{{.Name}}},
+{{- end}}
+}
+{{- if .Fuzz}}
+
+var fuzzTargets = []testing.InternalFuzzTarget{
+{{- range .FuzzTargets}}
+	{"{{.Name}}", {{.Package}}.{{.Name}}},
+{{- end}}
+}
+{{- end}}
+
+var examples = []testing.InternalExample{
+{{- range .Examples}}
+	{"{{.Name}}", {{.Package}}.{{.Name}}, {{printf "%q" .Output}}, {{.Unordered}}},
+{{- end}}
+}
+
+func init() {
+	testdeps.ImportPath = {{printf "%q" .ImportPath}}
+}
+
+func main() {
+{{- if .Fuzz}}
+	m := testing.MainStart(testdeps.TestDeps{}, tests, benchmarks, fuzzTargets, examples)
+{{- else}}
+	m := testing.MainStart(testdeps.TestDeps{}, tests, benchmarks, examples)
+{{- end}}
+{{- with .TestMain}}
+	{{.Package}}.{{.Name}}(m)
+{{- if $.ExitCode}}
+	os.Exit(int(reflect.ValueOf(m).Elem().FieldByName("exitCode").Int()))
+{{- end}}
+{{- else}}
+	os.Exit(m.Run())
+{{- end}}
+}
+`))
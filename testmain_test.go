@@ -0,0 +1,165 @@
+package build_test
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/gophertest/build/buildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMain113 = `// Code generated by gophertest/build. DO NOT EDIT.
+
+package main
+
+import (
+	"testing"
+	"testing/internal/testdeps"
+
+	_test "example.com/tested"
+	_xtest "example.com/tested_test"
+)
+
+var tests = []testing.InternalTest{
+	{"TestAdd", _test.TestAdd},
+	{"TestTwice", _xtest.TestTwice},
+}
+
+var benchmarks = []testing.InternalBenchmark{
+	{"BenchmarkAdd", _test.BenchmarkAdd},
+}
+
+var examples = []testing.InternalExample{
+	{"ExampleAdd", _test.ExampleAdd, "3\n", false},
+}
+
+func init() {
+	testdeps.ImportPath = "example.com/tested"
+}
+
+func main() {
+	m := testing.MainStart(testdeps.TestDeps{}, tests, benchmarks, examples)
+	_xtest.TestMain(m)
+}
+`
+
+func TestBuilderLoadTest(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "testmain")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	b := &build.Builder{Tools: buildtest.NewFakeTools(), Context: ctx}
+	plan, err := b.LoadTest("example.com/tested", "", dir)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com/tested.test", plan.Main.ImportPath)
+	assert.Equal(t, plan.Main, plan.Packages[len(plan.Packages)-1])
+	src, err := ioutil.ReadFile(filepath.Join(dir, "_testmain.go"))
+	require.NoError(t, err)
+	assert.Equal(t, testMain113, string(src))
+
+	packages := map[string]*build.Package{}
+	for _, p := range plan.Packages {
+		_, dup := packages[p.ImportPath]
+		assert.False(t, dup, p.ImportPath)
+		packages[p.ImportPath] = p
+	}
+	tested := packages["example.com/tested"]
+	require.NotNil(t, tested)
+	assert.Equal(t, []string{"tested.go", "tested_test.go"}, tested.GoFiles)
+	assert.Contains(t, tested.Imports, "testing")
+	xtest := packages["example.com/tested_test"]
+	require.NotNil(t, xtest)
+	assert.Equal(t, []string{"x_test.go"}, xtest.GoFiles)
+	// The dependencies of the external tests use the package with its tests.
+	uses := packages["example.com/usestested"]
+	require.NotNil(t, uses)
+	assert.Equal(t, []*build.Package{tested}, uses.Deps)
+	assert.Contains(t, plan.Main.Deps, tested)
+	assert.Contains(t, plan.Main.Deps, xtest)
+	assert.Contains(t, packages, "testing/internal/testdeps")
+	assert.Contains(t, packages, "runtime")
+}
+
+func TestBuilderLoadTestVersions(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+
+	testCases := []struct {
+		Version  string
+		Contains []string
+		Excludes []string
+	}{
+		{"go version go1.13 linux/amd64", []string{"_xtest.TestMain(m)\n}"}, []string{`"os"`, "reflect", "fuzzTargets"}},
+		{"go version go1.16.3 linux/amd64", []string{`"reflect"`, `FieldByName("exitCode")`}, []string{"fuzzTargets"}},
+		{"go version go1.21.0 linux/amd64", []string{`"reflect"`, "tests, benchmarks, fuzzTargets, examples"}, nil},
+		{"go version devel go1.23-abcdef linux/amd64", []string{"var fuzzTargets = []testing.InternalFuzzTarget{\n}"}, nil},
+	}
+	for _, tc := range testCases {
+		dir, err := ioutil.TempDir("", "testmain")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+		ft := buildtest.NewFakeTools()
+		ft.VersionString = tc.Version
+		b := &build.Builder{Tools: ft, Context: ctx}
+		plan, err := b.LoadTest("example.com/tested", "", dir)
+		require.NoError(t, err, tc.Version)
+		file := filepath.Join(dir, "_testmain.go")
+		src, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+		_, err = parser.ParseFile(token.NewFileSet(), file, src, 0)
+		assert.NoError(t, err, tc.Version)
+		for _, v := range tc.Contains {
+			assert.Contains(t, string(src), v, tc.Version)
+		}
+		for _, v := range tc.Excludes {
+			assert.NotContains(t, string(src), v, tc.Version)
+		}
+		assert.Equal(t, len(tc.Excludes) == 0 || tc.Excludes[0] != `"os"`, containsImport(plan.Main, "os"), tc.Version)
+	}
+}
+
+func containsImport(p *build.Package, path string) bool {
+	for _, v := range p.Imports {
+		if v == path {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBuilderBuildTest(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+	dir, err := ioutil.TempDir("", "testmain")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	b := &build.Builder{Tools: ft, Context: ctx}
+	output := filepath.Join(dir, "tested.test")
+	result, err := b.BuildTest("example.com/tested", "", output)
+	require.NoError(t, err)
+	assert.Equal(t, output, result.Binary)
+	assert.FileExists(t, output)
+
+	compiles := map[string]build.CompileArgs{}
+	for _, v := range ft.CompileCalls() {
+		compiles[v.PackageImportPath] = v
+	}
+	assert.Equal(t, []string{"_testmain.go"}, compiles["main"].Files)
+	assert.Equal(t, []string{"tested.go", "tested_test.go"}, compiles["example.com/tested"].Files)
+	assert.Equal(t, []string{"x_test.go"}, compiles["example.com/tested_test"].Files)
+	require.Len(t, ft.LinkCalls(), 1)
+
+	_, err = b.BuildTest("example.com/missing", "", output)
+	assert.Error(t, err)
+}
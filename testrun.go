@@ -0,0 +1,483 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TestEvent is an event of a test run, as encoded by test2json.
+type TestEvent struct {
+	Time    *time.Time `json:",omitempty"`
+	Action  string
+	Package string  `json:",omitempty"`
+	Test    string  `json:",omitempty"`
+	Elapsed float64 `json:",omitempty"`
+	Output  string  `json:",omitempty"`
+}
+
+// TestResult is the outcome of a single run of a test.
+type TestResult struct {
+	// Name of the test, "TestParent/sub" for subtests.
+	Name string
+	// Action is "pass", "fail" or "skip".
+	Action  string
+	Elapsed time.Duration
+	// Output of the test, including its framing lines.
+	Output string
+}
+
+// TestReport is the outcome of a test binary.
+type TestReport struct {
+	// Package is the import path of the tested package.
+	Package string
+	// Action is "pass" or "fail".
+	Action  string
+	Elapsed time.Duration
+	// Tests in the order they finished. Tests run several times with
+	// TestRunner.Count have several results.
+	Tests []TestResult
+	// Output of the binary that is not part of a test.
+	Output string
+	// Panicked is set if the binary panicked.
+	Panicked bool
+	// TimedOut is set if the binary ran longer than its timeout.
+	TimedOut bool
+}
+
+// Failed returns the results of the failed tests.
+func (r *TestReport) Failed() []TestResult {
+	failed := []TestResult(nil)
+	for _, v := range r.Tests {
+		if v.Action == "fail" {
+			failed = append(failed, v)
+		}
+	}
+	return failed
+}
+
+// killGrace is how long a test binary may run past its timeout before being
+// killed, to give it time to report the tests running, like "go test".
+var killGrace = time.Minute
+
+// TestRunner runs test binaries, as built by BuildTest, and parses their
+// verbose output into TestReports.
+type TestRunner struct {
+	// Package is the import path reported as the Package of the events.
+	Package string
+	// Version of the toolchain the binary was built with, as returned by
+	// Tools.Version. From go1.20 the binary frames its output for test2json
+	// so that the output of the tests cannot be mistaken for it.
+	Version string
+	// Dir is the working directory of the binary, the current directory if
+	// empty. "go test" runs binaries in the directory of the package.
+	Dir string
+	// Env the binary is run with.
+	Env Env
+	// Pattern selects the tests to run, as -test.run.
+	Pattern string
+	// Count runs each test Count times, as -test.count.
+	Count int
+	// Timeout makes the binary panic after Timeout, as -test.timeout. It is
+	// killed if still running a minute later.
+	Timeout time.Duration
+	// Parallel is the maximum number of parallel tests, as -test.parallel.
+	Parallel int
+	// Shards splits the tests across that many concurrent processes.
+	Shards int
+	// Args are more flags of the binary.
+	Args []string
+	// Events receives the TestEvents of the run as JSON, if set.
+	Events io.Writer
+}
+
+// Run runs binary and returns its report. Failing tests are reported, not
+// returned as errors.
+func (r *TestRunner) Run(ctx context.Context, binary string) (*TestReport, error) {
+	start := time.Now()
+	emit := r.emitter()
+	emit(TestEvent{Action: "start"})
+	patterns := []string{r.Pattern}
+	if r.Shards > 1 {
+		var err error
+		if patterns, err = r.shards(ctx, binary); err != nil {
+			return nil, err
+		}
+	}
+	reports := make([]*TestReport, len(patterns))
+	errs := make([]error, len(patterns))
+	wg := sync.WaitGroup{}
+	for i, v := range patterns {
+		wg.Add(1)
+		go func(i int, pattern string) {
+			defer wg.Done()
+			reports[i], errs[i] = r.run(ctx, binary, pattern, emit)
+		}(i, v)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	report := &TestReport{Package: r.Package, Action: "pass"}
+	for _, v := range reports {
+		report.Tests = append(report.Tests, v.Tests...)
+		report.Output += v.Output
+		report.Panicked = report.Panicked || v.Panicked
+		report.TimedOut = report.TimedOut || v.TimedOut
+		if v.Action != "pass" {
+			report.Action = "fail"
+		}
+	}
+	report.Elapsed = time.Since(start)
+	emit(TestEvent{Action: report.Action, Elapsed: report.Elapsed.Seconds()})
+	return report, nil
+}
+
+// emitter returns a func writing the events of the run to Events.
+func (r *TestRunner) emitter() func(TestEvent) {
+	if r.Events == nil {
+		return func(TestEvent) {}
+	}
+	mutex := sync.Mutex{}
+	enc := json.NewEncoder(r.Events)
+	return func(e TestEvent) {
+		now := time.Now()
+		e.Time = &now
+		e.Package = r.Package
+		mutex.Lock()
+		defer mutex.Unlock()
+		enc.Encode(e)
+	}
+}
+
+// shards lists the tests of binary selected by Pattern and returns the
+// patterns running each shard.
+func (r *TestRunner) shards(ctx context.Context, binary string) ([]string, error) {
+	top, sub := r.Pattern, ""
+	if i := strings.Index(top, "/"); i >= 0 {
+		top, sub = top[:i], top[i:]
+	}
+	if top == "" {
+		top = "."
+	}
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, binary, "-test.list", top)
+	cmd.Dir = r.Dir
+	cmd.Env = r.Env.environ()
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("listing tests of %s: %v: %s", binary, err, stderr)
+	}
+	shards := make([][]string, r.Shards)
+	n := 0
+	for _, v := range strings.Split(stdout.String(), "\n") {
+		if v == "" || strings.ContainsAny(v, " \t") {
+			continue
+		}
+		shards[n%len(shards)] = append(shards[n%len(shards)], regexp.QuoteMeta(v))
+		n++
+	}
+	if n == 0 {
+		return []string{r.Pattern}, nil
+	}
+	patterns := []string(nil)
+	for _, v := range shards {
+		if len(v) > 0 {
+			patterns = append(patterns, "^("+strings.Join(v, "|")+")$"+sub)
+		}
+	}
+	return patterns, nil
+}
+
+// run runs binary with the tests selected by pattern.
+func (r *TestRunner) run(ctx context.Context, binary, pattern string, emit func(TestEvent)) (*TestReport, error) {
+	args := []string{"-test.v"}
+	if minor, ok := goMinorVersion(r.Version); ok && minor >= 20 {
+		args[0] = "-test.v=test2json"
+	}
+	if pattern != "" {
+		args = append(args, "-test.run="+pattern)
+	}
+	if r.Count > 0 {
+		args = append(args, "-test.count="+strconv.Itoa(r.Count))
+	}
+	if r.Timeout > 0 {
+		args = append(args, "-test.timeout="+r.Timeout.String())
+	}
+	if r.Parallel > 0 {
+		args = append(args, "-test.parallel="+strconv.Itoa(r.Parallel))
+	}
+	args = append(args, r.Args...)
+	if DebugLog {
+		fmt.Printf("cd %s\n", r.Dir)
+		fmt.Printf("%s %s\n", binary, strings.Join(args, " "))
+	}
+	c := newTestConverter(r.Package, emit)
+	cmd := exec.Command(binary, args...)
+	cmd.Dir = r.Dir
+	cmd.Env = r.Env.environ()
+	// The same writer for both keeps the panics in order with the output.
+	cmd.Stdout = c
+	cmd.Stderr = c
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	var kill <-chan time.Time
+	if r.Timeout > 0 {
+		timer := time.NewTimer(r.Timeout + killGrace)
+		defer timer.Stop()
+		kill = timer.C
+	}
+	var err error
+	select {
+	case err = <-done:
+	case <-kill:
+		cmd.Process.Kill()
+		err = <-done
+		c.flush()
+		c.line(fmt.Sprintf("*** Test killed: ran too long (%v).\n", r.Timeout+killGrace))
+		c.report.TimedOut = true
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		<-done
+		return nil, ctx.Err()
+	}
+	c.close(err)
+	return c.report, nil
+}
+
+// ParseTestOutput parses the verbose output of a test binary of the package
+// importPath, framed for test2json or not.
+func ParseTestOutput(r io.Reader, importPath string) (*TestReport, error) {
+	c := newTestConverter(importPath, func(TestEvent) {})
+	if _, err := io.Copy(c, r); err != nil {
+		return nil, err
+	}
+	c.close(nil)
+	return c.report, nil
+}
+
+// Markers of the output of test binaries run with -test.v=test2json.
+const (
+	markFraming  = 'V' &^ '@'
+	markErrBegin = 'O' &^ '@'
+	markErrEnd   = 'N' &^ '@'
+	markEscape   = '[' &^ '@'
+)
+
+var (
+	testUpdates = []string{"=== RUN   ", "=== PAUSE ", "=== CONT  ", "=== NAME  "}
+	testReports = []string{"--- PASS: ", "--- FAIL: ", "--- SKIP: "}
+)
+
+// testConverter converts the verbose output of a test binary into
+// TestEvents and a TestReport, like cmd/test2json.
+type testConverter struct {
+	report *TestReport
+	emit   func(TestEvent)
+	start  time.Time
+	buf    []byte
+	// framed is set once a framing marker was seen, the lines without are
+	// output from then on.
+	framed bool
+	// test the output is attributed to.
+	test string
+	// reports are the pending results of a test and its subtests, which are
+	// printed before the results of the subtests.
+	reports []TestEvent
+	// running are the started tests without result.
+	running []string
+	outputs map[string]*bytes.Buffer
+	result  string
+}
+
+func newTestConverter(importPath string, emit func(TestEvent)) *testConverter {
+	return &testConverter{
+		report:  &TestReport{Package: importPath},
+		emit:    emit,
+		start:   time.Now(),
+		outputs: map[string]*bytes.Buffer{},
+	}
+}
+
+func (c *testConverter) Write(b []byte) (int, error) {
+	c.buf = append(c.buf, b...)
+	for {
+		i := bytes.IndexByte(c.buf, '\n')
+		if i < 0 {
+			break
+		}
+		c.line(string(c.buf[:i+1]))
+		c.buf = c.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// flush handles the last line if not terminated by a newline.
+func (c *testConverter) flush() {
+	if len(c.buf) > 0 {
+		c.line(string(c.buf) + "\n")
+		c.buf = nil
+	}
+}
+
+// close ends the conversion, the binary exiting with err. Tests left
+// running failed.
+func (c *testConverter) close(err error) {
+	c.flush()
+	c.flushReports(0)
+	for len(c.running) > 0 {
+		c.finish(TestEvent{Action: "fail", Test: c.running[0]})
+	}
+	if c.result == "" || err != nil {
+		c.result = "fail"
+	}
+	c.report.Action = c.result
+	c.report.Elapsed = time.Since(c.start)
+}
+
+func (c *testConverter) line(line string) {
+	framing := line[0] == markFraming
+	if framing {
+		c.framed = true
+		line = line[1:]
+	}
+	line = unescapeTestOutput(line)
+	trim := strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(trim, "panic: ") {
+		c.report.Panicked = true
+		c.report.TimedOut = c.report.TimedOut || strings.HasPrefix(trim, "panic: test timed out")
+	}
+	if c.framed && !framing {
+		c.output(line)
+		return
+	}
+
+	if trim == "PASS" || trim == "FAIL" {
+		c.flushReports(0)
+		c.test = ""
+		c.result = strings.ToLower(trim)
+		c.output(line)
+		return
+	}
+	for _, v := range testUpdates {
+		if !strings.HasPrefix(line, v) {
+			continue
+		}
+		action := strings.ToLower(strings.TrimSpace(v[len("=== "):]))
+		name := strings.TrimSpace(line[len(v):])
+		c.flushReports(0)
+		c.test = name
+		switch action {
+		case "name":
+			return
+		case "run":
+			c.running = append(c.running, name)
+			c.outputs[name] = &bytes.Buffer{}
+		}
+		c.emit(TestEvent{Action: action, Test: name})
+		c.output(line)
+		return
+	}
+
+	indent := 0
+	rest := line
+	for strings.HasPrefix(rest, "    ") {
+		rest = rest[4:]
+		indent++
+	}
+	for _, v := range testReports {
+		if !strings.HasPrefix(rest, v) || len(c.reports) < indent {
+			continue
+		}
+		e := TestEvent{Action: strings.ToLower(v[len("--- ") : len(v)-2])}
+		e.Test = strings.TrimSpace(rest[len(v):])
+		if i := strings.Index(e.Test, " ("); i >= 0 && strings.HasSuffix(e.Test, "s)") {
+			e.Elapsed, _ = strconv.ParseFloat(e.Test[i+2:len(e.Test)-2], 64)
+			e.Test = e.Test[:i]
+		}
+		c.flushReports(indent)
+		c.test = e.Test
+		c.reports = append(c.reports, e)
+		c.output(line)
+		return
+	}
+	// The output of subtests is indented by their depth.
+	if indent > 0 && indent <= len(c.reports) {
+		c.test = c.reports[indent-1].Test
+	}
+	c.output(line)
+}
+
+// unescapeTestOutput removes the error markers and escapes of a line.
+func unescapeTestOutput(line string) string {
+	if !strings.ContainsAny(line, string([]byte{markErrBegin, markErrEnd, markEscape})) {
+		return line
+	}
+	b := make([]byte, 0, len(line))
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case markErrBegin, markErrEnd:
+		case markEscape:
+			if i+1 < len(line) {
+				i++
+				b = append(b, line[i])
+			}
+		default:
+			b = append(b, line[i])
+		}
+	}
+	return string(b)
+}
+
+func (c *testConverter) output(line string) {
+	c.emit(TestEvent{Action: "output", Test: c.test, Output: line})
+	if out, ok := c.outputs[c.test]; ok && c.test != "" {
+		out.WriteString(line)
+	} else {
+		c.report.Output += line
+	}
+}
+
+// flushReports finishes the tests of the pending reports deeper than depth.
+func (c *testConverter) flushReports(depth int) {
+	c.test = ""
+	for len(c.reports) > depth {
+		e := c.reports[len(c.reports)-1]
+		c.reports = c.reports[:len(c.reports)-1]
+		c.finish(e)
+	}
+}
+
+// finish records the result e of a test.
+func (c *testConverter) finish(e TestEvent) {
+	for i := len(c.running) - 1; i >= 0; i-- {
+		if c.running[i] == e.Test {
+			c.running = append(c.running[:i], c.running[i+1:]...)
+			break
+		}
+	}
+	c.emit(e)
+	result := TestResult{Name: e.Test, Action: e.Action, Elapsed: time.Duration(e.Elapsed * float64(time.Second))}
+	if out, ok := c.outputs[e.Test]; ok {
+		result.Output = out.String()
+	}
+	c.report.Tests = append(c.report.Tests, result)
+}
@@ -0,0 +1,251 @@
+package build_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gophertest/build"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The TestRunnerChild tests are run by the TestRunner tests re-executing the
+// test binary, with GOPHERTEST_TESTRUN set to the mode of the run.
+
+func runnerChild(t *testing.T, mode string) {
+	if os.Getenv("GOPHERTEST_TESTRUN") != mode {
+		t.Skip("run by the TestRunner tests")
+	}
+}
+
+func TestRunnerChildPass(t *testing.T) {
+	runnerChild(t, "1")
+	t.Log("hello")
+	t.Run("sub", func(t *testing.T) {
+		t.Log("from sub")
+	})
+}
+
+func TestRunnerChildFail(t *testing.T) {
+	runnerChild(t, "1")
+	t.Run("sub", func(t *testing.T) {
+		t.Error("sub failed")
+	})
+	t.Run("ok", func(t *testing.T) {})
+}
+
+func TestRunnerChildSkip(t *testing.T) {
+	runnerChild(t, "1")
+	t.Skip("skipped")
+}
+
+func TestRunnerChildPanic(t *testing.T) {
+	runnerChild(t, "panic")
+	panic("boom")
+}
+
+func TestRunnerChildHang(t *testing.T) {
+	runnerChild(t, "hang")
+	time.Sleep(time.Minute)
+}
+
+func testRunner(mode string) *build.TestRunner {
+	return &build.TestRunner{
+		Package: "example.com/runner",
+		Version: runtime.Version(),
+		Env:     build.Env{Vars: []string{"GOPHERTEST_TESTRUN=" + mode}},
+		Pattern: "^TestRunnerChild",
+	}
+}
+
+func results(report *build.TestReport) map[string]string {
+	actions := map[string]string{}
+	for _, v := range report.Tests {
+		actions[v.Name] = v.Action
+	}
+	return actions
+}
+
+func TestRunnerRun(t *testing.T) {
+	events := &bytes.Buffer{}
+	r := testRunner("1")
+	r.Events = events
+	r.Parallel = 2
+	report, err := r.Run(context.Background(), os.Args[0])
+	require.NoError(t, err)
+	assert.Equal(t, "fail", report.Action)
+	assert.False(t, report.Panicked)
+	assert.Equal(t, map[string]string{
+		"TestRunnerChildPass":     "pass",
+		"TestRunnerChildPass/sub": "pass",
+		"TestRunnerChildFail":     "fail",
+		"TestRunnerChildFail/sub": "fail",
+		"TestRunnerChildFail/ok":  "pass",
+		"TestRunnerChildSkip":     "skip",
+		"TestRunnerChildPanic":    "skip",
+		"TestRunnerChildHang":     "skip",
+	}, results(report))
+	failed := report.Failed()
+	require.Len(t, failed, 2)
+	assert.Equal(t, "TestRunnerChildFail/sub", failed[0].Name)
+	assert.Contains(t, failed[0].Output, "sub failed")
+	for _, v := range report.Tests {
+		if v.Name == "TestRunnerChildPass" {
+			assert.Contains(t, v.Output, "hello")
+			assert.NotContains(t, v.Output, "from sub")
+		}
+	}
+	assert.Contains(t, report.Output, "FAIL\n")
+
+	lines := strings.Split(strings.TrimSpace(events.String()), "\n")
+	assert.Contains(t, lines[0], `"Action":"start","Package":"example.com/runner"`)
+	assert.Contains(t, lines[len(lines)-1], `"Action":"fail","Package":"example.com/runner"`)
+	assert.Contains(t, events.String(), `"Action":"run","Package":"example.com/runner","Test":"TestRunnerChildPass"}`)
+	assert.Contains(t, events.String(), `"Action":"output","Package":"example.com/runner","Test":"TestRunnerChildFail/sub","Output":"`)
+}
+
+func TestRunnerUnframed(t *testing.T) {
+	// Binaries of go1.19 and earlier only support -test.v.
+	r := testRunner("1")
+	r.Version = "go version go1.19 linux/amd64"
+	r.Pattern = "^TestRunnerChild(Pass|Fail)$"
+	report, err := r.Run(context.Background(), os.Args[0])
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"TestRunnerChildPass":     "pass",
+		"TestRunnerChildPass/sub": "pass",
+		"TestRunnerChildFail":     "fail",
+		"TestRunnerChildFail/sub": "fail",
+		"TestRunnerChildFail/ok":  "pass",
+	}, results(report))
+}
+
+func TestRunnerCount(t *testing.T) {
+	r := testRunner("1")
+	r.Pattern = "^TestRunnerChildPass$"
+	r.Count = 2
+	report, err := r.Run(context.Background(), os.Args[0])
+	require.NoError(t, err)
+	assert.Equal(t, "pass", report.Action)
+	require.Len(t, report.Tests, 4)
+	assert.Equal(t, "TestRunnerChildPass", report.Tests[3].Name)
+}
+
+func TestRunnerShards(t *testing.T) {
+	r := testRunner("1")
+	r.Pattern = "^TestRunnerChild(Pass|Fail|Skip)$/^sub$"
+	r.Shards = 2
+	report, err := r.Run(context.Background(), os.Args[0])
+	require.NoError(t, err)
+	assert.Equal(t, "fail", report.Action)
+	assert.Equal(t, map[string]string{
+		"TestRunnerChildPass":     "pass",
+		"TestRunnerChildPass/sub": "pass",
+		"TestRunnerChildFail":     "fail",
+		"TestRunnerChildFail/sub": "fail",
+		"TestRunnerChildSkip":     "skip",
+	}, results(report))
+}
+
+func TestRunnerPanic(t *testing.T) {
+	r := testRunner("panic")
+	r.Pattern = "^TestRunnerChild(Pass|Panic)$"
+	report, err := r.Run(context.Background(), os.Args[0])
+	require.NoError(t, err)
+	assert.Equal(t, "fail", report.Action)
+	assert.True(t, report.Panicked)
+	assert.False(t, report.TimedOut)
+	assert.Equal(t, "fail", results(report)["TestRunnerChildPanic"])
+	failed := report.Failed()
+	require.Len(t, failed, 1)
+	assert.Contains(t, failed[0].Output, "panic: boom")
+}
+
+func TestRunnerTimeout(t *testing.T) {
+	r := testRunner("hang")
+	r.Pattern = "^TestRunnerChildHang$"
+	r.Timeout = 100 * time.Millisecond
+	report, err := r.Run(context.Background(), os.Args[0])
+	require.NoError(t, err)
+	assert.Equal(t, "fail", report.Action)
+	assert.True(t, report.TimedOut)
+	assert.Equal(t, map[string]string{"TestRunnerChildHang": "fail"}, results(report))
+
+	// Binaries ignoring their timeout are killed.
+	defer build.SetKillGrace(100 * time.Millisecond)()
+	r.Args = []string{"-test.timeout=0"}
+	report, err = r.Run(context.Background(), os.Args[0])
+	require.NoError(t, err)
+	assert.True(t, report.TimedOut)
+	assert.False(t, report.Panicked)
+	assert.Equal(t, map[string]string{"TestRunnerChildHang": "fail"}, results(report))
+	assert.Contains(t, report.Tests[0].Output, "*** Test killed: ran too long")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = r.Run(ctx, os.Args[0])
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestParseTestOutput(t *testing.T) {
+	testCases := []struct {
+		Output   string
+		Action   string
+		Results  []build.TestResult
+		Panicked bool
+		TimedOut bool
+	}{
+		{
+			"=== RUN   TestA\n--- PASS: TestA (0.50s)\nPASS\n",
+			"pass",
+			[]build.TestResult{{Name: "TestA", Action: "pass", Elapsed: 500 * time.Millisecond, Output: "=== RUN   TestA\n--- PASS: TestA (0.50s)\n"}},
+			false, false,
+		},
+		{
+			// Subtest results are printed after their parent.
+			"=== RUN   TestA\n=== RUN   TestA/b\n    a_test.go:3: bad\n--- FAIL: TestA (0.00s)\n    --- FAIL: TestA/b (0.00s)\n        a_test.go:3: bad\nFAIL\n",
+			"fail",
+			[]build.TestResult{
+				{Name: "TestA/b", Action: "fail", Output: "=== RUN   TestA/b\n    a_test.go:3: bad\n    --- FAIL: TestA/b (0.00s)\n        a_test.go:3: bad\n"},
+				{Name: "TestA", Action: "fail", Output: "=== RUN   TestA\n--- FAIL: TestA (0.00s)\n"},
+			},
+			false, false,
+		},
+		{
+			// Framed output, the test printing framing lines itself.
+			"\x16=== RUN   TestA\n--- PASS: TestB (0.00s)\n\x16--- PASS: TestA (0.00s)\n\x16PASS\n",
+			"pass",
+			[]build.TestResult{{Name: "TestA", Action: "pass", Output: "=== RUN   TestA\n--- PASS: TestB (0.00s)\n--- PASS: TestA (0.00s)\n"}},
+			false, false,
+		},
+		{
+			"=== RUN   TestA\n=== PAUSE TestA\n=== RUN   TestB\n=== CONT  TestA\npanic: test timed out after 1s\n",
+			"fail",
+			[]build.TestResult{
+				{Name: "TestA", Action: "fail", Output: "=== RUN   TestA\n=== PAUSE TestA\n=== CONT  TestA\npanic: test timed out after 1s\n"},
+				{Name: "TestB", Action: "fail", Output: "=== RUN   TestB\n"},
+			},
+			true, true,
+		},
+		{
+			"no tests\n",
+			"fail",
+			nil,
+			false, false,
+		},
+	}
+	for _, tc := range testCases {
+		report, err := build.ParseTestOutput(strings.NewReader(tc.Output), "example.com/a")
+		require.NoError(t, err)
+		assert.Equal(t, "example.com/a", report.Package)
+		assert.Equal(t, tc.Action, report.Action, tc.Output)
+		assert.Equal(t, tc.Results, report.Tests, tc.Output)
+		assert.Equal(t, tc.Panicked, report.Panicked, tc.Output)
+		assert.Equal(t, tc.TimedOut, report.TimedOut, tc.Output)
+	}
+}
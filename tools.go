@@ -15,7 +15,8 @@ import (
 	"sync"
 )
 
-// Tools provides interfaces to build tools.
+// Tools provides interfaces to build tools. Tools building packages using cgo
+// also implement Cgoer and CCompiler.
 type Tools interface {
 	Assembler
 	Compiler
@@ -26,21 +27,52 @@ type Tools interface {
 	BuildCtx() (gb.Context, error)
 }
 
+// cgoTools are Tools building packages using cgo.
+type cgoTools interface {
+	Tools
+	Cgoer
+	CCompiler
+}
+
+// withCgo returns tools as cgoTools, or an error if they do not implement
+// Cgoer and CCompiler.
+func withCgo(tools Tools) (cgoTools, error) {
+	if t, ok := tools.(cgoTools); ok {
+		return t, nil
+	}
+	return nil, fmt.Errorf("tools %T do not support cgo: Cgoer and CCompiler are not implemented", tools)
+}
+
 var (
 	DebugLog bool = false
 )
 
 var (
 	// DefaultTools uses tools provided by the current go runtime.
-	DefaultTools Tools = &cmdTools{
+	DefaultTools Tools = NewTools(Env{})
+)
+
+// NewTools returns the tools provided by the current go runtime, run with the
+// environment env.
+func NewTools(env Env) Tools {
+	return &cmdTools{
 		Go:        "go",
 		Assembler: path.Join(gb.ToolDir, "asm"),
 		Compiler:  path.Join(gb.ToolDir, "compile"),
 		Linker:    path.Join(gb.ToolDir, "link"),
 		Packer:    path.Join(gb.ToolDir, "pack"),
 		BuildIDer: path.Join(gb.ToolDir, "buildid"),
+		Cgoer:     path.Join(gb.ToolDir, "cgo"),
+		CCompiler: "gcc",
+		Env:       env,
 	}
-)
+}
+
+// envTools are Tools run with an Env of their own, merged with the Env of
+// each call.
+type envTools interface {
+	toolsEnv() Env
+}
 
 type cmdTools struct {
 	mutex sync.Mutex
@@ -57,11 +89,19 @@ type cmdTools struct {
 	PackerArgs    []string
 	BuildIDer     string
 	BuildIDerArgs []string
+	Cgoer         string
+	CgoerArgs     []string
+	CCompiler     string
+	CCompilerArgs []string
+	// Env is the environment policy of all tool invocations.
+	Env Env
 
 	version string
 }
 
-var envRegex = regexp.MustCompile(`([a-zA-Z0-9_]+)="(.*)"`)
+// envRegex matches the variables printed by "go env", quoted with '"' up to
+// go1.20 and with "'" since.
+var envRegex = regexp.MustCompile(`([a-zA-Z0-9_]+)=(?:"(.*)"|'(.*)')`)
 
 func (ct *cmdTools) BuildCtx() (gb.Context, error) {
 	ctx := gb.Default
@@ -71,6 +111,7 @@ func (ct *cmdTools) BuildCtx() (gb.Context, error) {
 	stdout := &bytes.Buffer{}
 	stderr := &bytes.Buffer{}
 	cmd := exec.Command(ct.Go, cmdArgs...)
+	cmd.Env = mergeEnv(ct.Env, Env{}).environ()
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
 	err := cmd.Run()
@@ -86,7 +127,7 @@ func (ct *cmdTools) BuildCtx() (gb.Context, error) {
 			continue
 		}
 		key := values[1]
-		value := values[2]
+		value := values[2] + values[3]
 		switch key {
 		case "GOOS":
 			ctx.GOOS = value
@@ -117,6 +158,7 @@ func (ct *cmdTools) Version() (string, error) {
 	stdout := &bytes.Buffer{}
 	stderr := &bytes.Buffer{}
 	cmd := exec.Command(ct.Go, cmdArgs...)
+	cmd.Env = mergeEnv(ct.Env, Env{}).environ()
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
 	err := cmd.Run()
@@ -128,36 +170,23 @@ func (ct *cmdTools) Version() (string, error) {
 	return ct.version, nil
 }
 
-func (ct *cmdTools) env(buildCtx gb.Context) []string {
-	env := os.Environ()
-	newEnv := make([]string, 0, len(env))
-	for _, v := range env {
-		switch {
-		case strings.HasPrefix(v, "GOARCH"):
-		case strings.HasPrefix(v, "GOOS"):
-		case strings.HasPrefix(v, "GOROOT"):
-		case strings.HasPrefix(v, "GOPATH"):
-		case strings.HasPrefix(v, "CGO_ENABLED"):
-		default:
-			newEnv = append(newEnv, v)
-		}
+func (ct *cmdTools) toolsEnv() Env {
+	return ct.Env
+}
+
+func (ct *cmdTools) env(buildCtx gb.Context, arch Arch, env Env) []string {
+	vars := mergeEnv(ct.Env, env).environ()
+	for _, v := range arch.env() {
+		vars = setEnv(vars, v)
 	}
-	newEnv = append(newEnv,
-		"GOARCH="+buildCtx.GOARCH,
-		"GOOS="+buildCtx.GOOS,
-		"GOROOT="+buildCtx.GOROOT,
-		"GOPATH="+buildCtx.GOPATH,
-	)
-	if buildCtx.CgoEnabled {
-		newEnv = append(newEnv, "CGO_ENABLED=1")
-	} else {
-		newEnv = append(newEnv, "CGO_ENABLED=0")
-	}
-	return newEnv
+	return contextEnv(vars, buildCtx)
 }
 
 func (ct *cmdTools) Assemble(args AssembleArgs) error {
-	cmdArgs := append([]string(nil), ct.AssemblerArgs...)
+	if err := args.Arch.Validate(args.Context.GOARCH); err != nil {
+		return err
+	}
+	cmdArgs := []string(nil)
 	if args.TrimPath != "" {
 		cmdArgs = append(cmdArgs, "-trimpath", args.TrimPath)
 	}
@@ -179,15 +208,27 @@ func (ct *cmdTools) Assemble(args AssembleArgs) error {
 	if args.DynamicLink {
 		cmdArgs = append(cmdArgs, "-dynlink")
 	}
+	if args.PackageImportPath != "" {
+		cmdArgs = append(cmdArgs, "-p", args.PackageImportPath)
+	}
+	if args.Std {
+		cmdArgs = append(cmdArgs, "-std")
+	}
 	for _, v := range args.Files {
 		cmdArgs = append(cmdArgs, v)
 	}
+	cmdArgs, cleanup, err := responseFileArgs(args.WorkingDirectory, cmdArgs)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	cmdArgs = append(append([]string(nil), ct.AssemblerArgs...), cmdArgs...)
 	if DebugLog {
 		fmt.Printf("cd %s\n", args.WorkingDirectory)
 		fmt.Printf("%s %s\n", ct.Assembler, strings.Join(cmdArgs, " "))
 	}
 	cmd := exec.Command(ct.Assembler, cmdArgs...)
-	cmd.Env = ct.env(args.Context)
+	cmd.Env = ct.env(args.Context, args.Arch, args.Env)
 	cmd.Dir = args.WorkingDirectory
 	cmd.Stdout = args.Stdout
 	cmd.Stderr = args.Stderr
@@ -195,7 +236,10 @@ func (ct *cmdTools) Assemble(args AssembleArgs) error {
 }
 
 func (ct *cmdTools) Compile(args CompileArgs) error {
-	cmdArgs := append([]string(nil), ct.CompilerArgs...)
+	if err := args.Arch.Validate(args.Context.GOARCH); err != nil {
+		return err
+	}
+	cmdArgs := []string(nil)
 	if args.TrimPath != "" {
 		cmdArgs = append(cmdArgs, "-trimpath", args.TrimPath)
 	}
@@ -223,6 +267,9 @@ func (ct *cmdTools) Compile(args CompileArgs) error {
 	if args.Concurrency != 0 {
 		cmdArgs = append(cmdArgs, "-D", strconv.Itoa(args.Concurrency))
 	}
+	if args.ASan {
+		cmdArgs = append(cmdArgs, "-asan")
+	}
 	if args.AsmHeaderFile != "" {
 		cmdArgs = append(cmdArgs, "-asmhdr", args.AsmHeaderFile)
 	}
@@ -232,6 +279,9 @@ func (ct *cmdTools) Compile(args CompileArgs) error {
 	if args.DynamicLink {
 		cmdArgs = append(cmdArgs, "-dynlink")
 	}
+	if args.EmbedConfigFile != "" {
+		cmdArgs = append(cmdArgs, "-embedcfg", args.EmbedConfigFile)
+	}
 	if args.GoVersion != "" {
 		cmdArgs = append(cmdArgs, "-goversion", args.GoVersion)
 	}
@@ -265,6 +315,9 @@ func (ct *cmdTools) Compile(args CompileArgs) error {
 	if args.Pack {
 		cmdArgs = append(cmdArgs, "-pack")
 	}
+	if args.ProfileFile != "" {
+		cmdArgs = append(cmdArgs, "-pgoprofile", args.ProfileFile)
+	}
 	if args.Race {
 		cmdArgs = append(cmdArgs, "-race")
 	}
@@ -283,12 +336,18 @@ func (ct *cmdTools) Compile(args CompileArgs) error {
 	for _, v := range args.Files {
 		cmdArgs = append(cmdArgs, v)
 	}
+	cmdArgs, cleanup, err := responseFileArgs(args.WorkingDirectory, cmdArgs)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	cmdArgs = append(append([]string(nil), ct.CompilerArgs...), cmdArgs...)
 	if DebugLog {
 		fmt.Printf("cd %s\n", args.WorkingDirectory)
 		fmt.Printf("%s %s\n", ct.Compiler, strings.Join(cmdArgs, " "))
 	}
 	cmd := exec.Command(ct.Compiler, cmdArgs...)
-	cmd.Env = ct.env(args.Context)
+	cmd.Env = ct.env(args.Context, args.Arch, args.Env)
 	cmd.Dir = args.WorkingDirectory
 	cmd.Stdout = args.Stdout
 	cmd.Stderr = args.Stderr
@@ -296,12 +355,15 @@ func (ct *cmdTools) Compile(args CompileArgs) error {
 }
 
 func (ct *cmdTools) Link(args LinkArgs) error {
-	cmdArgs := append([]string(nil), ct.LinkerArgs...)
+	if err := args.Validate(); err != nil {
+		return err
+	}
+	cmdArgs := []string(nil)
 	if args.EntrySymbolName != "" {
 		cmdArgs = append(cmdArgs, "-E", args.EntrySymbolName)
 	}
 	if args.HeaderType != "" {
-		cmdArgs = append(cmdArgs, "-H", args.HeaderType)
+		cmdArgs = append(cmdArgs, "-H", string(args.HeaderType))
 	}
 	if args.ELFDynamicLinker != "" {
 		cmdArgs = append(cmdArgs, "-I", args.ELFDynamicLinker)
@@ -312,11 +374,14 @@ func (ct *cmdTools) Link(args LinkArgs) error {
 	for _, v := range args.StringDefines {
 		cmdArgs = append(cmdArgs, "-X", v)
 	}
+	if args.ASan {
+		cmdArgs = append(cmdArgs, "-asan")
+	}
 	if args.BuildID != "" {
 		cmdArgs = append(cmdArgs, "-buildid", args.BuildID)
 	}
 	if args.BuildMode != "" {
-		cmdArgs = append(cmdArgs, "-buildmode", args.BuildMode)
+		cmdArgs = append(cmdArgs, "-buildmode", string(args.BuildMode))
 	}
 	if args.ExternalTar != "" {
 		cmdArgs = append(cmdArgs, "-extar", args.ExternalTar)
@@ -349,7 +414,7 @@ func (ct *cmdTools) Link(args LinkArgs) error {
 		cmdArgs = append(cmdArgs, "-libgcc", args.LibGCC)
 	}
 	if args.LinkMode != "" {
-		cmdArgs = append(cmdArgs, "-linkmode", args.LinkMode)
+		cmdArgs = append(cmdArgs, "-linkmode", string(args.LinkMode))
 	}
 	if args.LinkShared {
 		cmdArgs = append(cmdArgs, "-linkshared")
@@ -375,12 +440,21 @@ func (ct *cmdTools) Link(args LinkArgs) error {
 	for _, v := range args.Files {
 		cmdArgs = append(cmdArgs, v)
 	}
+	cmdArgs, cleanup, err := responseFileArgs(args.WorkingDirectory, cmdArgs)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	cmdArgs = append(append([]string(nil), ct.LinkerArgs...), cmdArgs...)
 	if DebugLog {
 		fmt.Printf("cd %s\n", args.WorkingDirectory)
 		fmt.Printf("%s %s\n", ct.Linker, strings.Join(cmdArgs, " "))
 	}
 	cmd := exec.Command(ct.Linker, cmdArgs...)
-	cmd.Env = ct.env(args.Context)
+	cmd.Env = ct.env(args.Context, args.Arch, args.Env)
+	if args.TrimGOROOT {
+		cmd.Env = setEnv(setEnv(cmd.Env, "GOROOT="), "GOROOT_FINAL=go")
+	}
 	cmd.Dir = args.WorkingDirectory
 	cmd.Stdout = args.Stdout
 	cmd.Stderr = args.Stderr
@@ -388,7 +462,7 @@ func (ct *cmdTools) Link(args LinkArgs) error {
 }
 
 func (ct *cmdTools) Pack(args PackArgs) error {
-	cmdArgs := append([]string(nil), ct.PackerArgs...)
+	cmdArgs := []string(nil)
 	op := ""
 	switch args.Op {
 	case AppendNew:
@@ -409,12 +483,18 @@ func (ct *cmdTools) Pack(args PackArgs) error {
 	for _, v := range args.Names {
 		cmdArgs = append(cmdArgs, v)
 	}
+	cmdArgs, cleanup, err := responseFileArgs(args.WorkingDirectory, cmdArgs)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	cmdArgs = append(append([]string(nil), ct.PackerArgs...), cmdArgs...)
 	if DebugLog {
 		fmt.Printf("cd %s\n", args.WorkingDirectory)
 		fmt.Printf("%s %s\n", ct.Packer, strings.Join(cmdArgs, " "))
 	}
 	cmd := exec.Command(ct.Packer, cmdArgs...)
-	cmd.Env = ct.env(args.Context)
+	cmd.Env = ct.env(args.Context, Arch{}, args.Env)
 	cmd.Dir = args.WorkingDirectory
 	cmd.Stdout = args.Stdout
 	cmd.Stderr = args.Stderr
@@ -433,7 +513,7 @@ func (ct *cmdTools) BuildID(args BuildIDArgs) (string, error) {
 	}
 	stdout := &bytes.Buffer{}
 	cmd := exec.Command(ct.BuildIDer, cmdArgs...)
-	cmd.Env = ct.env(args.Context)
+	cmd.Env = ct.env(args.Context, Arch{}, args.Env)
 	cmd.Dir = args.WorkingDirectory
 	cmd.Stdout = stdout
 	cmd.Stderr = args.Stderr
@@ -443,3 +523,84 @@ func (ct *cmdTools) BuildID(args BuildIDArgs) (string, error) {
 	}
 	return strings.TrimSpace(stdout.String()), nil
 }
+
+func (ct *cmdTools) Cgo(args CgoArgs) error {
+	if err := args.Arch.Validate(args.Context.GOARCH); err != nil {
+		return err
+	}
+	cmdArgs := []string(nil)
+	if args.ObjectDir != "" {
+		cmdArgs = append(cmdArgs, "-objdir", args.ObjectDir)
+	}
+	if args.ImportPath != "" {
+		cmdArgs = append(cmdArgs, "-importpath", args.ImportPath)
+	}
+	if args.ExportHeader != "" {
+		cmdArgs = append(cmdArgs, "-exportheader", args.ExportHeader)
+	}
+	if args.TrimPath != "" {
+		cmdArgs = append(cmdArgs, "-trimpath", args.TrimPath)
+	}
+	if args.LDFlags != "" {
+		cmdArgs = append(cmdArgs, "-ldflags", args.LDFlags)
+	}
+	if args.NoImportRuntimeCgo {
+		cmdArgs = append(cmdArgs, "-import_runtime_cgo=false")
+	}
+	if args.NoImportSyscall {
+		cmdArgs = append(cmdArgs, "-import_syscall=false")
+	}
+	cmdArgs = append(cmdArgs, "--")
+	for _, v := range args.CFlags {
+		cmdArgs = append(cmdArgs, v)
+	}
+	for _, v := range args.Files {
+		cmdArgs = append(cmdArgs, v)
+	}
+	cmdArgs, cleanup, err := responseFileArgs(args.WorkingDirectory, cmdArgs)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	cmdArgs = append(append([]string(nil), ct.CgoerArgs...), cmdArgs...)
+	if DebugLog {
+		fmt.Printf("cd %s\n", args.WorkingDirectory)
+		fmt.Printf("%s %s\n", ct.Cgoer, strings.Join(cmdArgs, " "))
+	}
+	cmd := exec.Command(ct.Cgoer, cmdArgs...)
+	cmd.Env = ct.env(args.Context, args.Arch, args.Env)
+	cmd.Dir = args.WorkingDirectory
+	cmd.Stdout = args.Stdout
+	cmd.Stderr = args.Stderr
+	return cmd.Run()
+}
+
+func (ct *cmdTools) CCompile(args CCompileArgs) error {
+	cmdArgs := append([]string(nil), ct.CCompilerArgs...)
+	cmdArgs = append(cmdArgs, "-c")
+	for _, v := range args.Flags {
+		cmdArgs = append(cmdArgs, v)
+	}
+	for _, v := range args.IncludeDirs {
+		cmdArgs = append(cmdArgs, "-I", v)
+	}
+	for _, v := range args.Defines {
+		cmdArgs = append(cmdArgs, "-D", v)
+	}
+	if args.OutputFile != "" {
+		cmdArgs = append(cmdArgs, "-o", args.OutputFile)
+	}
+	for _, v := range args.Files {
+		cmdArgs = append(cmdArgs, v)
+	}
+	if DebugLog {
+		fmt.Printf("cd %s\n", args.WorkingDirectory)
+		fmt.Printf("%s %s\n", ct.CCompiler, strings.Join(cmdArgs, " "))
+	}
+	cmd := exec.Command(ct.CCompiler, cmdArgs...)
+	cmd.Env = ct.env(args.Context, Arch{}, args.Env)
+	cmd.Dir = args.WorkingDirectory
+	cmd.Stdout = args.Stdout
+	cmd.Stderr = args.Stderr
+	return cmd.Run()
+}
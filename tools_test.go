@@ -5,6 +5,7 @@ import (
 	"fmt"
 	gb "go/build"
 	"os"
+	"os/exec"
 	"strings"
 	"testing"
 
@@ -26,17 +27,19 @@ func TestAssembler(t *testing.T) {
 					GOROOT:     "go/root",
 					CgoEnabled: true,
 				},
-				Stdout:      &bytes.Buffer{},
-				TrimPath:    "tp",
-				OutputFile:  "of",
-				IncludeDirs: []string{"DirA", "DirB"},
-				Defines:     []string{"A", "B"},
-				GenSymABIs:  true,
-				Shared:      true,
-				DynamicLink: true,
-				Files:       []string{"a", "b", "c"},
+				Stdout:            &bytes.Buffer{},
+				TrimPath:          "tp",
+				OutputFile:        "of",
+				IncludeDirs:       []string{"DirA", "DirB"},
+				Defines:           []string{"A", "B"},
+				GenSymABIs:        true,
+				Shared:            true,
+				DynamicLink:       true,
+				PackageImportPath: "pip",
+				Std:               true,
+				Files:             []string{"a", "b", "c"},
 			},
-			"-trimpath tp -o of -I DirA -I DirB -D A -D B -gensymabis -shared -dynlink a b c goos goarch go/path go/root 1",
+			"-trimpath tp -o of -I DirA -I DirB -D A -D B -gensymabis -shared -dynlink -p pip -std a b c goos goarch go/path go/root 1",
 		},
 		{
 			build.AssembleArgs{
@@ -105,9 +108,11 @@ func TestCompiler(t *testing.T) {
 				RelativeImportPath:       "rip",
 				IncludeDirs:              []string{"includeDirA", "includeDirB"},
 				Concurrency:              5,
+				ASan:                     true,
 				AsmHeaderFile:            "aho",
 				Complete:                 true,
 				DynamicLink:              true,
+				EmbedConfigFile:          "ecf",
 				GoVersion:                "",
 				HaltOnError:              true,
 				ImportConfigFile:         "icf",
@@ -119,6 +124,7 @@ func TestCompiler(t *testing.T) {
 				NoLocalImports:           true,
 				PackageImportPath:        "pip",
 				Pack:                     true,
+				ProfileFile:              "pf",
 				Race:                     true,
 				Shared:                   true,
 				SmallFrames:              true,
@@ -126,7 +132,7 @@ func TestCompiler(t *testing.T) {
 				SymABIsFile:              "saf",
 				Files:                    []string{"a", "b", "c"},
 			},
-			"-trimpath tp -o of -buildid buildid -B -+ -N -D rip -I includeDirA -I includeDirB -D 5 -asmhdr aho -complete -dynlink -h -importcfg icf -importmap importMapA -importmap importMapB -l -linkobj loof -msan -nolocalimports -p pip -pack -race -shared -smallframes -std -symabis saf a b c goos goarch go/path go/root 1",
+			"-trimpath tp -o of -buildid buildid -B -+ -N -D rip -I includeDirA -I includeDirB -D 5 -asan -asmhdr aho -complete -dynlink -embedcfg ecf -h -importcfg icf -importmap importMapA -importmap importMapB -l -linkobj loof -msan -nolocalimports -p pip -pack -pgoprofile pf -race -shared -smallframes -std -symabis saf a b c goos goarch go/path go/root 1",
 		},
 		{
 			build.CompileArgs{
@@ -187,12 +193,13 @@ func TestLinker(t *testing.T) {
 				},
 				Stdout:                     &bytes.Buffer{},
 				EntrySymbolName:            "esn",
-				HeaderType:                 "ht",
+				HeaderType:                 build.HeaderTypeLinux,
 				ELFDynamicLinker:           "edl",
 				LibraryPaths:               []string{"lpa", "lpb"},
 				StringDefines:              []string{"sda", "sdb"},
+				ASan:                       true,
 				BuildID:                    "bi",
-				BuildMode:                  "bm",
+				BuildMode:                  build.BuildModeExe,
 				ExternalTar:                "et",
 				ExternalLinker:             "el",
 				ExternalLinkerFlags:        "elf",
@@ -203,7 +210,7 @@ func TestLinker(t *testing.T) {
 				InstallSuffix:              "is",
 				FieldTrackingSymbol:        "fts",
 				LibGCC:                     "lgcc",
-				LinkMode:                   "lm",
+				LinkMode:                   build.LinkModeExternal,
 				LinkShared:                 true,
 				MSan:                       true,
 				OutputFile:                 "of",
@@ -213,7 +220,7 @@ func TestLinker(t *testing.T) {
 				RejectUnsafePackages:       true,
 				Files:                      []string{"a", "b", "c"},
 			},
-			"-E esn -H ht -I edl -L lpa -L lpb -X sda -X sdb -buildid bi -buildmode bm -extar et -extld el -extldflags elf -f -g -h -importcfg icf -installsuffix is -k fts -libgcc lgcc -linkmode lm -linkshared -msan -o of -pluginpath pp -race -tmpdir td -u a b c goos goarch go/path go/root 1",
+			"-E esn -H linux -I edl -L lpa -L lpb -X sda -X sdb -asan -buildid bi -buildmode exe -extar et -extld el -extldflags elf -f -g -h -importcfg icf -installsuffix is -k fts -libgcc lgcc -linkmode external -linkshared -msan -o of -pluginpath pp -race -tmpdir td -u a b c goos goarch go/path go/root 1",
 		},
 		{
 			build.LinkArgs{
@@ -228,6 +235,20 @@ func TestLinker(t *testing.T) {
 			},
 			"goos goarch go/path go/root 1",
 		},
+		{
+			build.LinkArgs{
+				Context: gb.Context{
+					GOOS:       "goos",
+					GOARCH:     "goarch",
+					GOPATH:     "go/path",
+					GOROOT:     "go/root",
+					CgoEnabled: true,
+				},
+				Stdout:     &bytes.Buffer{},
+				TrimGOROOT: true,
+			},
+			"goos goarch go/path  1 go",
+		},
 	}
 	if os.Getenv("TEST_SUBPROCESS") == "1" {
 		args := []string(nil)
@@ -241,6 +262,9 @@ func TestLinker(t *testing.T) {
 		args = append(args, os.Getenv("GOPATH"))
 		args = append(args, os.Getenv("GOROOT"))
 		args = append(args, os.Getenv("CGO_ENABLED"))
+		if v, ok := os.LookupEnv("GOROOT_FINAL"); ok {
+			args = append(args, v)
+		}
 		fmt.Fprint(os.Stdout, strings.Join(args, " "))
 		os.Exit(0)
 	} else {
@@ -485,6 +509,19 @@ func TestBuildID(t *testing.T) {
 }
 
 func TestBuildCtx(t *testing.T) {
+	if os.Getenv("TEST_SUBPROCESS") == "1" && os.Getenv("TEST_GOENV") == "go1.21" {
+		fmt.Fprint(os.Stdout, `AR='ar'
+CC='gcc'
+CGO_ENABLED='1'
+GOARCH='arm64'
+GOOS='darwin'
+GOPATH='/Users/testuser/go'
+GOROOT='/usr/local/go1.21'
+GOVERSION='go1.21.0'
+`)
+		os.Exit(0)
+		return
+	}
 	if os.Getenv("TEST_SUBPROCESS") == "1" {
 		fmt.Fprint(os.Stdout, `GO111MODULE=""
 GOARCH="amd64"
@@ -534,4 +571,162 @@ GOGCCFLAGS="-fPIC -m64 -pthread -fmessage-length=0 -fdebug-prefix-map=/tmp/go-bu
 	assert.Equal(t, "amd64", ctx.GOARCH)
 	assert.Equal(t, "/home/testuser/go", ctx.GOPATH)
 	assert.Equal(t, "/usr/local/go1.14", ctx.GOROOT)
+
+	os.Setenv("TEST_GOENV", "go1.21")
+	defer os.Unsetenv("TEST_GOENV")
+	ctx, err = tools.BuildCtx()
+	assert.NoError(t, err)
+	assert.Equal(t, "darwin", ctx.GOOS)
+	assert.Equal(t, "arm64", ctx.GOARCH)
+	assert.Equal(t, "/Users/testuser/go", ctx.GOPATH)
+	assert.Equal(t, "/usr/local/go1.21", ctx.GOROOT)
+}
+
+func TestNewTools(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	tools := build.NewTools(build.Env{Vars: []string{"GOOS=plan9", "GOARCH=arm"}})
+	ctx, err := tools.BuildCtx()
+	assert.NoError(t, err)
+	assert.Equal(t, "plan9", ctx.GOOS)
+	assert.Equal(t, "arm", ctx.GOARCH)
+}
+
+func TestCgoer(t *testing.T) {
+	testCases := []struct {
+		Args     build.CgoArgs
+		Expected string
+	}{
+		{
+			build.CgoArgs{
+				Context: gb.Context{
+					GOOS:       "goos",
+					GOARCH:     "goarch",
+					GOPATH:     "go/path",
+					GOROOT:     "go/root",
+					CgoEnabled: true,
+				},
+				Stdout:             &bytes.Buffer{},
+				ObjectDir:          "od",
+				ImportPath:         "ip",
+				ExportHeader:       "eh",
+				TrimPath:           "tp",
+				LDFlags:            "-lm",
+				NoImportRuntimeCgo: true,
+				NoImportSyscall:    true,
+				CFlags:             []string{"-g", "-O2"},
+				Files:              []string{"a", "b", "c"},
+			},
+			"-objdir od -importpath ip -exportheader eh -trimpath tp -ldflags -lm -import_runtime_cgo=false -import_syscall=false -- -g -O2 a b c goos goarch go/path go/root 1",
+		},
+		{
+			build.CgoArgs{
+				Context: gb.Context{
+					GOOS:       "goos",
+					GOARCH:     "goarch",
+					GOPATH:     "go/path",
+					GOROOT:     "go/root",
+					CgoEnabled: true,
+				},
+				Stdout: &bytes.Buffer{},
+			},
+			"-- goos goarch go/path go/root 1",
+		},
+	}
+	if os.Getenv("TEST_SUBPROCESS") == "1" {
+		args := []string(nil)
+		for i, v := range os.Args {
+			if v == "--" {
+				args = os.Args[i+1:]
+				break
+			}
+		}
+		args = append(args, os.Getenv("GOOS"))
+		args = append(args, os.Getenv("GOARCH"))
+		args = append(args, os.Getenv("GOPATH"))
+		args = append(args, os.Getenv("GOROOT"))
+		args = append(args, os.Getenv("CGO_ENABLED"))
+		fmt.Fprint(os.Stdout, strings.Join(args, " "))
+		os.Exit(0)
+	} else {
+		os.Setenv("TEST_SUBPROCESS", "1")
+		defer os.Setenv("TEST_SUBPROCESS", "")
+		for c, tc := range testCases {
+			tools := build.NewCmdTools()
+			tools.Cgoer = os.Args[0]
+			tools.CgoerArgs = []string{"-test.run=TestCgoer", "--"}
+			err := tools.Cgo(tc.Args)
+			assert.NoError(t, err)
+			out := tc.Args.Stdout.(*bytes.Buffer)
+			assert.Equalf(t, tc.Expected, out.String(), "failed with case %d", c)
+		}
+	}
+}
+
+func TestCCompiler(t *testing.T) {
+	testCases := []struct {
+		Args     build.CCompileArgs
+		Expected string
+	}{
+		{
+			build.CCompileArgs{
+				Context: gb.Context{
+					GOOS:       "goos",
+					GOARCH:     "goarch",
+					GOPATH:     "go/path",
+					GOROOT:     "go/root",
+					CgoEnabled: true,
+				},
+				Stdout:      &bytes.Buffer{},
+				Flags:       []string{"-fPIC", "-m64"},
+				IncludeDirs: []string{"DirA", "DirB"},
+				Defines:     []string{"A", "B"},
+				OutputFile:  "of",
+				Files:       []string{"a.c"},
+			},
+			"-c -fPIC -m64 -I DirA -I DirB -D A -D B -o of a.c goos goarch go/path go/root 1",
+		},
+		{
+			build.CCompileArgs{
+				Context: gb.Context{
+					GOOS:       "goos",
+					GOARCH:     "goarch",
+					GOPATH:     "go/path",
+					GOROOT:     "go/root",
+					CgoEnabled: true,
+				},
+				Stdout: &bytes.Buffer{},
+			},
+			"-c goos goarch go/path go/root 1",
+		},
+	}
+	if os.Getenv("TEST_SUBPROCESS") == "1" {
+		args := []string(nil)
+		for i, v := range os.Args {
+			if v == "--" {
+				args = os.Args[i+1:]
+				break
+			}
+		}
+		args = append(args, os.Getenv("GOOS"))
+		args = append(args, os.Getenv("GOARCH"))
+		args = append(args, os.Getenv("GOPATH"))
+		args = append(args, os.Getenv("GOROOT"))
+		args = append(args, os.Getenv("CGO_ENABLED"))
+		fmt.Fprint(os.Stdout, strings.Join(args, " "))
+		os.Exit(0)
+	} else {
+		os.Setenv("TEST_SUBPROCESS", "1")
+		defer os.Setenv("TEST_SUBPROCESS", "")
+		for c, tc := range testCases {
+			tools := build.NewCmdTools()
+			tools.CCompiler = os.Args[0]
+			tools.CCompilerArgs = []string{"-test.run=TestCCompiler", "--"}
+			err := tools.CCompile(tc.Args)
+			assert.NoError(t, err)
+			out := tc.Args.Stdout.(*bytes.Buffer)
+			assert.Equalf(t, tc.Expected, out.String(), "failed with case %d", c)
+		}
+	}
 }
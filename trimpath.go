@@ -0,0 +1,94 @@
+package build
+
+import (
+	gb "go/build"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// TrimPathRule rewrites the file paths under Prefix to start with
+// Replacement instead.
+type TrimPathRule struct {
+	Prefix      string
+	Replacement string
+}
+
+// TrimPathRules are the path rewrites of the compiler and assembler
+// "-trimpath" flag. The first rule matching a path applies.
+type TrimPathRules []TrimPathRule
+
+// NewTrimPathRules returns the rules removing the machine specific
+// directories of a build: the module roots, replaced by the module path and
+// version, then the source directories of GOROOT and GOPATH and finally the
+// working directory dir, if not empty. modules maps the root directories of
+// the modules to their module.
+func NewTrimPathRules(ctx gb.Context, modules map[string]Module, dir string) TrimPathRules {
+	rules := TrimPathRules(nil)
+	roots := []string(nil)
+	for k := range modules {
+		roots = append(roots, k)
+	}
+	// Nested modules first.
+	sort.Slice(roots, func(i, j int) bool { return len(roots[i]) > len(roots[j]) })
+	for _, v := range roots {
+		m := modules[v]
+		replacement := m.Path
+		if m.Version != "" {
+			replacement += "@" + m.Version
+		}
+		rules = rules.Add(v, replacement)
+	}
+	if ctx.GOROOT != "" {
+		rules = rules.Add(filepath.Join(ctx.GOROOT, "src"), "")
+	}
+	for _, v := range filepath.SplitList(ctx.GOPATH) {
+		if v != "" && v != ctx.GOROOT {
+			rules = rules.Add(filepath.Join(v, "src"), "")
+		}
+	}
+	if dir != "" {
+		rules = rules.Add(dir, "")
+	}
+	return rules
+}
+
+// Add returns the rules with the rewrite of prefix to replacement appended.
+func (r TrimPathRules) Add(prefix, replacement string) TrimPathRules {
+	return append(r, TrimPathRule{
+		Prefix:      strings.TrimSuffix(prefix, string(filepath.Separator)),
+		Replacement: replacement,
+	})
+}
+
+// Apply rewrites file with the first matching rule, like the compiler. It
+// reports whether a rule matched.
+func (r TrimPathRules) Apply(file string) (string, bool) {
+	for _, v := range r {
+		if !strings.HasPrefix(file, v.Prefix) {
+			continue
+		}
+		rest := file[len(v.Prefix):]
+		if rest == "" {
+			return v.Replacement, true
+		}
+		if rest[0] != '/' && rest[0] != '\\' {
+			continue
+		}
+		if v.Replacement == "" {
+			return rest[1:], true
+		}
+		return v.Replacement + "/" + filepath.ToSlash(rest[1:]), true
+	}
+	return file, false
+}
+
+// String returns the rules in the "-trimpath" syntax:
+// "prefix=>replacement" rewrites separated by ";".
+func (r TrimPathRules) String() string {
+	rules := []string(nil)
+	for _, v := range r {
+		rules = append(rules, v.Prefix+"=>"+v.Replacement)
+	}
+	return strings.Join(rules, ";")
+}
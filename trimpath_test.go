@@ -0,0 +1,74 @@
+package build_test
+
+import (
+	gb "go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/gophertest/build/buildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTrimPathRules(t *testing.T) {
+	ctx := gb.Context{GOROOT: "/goroot", GOPATH: "/gopath1" + string(filepath.ListSeparator) + "/gopath2", Compiler: "gc"}
+	modules := map[string]build.Module{
+		"/src/mod":        {Path: "example.com/mod"},
+		"/src/mod/nested": {Path: "example.com/nested", Version: "v1.2.0"},
+	}
+	rules := build.NewTrimPathRules(ctx, modules, "/work/")
+	assert.Equal(t, "/src/mod/nested=>example.com/nested@v1.2.0;/src/mod=>example.com/mod;/goroot/src=>;/gopath1/src=>;/gopath2/src=>;/work=>", rules.String())
+	assert.Equal(t, "", build.NewTrimPathRules(gb.Context{}, nil, "").String())
+}
+
+func TestTrimPathRulesApply(t *testing.T) {
+	rules := build.TrimPathRules{}.
+		Add("/src/mod/nested", "example.com/nested@v1.2.0").
+		Add("/src/mod", "example.com/mod").
+		Add("/goroot/src", "").
+		Add("/work", "")
+	testCases := []struct {
+		File     string
+		Expected string
+		Matched  bool
+	}{
+		{"/src/mod/a.go", "example.com/mod/a.go", true},
+		{"/src/mod/nested/b/b.go", "example.com/nested@v1.2.0/b/b.go", true},
+		{"/src/mod", "example.com/mod", true},
+		{"/src/module/a.go", "/src/module/a.go", false},
+		{"/goroot/src/runtime/proc.go", "runtime/proc.go", true},
+		{"/work/main.go", "main.go", true},
+		{"/other/main.go", "/other/main.go", false},
+	}
+	for _, tc := range testCases {
+		file, ok := rules.Apply(tc.File)
+		assert.Equal(t, tc.Expected, file, tc.File)
+		assert.Equal(t, tc.Matched, ok, tc.File)
+	}
+}
+
+func TestBuilderTrimPath(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	b := &build.Builder{Tools: ft, Context: ctx, TrimPath: true}
+	dir, err := ioutil.TempDir("", "trimpath")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	_, err = b.Build("example.com/hello", "", filepath.Join(dir, "hello"))
+	require.NoError(t, err)
+
+	gopath := filepath.Join(ctx.GOPATH, "src")
+	for _, v := range ft.CompileCalls() {
+		assert.Contains(t, v.TrimPath, ";"+gopath+"=>", v.PackageImportPath)
+		assert.NotContains(t, v.TrimPath, v.WorkingDirectory+"=>example.com", v.PackageImportPath)
+	}
+	links := ft.LinkCalls()
+	require.Len(t, links, 1)
+	assert.True(t, links[0].TrimGOROOT)
+}
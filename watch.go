@@ -0,0 +1,200 @@
+package build
+
+import (
+	"context"
+	gb "go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Watcher rebuilds a package whenever the files of its source directories
+// change, polling them. Only the packages made stale by a change are
+// compiled again.
+type Watcher struct {
+	// Builder used for every build. A temporary CacheDir removed when the
+	// watch ends is used if it has neither WorkDir nor CacheDir.
+	Builder Builder
+	// ImportPath and SrcDir of the package to build, as passed to Build.
+	ImportPath string
+	SrcDir     string
+	// OutputFile the command is linked to.
+	OutputFile string
+	// Interval between polls of the source directories, 500ms if zero.
+	Interval time.Duration
+}
+
+// WatchEvent reports a build of a Watcher.
+type WatchEvent struct {
+	// Changed are the files created, modified or removed since the previous
+	// build, sorted, empty for the first build.
+	Changed []string
+	// Result of the build, if the package could be loaded.
+	Result *Result
+	// Compiled are the import paths of the packages compiled by the build,
+	// the others were up to date.
+	Compiled []string
+	// Err is the error of the build, if any.
+	Err error
+}
+
+// Watch builds the package and then rebuilds it on every change of the
+// source directories of its plan or of the files they embed, until ctx is
+// done. The packages of GOROOT are not watched. A package that cannot be
+// found is looked for again on every poll. The events are sent on the returned channel, closed once
+// the watch has ended.
+func (w *Watcher) Watch(ctx context.Context) (<-chan WatchEvent, error) {
+	b := w.Builder
+	cleanup := func() {}
+	if b.WorkDir == "" && b.CacheDir == "" {
+		dir, err := ioutil.TempDir("", "gophertest-watch")
+		if err != nil {
+			return nil, err
+		}
+		b.CacheDir = dir
+		cleanup = func() { os.RemoveAll(dir) }
+	}
+	interval := w.Interval
+	if interval == 0 {
+		interval = 500 * time.Millisecond
+	}
+
+	events := make(chan WatchEvent)
+	go func() {
+		defer close(events)
+		defer cleanup()
+		dirs := []string(nil)
+		changed := []string(nil)
+		for {
+			event := WatchEvent{Changed: changed}
+			plan, err := b.Load(w.ImportPath, w.SrcDir)
+			if err == nil {
+				dirs = watchDirs(plan)
+			} else if dirs == nil {
+				dirs = w.packageDirs(&b)
+			}
+			// Snapshot before building so that changes made during the
+			// build trigger another one.
+			files := snapshotDirs(dirs)
+			if err != nil {
+				event.Err = err
+			} else {
+				event.Result, event.Err = b.BuildPlan(plan, w.OutputFile)
+				for _, p := range plan.Packages {
+					if p.Archive != "" && !p.Cached {
+						event.Compiled = append(event.Compiled, p.ImportPath)
+					}
+				}
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+
+			for changed = nil; len(changed) == 0; {
+				select {
+				case <-time.After(interval):
+				case <-ctx.Done():
+					return
+				}
+				if dirs == nil {
+					// Nothing is watched until the package is found.
+					if dirs = w.packageDirs(&b); dirs != nil {
+						break
+					}
+					continue
+				}
+				changed = diffSnapshots(files, snapshotDirs(dirs))
+			}
+		}
+	}()
+	return events, nil
+}
+
+// packageDirs returns the directories to watch for the package, those of its
+// plan if it loads, its own if it is only found, or nil.
+func (w *Watcher) packageDirs(b *Builder) []string {
+	if plan, err := b.Load(w.ImportPath, w.SrcDir); err == nil {
+		return watchDirs(plan)
+	}
+	if pkg, err := b.Context.Import(w.ImportPath, w.SrcDir, gb.FindOnly); err == nil {
+		return []string{pkg.Dir}
+	}
+	return nil
+}
+
+// watchDirs returns the source directories of the packages of plan outside
+// GOROOT, and the directories of the files they embed.
+func watchDirs(plan *Plan) []string {
+	dirs := []string(nil)
+	seen := map[string]bool{}
+	add := func(dir string) {
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	for _, p := range plan.Packages {
+		if p.Goroot {
+			continue
+		}
+		add(p.Dir)
+		// Patterns matching no file fail the build, leaving the package
+		// directory to watch.
+		if embed, err := NewEmbedConfig(p.Package); err == nil && embed != nil {
+			files := []string(nil)
+			for _, v := range embed.Files {
+				files = append(files, v)
+			}
+			sort.Strings(files)
+			for _, v := range files {
+				add(filepath.Dir(v))
+			}
+		}
+	}
+	return dirs
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// snapshotDirs returns the state of the files of dirs, keyed by path.
+// Unreadable directories are empty.
+func snapshotDirs(dirs []string) map[string]fileState {
+	files := map[string]fileState{}
+	for _, dir := range dirs {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, v := range infos {
+			if !v.IsDir() {
+				files[filepath.Join(dir, v.Name())] = fileState{v.ModTime(), v.Size()}
+			}
+		}
+	}
+	return files
+}
+
+// diffSnapshots returns the sorted paths of the files created, modified or
+// removed between two snapshots.
+func diffSnapshots(old, new map[string]fileState) []string {
+	changed := []string(nil)
+	for k, v := range new {
+		if o, ok := old[k]; !ok || !o.modTime.Equal(v.modTime) || o.size != v.size {
+			changed = append(changed, k)
+		}
+	}
+	for k := range old {
+		if _, ok := new[k]; !ok {
+			changed = append(changed, k)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
@@ -0,0 +1,173 @@
+package build_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gophertest/build"
+	"github.com/gophertest/build/buildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func nextEvent(t *testing.T, events <-chan build.WatchEvent) build.WatchEvent {
+	select {
+	case event, ok := <-events:
+		require.True(t, ok, "events closed")
+		return event
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "no build")
+	}
+	return build.WatchEvent{}
+}
+
+func TestWatcher(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+	gopath, err := ioutil.TempDir("", "watch")
+	require.NoError(t, err)
+	defer os.RemoveAll(gopath)
+	ctx.GOPATH = gopath
+	src := filepath.Join(gopath, "src", "example.com", "watched")
+	write := func(file, content string) string {
+		file = filepath.Join(src, file)
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0777))
+		require.NoError(t, ioutil.WriteFile(file, []byte(content), 0666))
+		return file
+	}
+	write("main.go", "package main\n\nimport \"example.com/watched/lib\"\n\nfunc main() { lib.Hello() }\n")
+	lib := write("lib/lib.go", "package lib\n\nfunc Hello() {}\n")
+
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	w := &build.Watcher{
+		Builder:    build.Builder{Tools: ft, Context: ctx},
+		ImportPath: "example.com/watched",
+		OutputFile: filepath.Join(gopath, "bin", "watched"),
+		Interval:   10 * time.Millisecond,
+	}
+	watchCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := w.Watch(watchCtx)
+	require.NoError(t, err)
+
+	event := nextEvent(t, events)
+	require.NoError(t, event.Err)
+	assert.Empty(t, event.Changed)
+	assert.Contains(t, event.Compiled, "example.com/watched/lib")
+	assert.Contains(t, event.Compiled, "example.com/watched")
+	assert.Contains(t, event.Compiled, "runtime")
+	assert.FileExists(t, w.OutputFile)
+
+	// Only the changed package and its dependents are compiled again.
+	write("lib/lib.go", "package lib\n\nfunc Hello() { println() }\n")
+	event = nextEvent(t, events)
+	require.NoError(t, event.Err)
+	assert.Equal(t, []string{lib}, event.Changed)
+	assert.Equal(t, []string{"example.com/watched/lib", "example.com/watched"}, event.Compiled)
+	assert.Len(t, ft.LinkCalls(), 2)
+
+	bad := write("lib/bad.go", "package other\n")
+	event = nextEvent(t, events)
+	assert.Equal(t, []string{bad}, event.Changed)
+	assert.Error(t, event.Err)
+	assert.Nil(t, event.Result)
+
+	require.NoError(t, os.Remove(bad))
+	event = nextEvent(t, events)
+	require.NoError(t, event.Err)
+	assert.Equal(t, []string{bad}, event.Changed)
+	assert.Empty(t, event.Compiled)
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		assert.False(t, ok)
+	case <-time.After(10 * time.Second):
+		assert.Fail(t, "events not closed")
+	}
+}
+
+func TestWatcherEmbed(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+	gopath, err := ioutil.TempDir("", "watch")
+	require.NoError(t, err)
+	defer os.RemoveAll(gopath)
+	ctx.GOPATH = gopath
+	src := filepath.Join(gopath, "src", "example.com", "watched")
+	write := func(file, content string) string {
+		file = filepath.Join(src, file)
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0777))
+		require.NoError(t, ioutil.WriteFile(file, []byte(content), 0666))
+		return file
+	}
+	write("main.go", "package main\n\nimport \"embed\"\n\n//go:embed static/*\nvar static embed.FS\n\nfunc main() {}\n")
+	write("static/a.txt", "a")
+
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	w := &build.Watcher{
+		Builder:    build.Builder{Tools: ft, Context: ctx},
+		ImportPath: "example.com/watched",
+		OutputFile: filepath.Join(gopath, "bin", "watched"),
+		Interval:   10 * time.Millisecond,
+	}
+	watchCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := w.Watch(watchCtx)
+	require.NoError(t, err)
+	event := nextEvent(t, events)
+	require.NoError(t, event.Err)
+
+	// The embedded files and their directories are watched.
+	a := write("static/a.txt", "changed")
+	event = nextEvent(t, events)
+	require.NoError(t, event.Err)
+	assert.Equal(t, []string{a}, event.Changed)
+	assert.Equal(t, []string{"example.com/watched"}, event.Compiled)
+
+	b := write("static/b.txt", "b")
+	event = nextEvent(t, events)
+	require.NoError(t, event.Err)
+	assert.Equal(t, []string{b}, event.Changed)
+	assert.Equal(t, []string{"example.com/watched"}, event.Compiled)
+}
+
+func TestWatcherNotFound(t *testing.T) {
+	ctx, restore := testContext(t)
+	defer restore()
+	gopath, err := ioutil.TempDir("", "watch")
+	require.NoError(t, err)
+	defer os.RemoveAll(gopath)
+	ctx.GOPATH = gopath
+
+	ft := buildtest.NewFakeTools()
+	ft.WriteOutputs = true
+	w := &build.Watcher{
+		Builder:    build.Builder{Tools: ft, Context: ctx},
+		ImportPath: "example.com/later",
+		OutputFile: filepath.Join(gopath, "bin", "later"),
+		Interval:   10 * time.Millisecond,
+	}
+	watchCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := w.Watch(watchCtx)
+	require.NoError(t, err)
+	event := nextEvent(t, events)
+	assert.Error(t, event.Err)
+
+	// The package is looked for until it exists, moved in complete.
+	tmp := filepath.Join(gopath, "later")
+	require.NoError(t, os.MkdirAll(tmp, 0777))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tmp, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0666))
+	require.NoError(t, os.MkdirAll(filepath.Join(gopath, "src", "example.com"), 0777))
+	require.NoError(t, os.Rename(tmp, filepath.Join(gopath, "src", "example.com", "later")))
+	event = nextEvent(t, events)
+	require.NoError(t, event.Err)
+	assert.Contains(t, event.Compiled, "example.com/later")
+}